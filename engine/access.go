@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/recwatch"
 )
 
 // CommonLogFormat returns a line with the data that is available at the start
@@ -21,11 +24,7 @@ func (ac *Config) CommonLogFormat(req *http.Request, statusCode int, byteSize in
 	if ac.perm != nil {
 		username = ac.perm.UserState().Username(req)
 	}
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
-	ip := host
-	if err != nil {
-		ip = req.RemoteAddr
-	}
+	ip := ac.ClientIP(req)
 	statusCodeString := "-"
 	if statusCode > 0 {
 		statusCodeString = strconv.Itoa(statusCode)
@@ -47,11 +46,7 @@ func (ac *Config) CombinedLogFormat(req *http.Request, statusCode int, byteSize
 	if ac.perm != nil {
 		username = ac.perm.UserState().Username(req)
 	}
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
-	ip := host
-	if err != nil {
-		ip = req.RemoteAddr
-	}
+	ip := ac.ClientIP(req)
 	statusCodeString := "-"
 	if statusCode > 0 {
 		statusCodeString = strconv.Itoa(statusCode)
@@ -66,9 +61,76 @@ func (ac *Config) CombinedLogFormat(req *http.Request, statusCode int, byteSize
 	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %s %s \"%s\" \"%s\"", ip, username, timestamp, req.Method, req.RequestURI, req.Proto, statusCodeString, byteSizeString, referer, userAgent)
 }
 
+// StatusWriter is a http.ResponseWriter that keeps track of the HTTP status
+// code that is written, for the access logs
+type StatusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// NewStatusWriter wraps the given http.ResponseWriter
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+// WriteHeader records the status code before writing it
+func (sw *StatusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the given data. The status code is 200 if not already written.
+func (sw *StatusWriter) Write(data []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(data)
+}
+
+// Flush sends the data that has been written so far to the client
+func (sw *StatusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	recwatch.Flush(sw.ResponseWriter)
+}
+
+// Hijack lets the caller take over the connection, if supported
+func (sw *StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the wrapped http.ResponseWriter does not implement http.Hijacker")
+	}
+	return hijacker.Hijack()
+}
+
+// Written returns true if the status code or any data has been written,
+// after which the status code can no longer be changed
+func (sw *StatusWriter) Written() bool {
+	return sw.status != 0
+}
+
+// Status returns the HTTP status code that has been written.
+// If nothing has been written yet, 200 is returned.
+func (sw *StatusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
 // LogAccess creates one entry in the access log, given a http.Request,
 // a HTTP status code and the amount of bytes that have been transferred.
+// Successful requests may be skipped, depending on the sampling rate.
 func (ac *Config) LogAccess(req *http.Request, statusCode int, byteSize int64) {
+	if ac.commonAccessLogFilename == "" && ac.combinedAccessLogFilename == "" {
+		return
+	}
+	if !ac.shouldLogAccess(req, statusCode) {
+		return
+	}
 	if ac.commonAccessLogFilename != "" {
 		f, err := os.OpenFile(ac.commonAccessLogFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// The HTTP/3 ALPN identifier that is supported by the QUIC implementation
+	altSvcProtocol = "h3-22"
+
+	// The default max age for the Alt-Svc header, in seconds (30 days)
+	defaultAltSvcMaxAge = 2592000
+)
+
+// advertiseHTTP3 checks if the Alt-Svc header should be sent. If not
+// configured with SetAltSvc, it is only sent when QUIC is being served.
+func (ac *Config) advertiseHTTP3() bool {
+	if ac.altSvcConfigured {
+		return ac.altSvc
+	}
+	return ac.serveJustQUIC
+}
+
+// altSvcPort returns the UDP port where HTTP/3 is served, based on the
+// configured server address
+func (ac *Config) altSvcPort() string {
+	if ac.productionMode {
+		return "443"
+	}
+	_, port, err := net.SplitHostPort(ac.serverAddr)
+	if err != nil {
+		return "443"
+	}
+	if portNumber, err := net.LookupPort("udp", port); err == nil {
+		return strconv.Itoa(portNumber)
+	}
+	return port
+}
+
+// AltSvcHeader returns the Alt-Svc header value that advertises HTTP/3
+func (ac *Config) AltSvcHeader() string {
+	return altSvcProtocol + "=\":" + ac.altSvcPort() + "\"; ma=" + strconv.Itoa(ac.altSvcMaxAge)
+}
+
+// altSvcHandler adds the Alt-Svc header to HTTP/1.1 and HTTP/2 responses,
+// so that clients know that HTTP/3 is available
+func (ac *Config) altSvcHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor < 3 && ac.advertiseHTTP3() {
+			w.Header().Set("Alt-Svc", ac.AltSvcHeader())
+		}
+		next.ServeHTTP(w, req)
+	})
+}
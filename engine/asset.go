@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xyproto/algernon/utils"
+)
+
+const (
+	// The number of hex digits of the SHA-256 hash that are used in asset URLs
+	assetHashLength = 12
+
+	// Cache-Control for assets that are requested with the current hash
+	assetCacheControl = "public, max-age=31536000, immutable"
+)
+
+// assetHash is a cached hash of the contents of a file
+type assetHash struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// AssetHash returns a short hash of the contents of the given file. The hash
+// is cached, and calculated again only if the file has been changed.
+func (ac *Config) AssetHash(filename string) (string, error) {
+	fInfo, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	ac.assetHashesMutex.Lock()
+	cached, ok := ac.assetHashes[filename]
+	ac.assetHashesMutex.Unlock()
+	if ok && cached.modTime.Equal(fInfo.ModTime()) && cached.size == fInfo.Size() {
+		return cached.hash, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))[:assetHashLength]
+	ac.assetHashesMutex.Lock()
+	if ac.assetHashes == nil {
+		ac.assetHashes = make(map[string]assetHash)
+	}
+	ac.assetHashes[filename] = assetHash{modTime: fInfo.ModTime(), size: fInfo.Size(), hash: hash}
+	ac.assetHashesMutex.Unlock()
+	return hash, nil
+}
+
+// AssetURL returns the given URL path with the hash of the file contents as
+// the "v" query parameter, like "/js/app.js?v=1a2b3c4d5e6f". Absolute paths
+// are relative to the server directory, other paths are relative to the
+// given script directory. If the file can not be read, the path is returned
+// as it is.
+func (ac *Config) AssetURL(urlpath, scriptdir string) string {
+	var filename string
+	if strings.HasPrefix(urlpath, "/") {
+		filename = utils.URL2filename(ac.serverDirOrFilename, urlpath)
+	} else {
+		filename = filepath.Join(scriptdir, filepath.FromSlash(urlpath))
+	}
+	hash, err := ac.AssetHash(filename)
+	if err != nil {
+		return urlpath
+	}
+	if strings.Contains(urlpath, "?") {
+		return urlpath + "&v=" + hash
+	}
+	return urlpath + "?v=" + hash
+}
+
+// setAssetCacheHeaders lets clients cache the file for a long time, if it is
+// requested with the hash of the current contents, as given by AssetURL
+func (ac *Config) setAssetCacheHeaders(w http.ResponseWriter, req *http.Request, filename string) {
+	v := req.URL.Query().Get("v")
+	if v == "" {
+		return
+	}
+	if hash, err := ac.AssetHash(filename); err == nil && hash == v {
+		w.Header().Set("Cache-Control", assetCacheControl)
+	}
+}
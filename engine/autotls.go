@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// defaultAutoTLSCacheDir returns the default directory for storing
+// certificates that are obtained with ACME
+func defaultAutoTLSCacheDir() string {
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, "flunix", "autocert")
+	}
+	return filepath.Join(os.TempDir(), "flunix-autocert")
+}
+
+// NewCertManager creates a certificate manager that obtains and renews
+// certificates for the configured domains, using ACME (Let's Encrypt)
+func (ac *Config) NewCertManager() *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(ac.autoTLSDomains...),
+		Cache:      autocert.DirCache(ac.autoTLSCacheDir),
+		Email:      ac.autoTLSEmail,
+	}
+}
+
+// AutoTLSConfig returns a TLS configuration that uses the given certificate
+// manager. If a certificate could not be obtained, the certificate and key
+// given with the --cert and --key flags are used instead, if they exist.
+func (ac *Config) AutoTLSConfig(m *autocert.Manager) *tls.Config {
+	var fallback *tls.Certificate
+	if cert, err := tls.LoadX509KeyPair(ac.serverCert, ac.serverKey); err == nil {
+		fallback = &cert
+	}
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := m.GetCertificate(hello)
+			if err != nil {
+				if fallback != nil {
+					log.Warnf("Could not obtain a certificate for %s, using %s instead: %s", hello.ServerName, ac.serverCert, err)
+					ac.recordServedCertificate(fallback)
+					return fallback, nil
+				}
+				log.Errorf("Could not obtain a certificate for %s: %s", hello.ServerName, err)
+				return nil, err
+			}
+			ac.recordServedCertificate(cert)
+			return cert, nil
+		},
+		NextProtos: []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+	ac.applyClientAuth(config)
+	return config
+}
+
+// ServeAutoTLS serves HTTPS with automatic certificates on the given address,
+// and HTTP on the given HTTP address, for the ACME HTTP-01 challenge. Other
+// HTTP requests are redirected to HTTPS. Returns when the HTTPS server stops.
+func (ac *Config) ServeAutoTLS(mux *http.ServeMux, httpsAddr, httpAddr string) error {
+	if err := os.MkdirAll(ac.autoTLSCacheDir, 0700); err != nil {
+		return err
+	}
+	m := ac.NewCertManager()
+	go func() {
+		challengeMux := http.NewServeMux()
+		challengeMux.Handle("/", m.HTTPHandler(nil))
+		HTTPserver := ac.NewGracefulServer(challengeMux, false, httpAddr)
+		if err := HTTPserver.ListenAndServe(); err != nil {
+			log.Error("Not serving the ACME HTTP-01 challenge: ", err)
+		}
+	}()
+	HTTPSserver := ac.NewGracefulServer(mux, true, httpsAddr)
+	return HTTPSserver.ListenAndServeTLSConfig(ac.AutoTLSConfig(m))
+}
@@ -0,0 +1,35 @@
+package engine
+
+// The startup banner, for status pages that show which build is running
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/xyproto/algernon/platformdep"
+)
+
+// The URL path for the banner, only available in debug mode
+const bannerPath = "/debug/banner"
+
+// ServerBanner returns the startup banner, with the version and description
+// embedded, or an empty string if the banner has been disabled
+func (ac *Config) ServerBanner() string {
+	if ac.noBanner {
+		return ""
+	}
+	if ac.customBanner != "" {
+		return platformdep.CustomBanner(ac.customBanner, ac.versionString, ac.description)
+	}
+	return platformdep.Banner(ac.versionString, ac.description)
+}
+
+// BannerHandler outputs the version, the description and the banner as JSON
+func (ac *Config) BannerHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":     ac.versionString,
+		"description": ac.description,
+		"banner":      ac.ServerBanner(),
+	})
+}
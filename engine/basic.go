@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,9 +15,9 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/algernon/lua/datastruct"
 	"github.com/xyproto/algernon/utils"
 	"github.com/xyproto/gopher-lua"
-	"gopkg.in/russross/blackfriday.v2"
 )
 
 // FutureStatus is useful when redirecting in combination with writing to a
@@ -35,11 +36,89 @@ func (ac *Config) LoadBasicSystemFunctions(L *lua.LState) {
 		return 1 // number of results
 	}))
 
+	// The in-memory cache that is shared between all Lua states
+	datastruct.LoadMemCache(L)
+
+	// Return statistics for the pool of Lua states, as a table
+	L.SetGlobal("LuaPoolStats", L.NewFunction(func(L *lua.LState) int {
+		stats := ac.luapool.Stats()
+		table := L.NewTable()
+		table.RawSetString("in_use", lua.LNumber(stats.InUse))
+		table.RawSetString("idle", lua.LNumber(stats.Idle))
+		table.RawSetString("created", lua.LNumber(stats.Created))
+		table.RawSetString("reused", lua.LNumber(stats.Reused))
+		L.Push(table)
+		return 1 // number of results
+	}))
+
+	// Return statistics for the limit on concurrent requests, as a table
+	L.SetGlobal("RequestStats", L.NewFunction(func(L *lua.LState) int {
+		table := L.NewTable()
+		if rl := ac.loadRequestLimiter(); rl != nil {
+			stats := rl.Stats()
+			table.RawSetString("max", lua.LNumber(stats.Max))
+			table.RawSetString("queue_depth", lua.LNumber(stats.QueueDepth))
+			table.RawSetString("in_flight", lua.LNumber(stats.InFlight))
+			table.RawSetString("queued", lua.LNumber(stats.Queued))
+			table.RawSetString("rejected", lua.LNumber(stats.Rejected))
+		}
+		L.Push(table)
+		return 1 // number of results
+	}))
+
+	// Return the startup banner, with the version and description embedded
+	L.SetGlobal("ServerBanner", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(ac.ServerBanner()))
+		return 1 // number of results
+	}))
+
+	// Call the given function with the rest of the arguments. Returns true and
+	// nil if it succeeded, or false and the error message if it failed.
+	L.SetGlobal("try", L.NewFunction(func(L *lua.LState) int {
+		fn := L.CheckFunction(1)
+		top := L.GetTop()
+		L.Push(fn)
+		for i := 2; i <= top; i++ {
+			L.Push(L.Get(i))
+		}
+		if err := L.PCall(top-1, 0, nil); err != nil {
+			message, _ := luaErrorParts(err)
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(message))
+			return 2 // number of results
+		}
+		L.Push(lua.LTrue)
+		L.Push(lua.LNil)
+		return 2 // number of results
+	}))
+
+	// Fields that are added to the text that is logged with log, warn and err,
+	// as set with logfields. The functions are loaded for each request, so
+	// the fields only apply to the current request.
+	fields := make(log.Fields)
+
+	// Add the given key/value fields to the text that is logged with log,
+	// warn and err from now on. Without arguments, the fields are cleared.
+	L.SetGlobal("logfields", L.NewFunction(func(L *lua.LState) int {
+		if L.GetTop() == 0 {
+			fields = make(log.Fields)
+			return 0 // number of results
+		}
+		L.CheckTable(1).ForEach(func(key, value lua.LValue) {
+			field, err := convert.LValue2Interface(value)
+			if err != nil {
+				L.ArgError(1, err.Error())
+			}
+			fields[key.String()] = field
+		})
+		return 0 // number of results
+	}))
+
 	// Log text with the "Info" log type
 	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
 		buf := convert.Arguments2buffer(L, false)
 		// Log the combined text
-		log.Info(buf.String())
+		log.WithFields(fields).Info(buf.String())
 		return 0 // number of results
 	}))
 
@@ -47,7 +126,7 @@ func (ac *Config) LoadBasicSystemFunctions(L *lua.LState) {
 	L.SetGlobal("warn", L.NewFunction(func(L *lua.LState) int {
 		buf := convert.Arguments2buffer(L, false)
 		// Log the combined text
-		log.Warn(buf.String())
+		log.WithFields(fields).Warn(buf.String())
 		return 0 // number of results
 	}))
 
@@ -55,7 +134,7 @@ func (ac *Config) LoadBasicSystemFunctions(L *lua.LState) {
 	L.SetGlobal("err", L.NewFunction(func(L *lua.LState) int {
 		buf := convert.Arguments2buffer(L, false)
 		// Log the combined text
-		log.Error(buf.String())
+		log.WithFields(fields).Error(buf.String())
 		return 0 // number of results
 	}))
 
@@ -75,16 +154,59 @@ func (ac *Config) LoadBasicSystemFunctions(L *lua.LState) {
 		return 1 // number of results
 	}))
 
-	// Convert Markdown to HTML
+	// Convert Markdown to HTML. An optional table with Markdown options may
+	// be given as the last argument.
 	L.SetGlobal("markdown", L.NewFunction(func(L *lua.LState) int {
+		options := DefaultMarkdownOptions()
+		if table, ok := L.Get(L.GetTop()).(*lua.LTable); ok {
+			options = Table2MarkdownOptions(table)
+			L.Pop(1)
+		}
 		// Retrieve all the function arguments as a bytes.Buffer
 		buf := convert.Arguments2buffer(L, true)
 		// Convert the buffer to markdown and output the translated string
-		html := strings.TrimSpace(string(blackfriday.Run(buf.Bytes())))
+		html := strings.TrimSpace(string(RenderMarkdown(buf.Bytes(), options)))
 		L.Push(lua.LString(html))
 		return 1 // number of results
 	}))
 
+	// Separate the YAML or TOML front matter from the given Markdown.
+	// Returns the front matter as a table (empty if there is none) and the
+	// rest of the Markdown. Returns nil and an error string on failure.
+	L.SetGlobal("markdown_meta", L.NewFunction(func(L *lua.LState) int {
+		meta, body, err := utils.SplitFrontMatter([]byte(L.CheckString(1)))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		table := L.NewTable()
+		for key, value := range meta {
+			table.RawSetString(key, convert.Interface2LValue(L, value))
+		}
+		L.Push(table)
+		L.Push(lua.LString(body))
+		return 2 // number of results
+	}))
+
+	// Return the value of an environment variable, or the given default value.
+	// Only the variables that are allowed with AllowEnv can be read.
+	L.SetGlobal("env", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		defaultValue := L.OptString(2, "")
+		if !ac.envAllowed[name] {
+			log.Warn("Reading the environment variable ", name, " is not allowed. See AllowEnv.")
+			L.Push(lua.LString(defaultValue))
+			return 1 // number of results
+		}
+		value, found := os.LookupEnv(name)
+		if !found {
+			value = defaultValue
+		}
+		L.Push(lua.LString(value))
+		return 1 // number of results
+	}))
+
 	// Get the full filename of a given file that is in the directory
 	// where the server is running (root directory for the server).
 	// If no filename is given, the directory where the server is
@@ -103,6 +225,50 @@ func (ac *Config) LoadBasicSystemFunctions(L *lua.LState) {
 		return 1 // number of results
 	}))
 
+	// Build an URL-encoded query string from a table with keys and values.
+	// A table of values gives a repeated key. The keys are sorted.
+	L.SetGlobal("buildquery", L.NewFunction(func(L *lua.LState) int {
+		values := make(url.Values)
+		L.CheckTable(1).ForEach(func(k, v lua.LValue) {
+			key := k.String()
+			if t, ok := v.(*lua.LTable); ok {
+				for i := 1; i <= t.Len(); i++ {
+					values.Add(key, t.RawGetInt(i).String())
+				}
+				return
+			}
+			values.Add(key, v.String())
+		})
+		L.Push(lua.LString(values.Encode()))
+		return 1 // number of results
+	}))
+
+	// Parse an URL-encoded query string, with or without a leading "?", to a
+	// table. Repeated keys have a table of values. Also returns an error
+	// string, which is empty if the query string could be parsed.
+	L.SetGlobal("parsequery", L.NewFunction(func(L *lua.LState) int {
+		values, err := url.ParseQuery(strings.TrimPrefix(L.CheckString(1), "?"))
+		table := L.NewTable()
+		for key, vs := range values {
+			if len(vs) == 1 {
+				table.RawSetString(key, lua.LString(vs[0]))
+				continue
+			}
+			list := L.NewTable()
+			for _, v := range vs {
+				list.Append(lua.LString(v))
+			}
+			table.RawSetString(key, list)
+		}
+		L.Push(table)
+		if err != nil {
+			L.Push(lua.LString(err.Error()))
+		} else {
+			L.Push(lua.LString(""))
+		}
+		return 2 // number of results
+	}))
+
 }
 
 // LoadBasicWeb loads functions related to handling requests, outputting data to
@@ -194,6 +360,73 @@ func (ac *Config) LoadBasicWeb(w http.ResponseWriter, req *http.Request, L *lua.
 		return 1 // number of results
 	}))
 
+	// Return the IP address of the client. Uses X-Forwarded-For if the
+	// request comes from a trusted proxy.
+	L.SetGlobal("clientip", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(ac.ClientIP(req)))
+		return 1 // number of results
+	}))
+
+	// Return a table with information about the verified client certificate,
+	// or nil if there is none
+	L.SetGlobal("clientcert", L.NewFunction(func(L *lua.LState) int {
+		info := ClientCertificateInfo(req)
+		if info == nil {
+			L.Push(lua.LNil)
+			return 1 // number of results
+		}
+		L.Push(convert.Map2table(L, info))
+		return 1 // number of results
+	}))
+
+	// Do not log the current request in the access log, unless the response
+	// is not 2xx
+	L.SetGlobal("nolog", L.NewFunction(func(L *lua.LState) int {
+		setAccessLogMode(req, accessLogNever)
+		return 0 // number of results
+	}))
+
+	// Always log the current request in the access log, regardless of the
+	// sampling rate
+	L.SetGlobal("forcelog", L.NewFunction(func(L *lua.LState) int {
+		setAccessLogMode(req, accessLogAlways)
+		return 0 // number of results
+	}))
+
+	// Return a table with information about the TLS certificate that is
+	// served, for monitoring when it expires. Returns nil and an error string
+	// if TLS is not enabled or the certificate can not be read.
+	L.SetGlobal("TLSCertInfo", L.NewFunction(func(L *lua.LState) int {
+		info, err := ac.TLSCertificateInfo()
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		table := L.NewTable()
+		table.RawSetString("subject", lua.LString(info.Subject))
+		table.RawSetString("issuer", lua.LString(info.Issuer))
+		table.RawSetString("serial", lua.LString(info.Serial))
+		table.RawSetString("dnsNames", convert.Strings2table(L, info.DNSNames))
+		table.RawSetString("notBefore", lua.LString(info.NotBefore.UTC().Format(time.RFC3339)))
+		table.RawSetString("notAfter", lua.LString(info.NotAfter.UTC().Format(time.RFC3339)))
+		table.RawSetString("expiresIn", lua.LNumber(int64(time.Until(info.NotAfter).Seconds())))
+		L.Push(table)
+		return 1 // number of results
+	}))
+
+	// Check if a proxy has marked the request as early data (0-RTT), which can be replayed
+	L.SetGlobal("isearlydata", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(IsProxyEarlyData(req)))
+		return 1 // number of results
+	}))
+
+	// Return the path parameter with the given name, as captured by a route
+	L.SetGlobal("param", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(RouteParams(req)[L.CheckString(1)]))
+		return 1 // number of results
+	}))
+
 	// Return the HTTP headers as a table
 	L.SetGlobal("headers", L.NewFunction(func(L *lua.LState) int {
 		luaTable := L.NewTable()
@@ -223,7 +456,48 @@ func (ac *Config) LoadBasicWeb(w http.ResponseWriter, req *http.Request, L *lua.
 		return 0 // number of results
 	}))
 
-	// Return the HTTP body in the request
+	// Set several HTTP headers in the response, given a table with keys and
+	// values. A value can be a table of strings, for headers with several values.
+	L.SetGlobal("setheaders", L.NewFunction(func(L *lua.LState) int {
+		L.CheckTable(1).ForEach(func(key, value lua.LValue) {
+			k := key.String()
+			if values, ok := value.(*lua.LTable); ok {
+				w.Header().Del(k)
+				for _, v := range convert.Table2strings(values) {
+					w.Header().Add(k, v)
+				}
+				return
+			}
+			w.Header().Set(k, value.String())
+		})
+		return 0 // number of results
+	}))
+
+	// Remove the HTTP header in the response, for a given key
+	L.SetGlobal("delheader", L.NewFunction(func(L *lua.LState) int {
+		w.Header().Del(L.CheckString(1))
+		return 0 // number of results
+	}))
+
+	// Return the HTTP headers that are set in the response, as a table.
+	// Headers with several values have a table of strings as the value.
+	L.SetGlobal("responseheaders", L.NewFunction(func(L *lua.LState) int {
+		luaTable := L.NewTable()
+		for key, values := range w.Header() {
+			switch len(values) {
+			case 0:
+			case 1:
+				L.RawSet(luaTable, lua.LString(key), lua.LString(values[0]))
+			default:
+				L.RawSet(luaTable, lua.LString(key), convert.Strings2table(L, values))
+			}
+		}
+		L.Push(luaTable)
+		return 1 // number of results
+	}))
+
+	// Return the HTTP body in the request, and an error string.
+	// The error string is "too large" if the maximum body size was exceeded.
 	L.SetGlobal("body", L.NewFunction(func(L *lua.LState) int {
 		body, err := ioutil.ReadAll(req.Body)
 		var result lua.LString
@@ -233,7 +507,8 @@ func (ac *Config) LoadBasicWeb(w http.ResponseWriter, req *http.Request, L *lua.
 			result = lua.LString(string(body))
 		}
 		L.Push(result)
-		return 1 // number of results
+		L.Push(lua.LString(bodyErrorString(err)))
+		return 2 // number of results
 	}))
 
 	// Set the HTTP status code (must come before print)
@@ -252,9 +527,16 @@ func (ac *Config) LoadBasicWeb(w http.ResponseWriter, req *http.Request, L *lua.
 		if httpStatus != nil {
 			httpStatus.code = code
 		}
+		message := ""
+		if L.GetTop() == 2 {
+			message = L.ToString(2)
+		}
+		// Use the error page template for this status code, if configured
+		if ac.ErrorPage(w, req, code, message) {
+			return 0 // number of results
+		}
 		w.WriteHeader(code)
 		if L.GetTop() == 2 {
-			message := L.ToString(2)
 			fmt.Fprint(w, message)
 		}
 		return 0 // number of results
@@ -281,6 +563,34 @@ func (ac *Config) LoadBasicWeb(w http.ResponseWriter, req *http.Request, L *lua.
 		return 1 // number of results
 	}))
 
+	// Translate the given key, as loaded with LoadTranslations. Takes an optional
+	// table of arguments and an optional locale. The default locale is taken from
+	// the Accept-Language header.
+	L.SetGlobal("t", L.NewFunction(func(L *lua.LState) int {
+		key := L.CheckString(1)
+		args := make(map[string]string)
+		locale := ""
+		for i := 2; i <= L.GetTop(); i++ {
+			switch v := L.Get(i).(type) {
+			case *lua.LTable:
+				v.ForEach(func(key, value lua.LValue) {
+					args[key.String()] = value.String()
+				})
+			case lua.LString:
+				locale = string(v)
+			}
+		}
+		L.Push(lua.LString(ac.translate(req, key, locale, args)))
+		return 1 // number of results
+	}))
+
+	// Given a URL path to a file, return the URL path with a hash of the file
+	// contents, for cache busting. The file is then cached for a long time.
+	L.SetGlobal("asset", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(ac.AssetURL(L.CheckString(1), filepath.Dir(filename))))
+		return 1 // number of results
+	}))
+
 	// Given a filename, return the URL path
 	L.SetGlobal("file2url", L.NewFunction(func(L *lua.LState) int {
 		fn := L.ToString(1)
@@ -298,13 +608,46 @@ func (ac *Config) LoadBasicWeb(w http.ResponseWriter, req *http.Request, L *lua.
 	L.SetGlobal("formdata", L.NewFunction(func(L *lua.LState) int {
 		// Place the form data in a map
 		m := make(map[string]string)
-		req.ParseForm()
+		err := req.ParseForm()
 		for key, values := range req.Form {
 			m[key] = values[0]
 		}
-		// Convert the map to a table and return it
+		// Convert the map to a table and return it, together with an error string
 		L.Push(convert.Map2table(L, m))
-		return 1 // number of results
+		L.Push(lua.LString(bodyErrorString(err)))
+		return 2 // number of results
+	}))
+
+	// Decode a JSON request body to a table. Returns the table and an empty
+	// string on success, or nil and an error string on failure.
+	L.SetGlobal("jsonbody", L.NewFunction(func(L *lua.LState) int {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(bodyErrorString(err)))
+			return 2 // number of results
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("empty body"))
+			return 2 // number of results
+		}
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("invalid JSON: " + err.Error()))
+			return 2 // number of results
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			L.Push(lua.LNil)
+			L.Push(lua.LString("invalid JSON: expected an object or an array"))
+			return 2 // number of results
+		}
+		L.Push(convert.Interface2LValue(L, value))
+		L.Push(lua.LString(""))
+		return 2 // number of results
 	}))
 
 	// Retrieve a table with keys and values from the URL in the request
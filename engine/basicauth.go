@@ -0,0 +1,80 @@
+package engine
+
+// HTTP Basic Authentication for URL path prefixes, without the user database
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A bcrypt hash that is compared with when the username is unknown, so that
+// it takes as long to reject an unknown username as a wrong password
+const basicAuthDummyHash = "$2a$10$JIrj6LTmNjWtTl4UcI06xO/rU65R1MykLnwp8mBso7XLQgQDdgW1K"
+
+// BasicAuthConfig is an URL path prefix and the usernames and bcrypt password
+// hashes that may access it
+type BasicAuthConfig struct {
+	Prefix string
+	Users  map[string]string
+}
+
+// matches checks if the given URL path is the prefix, or below it,
+// optionally ignoring case
+func (ba *BasicAuthConfig) matches(urlpath string, ignoreCase bool) bool {
+	prefix := strings.TrimSuffix(ba.Prefix, "/")
+	if ignoreCase {
+		prefix = strings.ToLower(prefix)
+		urlpath = strings.ToLower(urlpath)
+	}
+	return prefix == "" || urlpath == prefix || strings.HasPrefix(urlpath, prefix+"/")
+}
+
+// verify checks the given username and password. All usernames are compared,
+// in constant time, so that the time taken does not reveal which usernames exist.
+func (ba *BasicAuthConfig) verify(username, password string) bool {
+	hash := basicAuthDummyHash
+	found := false
+	for u, h := range ba.Users {
+		if subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1 {
+			hash = h
+			found = true
+		}
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return found && err == nil
+}
+
+// ValidBcryptHash checks if the given string is a bcrypt hash, like the ones
+// generated by "htpasswd -nB username"
+func ValidBcryptHash(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// basicAuthHandler requires HTTP Basic Authentication for the URL path prefixes
+// that are configured with BasicAuth. The longest matching prefix applies.
+func (ac *Config) basicAuthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var match *BasicAuthConfig
+		for i := range ac.basicAuths {
+			ba := &ac.basicAuths[i]
+			if ba.matches(req.URL.Path, ac.caseInsensitivePaths) && (match == nil || len(ba.Prefix) > len(match.Prefix)) {
+				match = ba
+			}
+		}
+		if match == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if username, password, ok := req.BasicAuth(); ok && match.verify(username, password) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+match.Prefix+`", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		ac.LogAccess(req, http.StatusUnauthorized, int64(len("Unauthorized\n")))
+	})
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthMatches(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, urlpath string
+		ignoreCase      bool
+		matches         bool
+	}{
+		{"/admin", "/admin", false, true},
+		{"/admin", "/admin/", false, true},
+		{"/admin/", "/admin/page", false, true},
+		{"/admin", "/administrator", false, false},
+		{"/admin", "/", false, false},
+		{"/admin", "/Admin/page", false, false},
+		{"/admin", "/Admin/page", true, true},
+		{"/Admin", "/admin", true, true},
+		{"/", "/anything", false, true},
+		{"", "/anything", false, true},
+	} {
+		ba := &BasicAuthConfig{Prefix: tc.prefix}
+		assert.Equal(t, ba.matches(tc.urlpath, tc.ignoreCase), tc.matches)
+	}
+}
+
+func TestBasicAuthVerify(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	assert.Equal(t, err, nil)
+	ba := &BasicAuthConfig{Prefix: "/admin", Users: map[string]string{"bob": string(hash)}}
+	for _, tc := range []struct {
+		username, password string
+		valid              bool
+	}{
+		{"bob", "hunter2", true},
+		{"bob", "hunter3", false},
+		{"bob", "", false},
+		{"alice", "hunter2", false},
+		{"", "", false},
+	} {
+		assert.Equal(t, ba.verify(tc.username, tc.password), tc.valid)
+	}
+}
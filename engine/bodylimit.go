@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/xyproto/algernon/utils"
+)
+
+// ErrBodyTooLarge is the error message that is given to Lua when the
+// request body is larger than the configured maximum body size
+const ErrBodyTooLarge = "too large"
+
+// maxBodySize returns the maximum request body size for the given URL path,
+// in bytes. The longest matching prefix override is used, if any.
+// Returns 0 if there is no limit.
+func (ac *Config) maxBodySize(urlPath string) int64 {
+	limit := ac.maxBodyBytes
+	longest := -1
+	for prefix, prefixLimit := range ac.maxBodyBytesPrefixes {
+		if ac.hasPathPrefix(urlPath, prefix) && len(prefix) > longest {
+			limit = prefixLimit
+			longest = len(prefix)
+		}
+	}
+	return limit
+}
+
+// maxBodyHandler limits the size of request bodies, if configured with
+// SetMaxBodySize. Reading more than the limit results in an error.
+func (ac *Config) maxBodyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if limit := ac.maxBodySize(req.URL.Path); limit > 0 && req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, limit)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// bodyErrorString converts an error from reading the request body to a
+// string that can be returned to Lua
+func bodyErrorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	if strings.Contains(err.Error(), "request body too large") {
+		return ErrBodyTooLarge
+	}
+	return err.Error()
+}
+
+// megabytesToBytes converts a size in MiB, as given in a configuration script, to bytes
+func megabytesToBytes(megabytes float64) int64 {
+	return int64(megabytes * float64(utils.MiB))
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/utils"
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// Identifier for the BodyReader class in Lua
+	lBodyReaderClass = "BodyReader"
+
+	// The number of bytes that are read by reader:read() if no number is given
+	defaultBodyChunkSize = 32 * utils.KiB
+
+	// The maximum number of bytes that can be read at once
+	maxBodyChunkSize = 16 * utils.MiB
+)
+
+// BodyReader reads the request body in chunks, instead of all at once
+type BodyReader struct {
+	body io.ReadCloser
+	done bool
+}
+
+// Read reads up to n bytes. Fewer bytes are only returned at the end of the
+// body. Returns io.EOF when there is nothing more to read.
+func (br *BodyReader) Read(n int) ([]byte, error) {
+	if br.done || br.body == nil {
+		return nil, io.EOF
+	}
+	buf := make([]byte, n)
+	read, err := io.ReadFull(br.body, buf)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		br.done = true
+		if read == 0 {
+			return nil, io.EOF
+		}
+		return buf[:read], nil
+	case err != nil:
+		br.done = true
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Close closes the request body
+func (br *BodyReader) Close() error {
+	br.done = true
+	if br.body == nil {
+		return nil
+	}
+	return br.body.Close()
+}
+
+// Get the first argument, "self", and cast it from userdata to a BodyReader
+func checkBodyReader(L *lua.LState) *BodyReader {
+	ud := L.CheckUserData(1)
+	if br, ok := ud.Value.(*BodyReader); ok {
+		return br
+	}
+	L.ArgError(1, "body reader expected")
+	return nil
+}
+
+// Takes a BodyReader and an optional number of bytes to read.
+// Returns the data, nil when done, or nil and an error message.
+// The error message is "too large" if the maximum body size was exceeded.
+func bodyReaderRead(L *lua.LState) int {
+	br := checkBodyReader(L) // arg 1
+	n := L.OptInt(2, defaultBodyChunkSize)
+	if n <= 0 || n > maxBodyChunkSize {
+		L.ArgError(2, "the number of bytes must be from 1 to 16 MiB")
+		return 0 // number of results
+	}
+	data, err := br.Read(n)
+	if err == io.EOF {
+		L.Push(lua.LNil)
+		return 1 // number of results
+	} else if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(bodyErrorString(err)))
+		return 2 // number of results
+	}
+	L.Push(lua.LString(data))
+	return 1 // number of results
+}
+
+// Takes a BodyReader and closes the request body
+func bodyReaderClose(L *lua.LState) int {
+	br := checkBodyReader(L) // arg 1
+	if err := br.Close(); err != nil {
+		log.Error(err)
+	}
+	return 0 // number of results
+}
+
+// The BodyReader methods that are to be registered
+var bodyReaderMethods = map[string]lua.LGFunction{
+	"read":  bodyReaderRead,
+	"close": bodyReaderClose,
+}
+
+// LoadBodyReaderFunctions makes the bodyreader function available, for
+// reading the body of the given request in chunks
+func (ac *Config) LoadBodyReaderFunctions(L *lua.LState, req *http.Request) {
+
+	// Register the BodyReader class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lBodyReaderClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, bodyReaderMethods)
+
+	// Return a reader for the request body. The body can only be read once,
+	// so body() returns the rest of the body after reading some of it.
+	L.SetGlobal("bodyreader", L.NewFunction(func(L *lua.LState) int {
+		ud := L.NewUserData()
+		ud.Value = &BodyReader{body: req.Body}
+		L.SetMetatable(ud, L.GetTypeMetatable(lBodyReaderClass))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
@@ -0,0 +1,136 @@
+package engine
+
+// Case-insensitive URL paths, for sites with legacy URLs in mixed case
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// permissionPrefix is an URL path prefix that requires user or admin rights
+type permissionPrefix struct {
+	prefix string
+	admin  bool
+}
+
+// addPermissionPrefix registers the given URL path prefix as requiring user
+// or admin rights. Requests are redirected to the lowercase URL path if
+// SetCaseInsensitivePaths is enabled, so then the lowercase form of the
+// prefix is also registered.
+func (ac *Config) addPermissionPrefix(prefix string, admin bool) {
+	add := ac.perm.AddUserPath
+	if admin {
+		add = ac.perm.AddAdminPath
+	}
+	add(prefix)
+	if lower := strings.ToLower(prefix); lower != prefix {
+		if ac.caseInsensitivePaths {
+			add(lower)
+		} else {
+			ac.mixedCasePrefixes = append(ac.mixedCasePrefixes, permissionPrefix{prefix, admin})
+		}
+	}
+}
+
+// setCaseInsensitivePaths enables or disables case-insensitive URL paths,
+// and registers the lowercase form of the user and admin URL path prefixes
+// that have been added so far
+func (ac *Config) setCaseInsensitivePaths(enable bool) {
+	ac.caseInsensitivePaths = enable
+	if !enable || ac.perm == nil {
+		return
+	}
+	for _, pp := range ac.mixedCasePrefixes {
+		ac.addPermissionPrefix(strings.ToLower(pp.prefix), pp.admin)
+	}
+	ac.mixedCasePrefixes = nil
+}
+
+// hasPathPrefix checks if the given URL path starts with the given prefix,
+// ignoring case if enabled with SetCaseInsensitivePaths
+func (ac *Config) hasPathPrefix(urlPath, prefix string) bool {
+	if ac.caseInsensitivePaths {
+		return strings.HasPrefix(strings.ToLower(urlPath), strings.ToLower(prefix))
+	}
+	return strings.HasPrefix(urlPath, prefix)
+}
+
+// lowercasePath returns the lowercase form of the given URL path. The path
+// parameters that are captured by a matching route keep their case.
+func (ac *Config) lowercasePath(urlPath string) string {
+	for _, route := range ac.routes {
+		if _, ok := route.match(urlPath, true); ok {
+			return route.lowercase(urlPath)
+		}
+	}
+	return strings.ToLower(urlPath)
+}
+
+// caseInsensitiveHandler redirects URL paths with uppercase letters to the
+// lowercase form, if enabled with SetCaseInsensitivePaths
+func (ac *Config) caseInsensitiveHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !ac.caseInsensitivePaths {
+			next.ServeHTTP(w, req)
+			return
+		}
+		lower := ac.lowercasePath(req.URL.Path)
+		if lower == req.URL.Path {
+			next.ServeHTTP(w, req)
+			return
+		}
+		location := (&url.URL{Path: lower, RawQuery: req.URL.RawQuery}).String()
+		// Keep the method and body for other requests than GET and HEAD
+		status := http.StatusMovedPermanently
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, req, location, status)
+		ac.LogAccess(req, status, 0)
+	})
+}
+
+// resolveFilenameCase finds the file or directory on disk that matches the
+// given filename below the given directory, when ignoring the case of each
+// path element. An exact match is preferred. Returns the filename with the
+// names that are on disk and true, or false if there is no match.
+func resolveFilenameCase(dir, filename string) (string, bool) {
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	resolved := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if elem == "" || elem == "." {
+			continue
+		}
+		candidate := filepath.Join(resolved, elem)
+		if _, err := os.Stat(candidate); err == nil {
+			resolved = candidate
+			continue
+		}
+		entries, err := ioutil.ReadDir(resolved)
+		if err != nil {
+			return "", false
+		}
+		found := false
+		for _, fi := range entries {
+			if strings.EqualFold(fi.Name(), elem) {
+				resolved = filepath.Join(resolved, fi.Name())
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	if strings.HasSuffix(filename, string(filepath.Separator)) {
+		resolved += string(filepath.Separator)
+	}
+	return resolved, true
+}
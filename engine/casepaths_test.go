@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestResolveFilenameCase(t *testing.T) {
+	parent, err := ioutil.TempDir("", "casepaths")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "www")
+	assert.Equal(t, os.MkdirAll(filepath.Join(dir, "Docs"), 0755), nil)
+	assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, "Docs", "ReadMe.md"), []byte("# Hi"), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0644), nil)
+
+	sep := string(filepath.Separator)
+	for _, tc := range []struct {
+		filename, resolved string
+		ok                 bool
+	}{
+		{filepath.Join(dir, "Docs", "ReadMe.md"), filepath.Join(dir, "Docs", "ReadMe.md"), true},
+		{filepath.Join(dir, "docs", "readme.MD"), filepath.Join(dir, "Docs", "ReadMe.md"), true},
+		{filepath.Join(dir, "DOCS") + sep, filepath.Join(dir, "Docs") + sep, true},
+		{dir, dir, true},
+		{filepath.Join(dir, "docs", "missing.md"), "", false},
+		{filepath.Join(dir, "..", "secret.txt"), "", false},
+		{filepath.Join(dir, "..", "SECRET.txt"), "", false},
+		{parent, "", false},
+	} {
+		resolved, ok := resolveFilenameCase(dir, tc.filename)
+		assert.Equal(t, ok, tc.ok)
+		assert.Equal(t, resolved, tc.resolved)
+	}
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// The QUIC package does not pass the TLS connection state on to the HTTP/3
+// requests, so client certificates can not be checked over QUIC
+var errQUICClientCert = errors.New("client certificates (RequireClientCert) can not be used when serving QUIC, since HTTP/3 requests do not carry the client certificate. Serve HTTPS without QUIC instead")
+
+// LoadClientCAs reads a PEM encoded CA certificate file, for verifying client certificates
+func LoadClientCAs(caCertFilename string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caCertFilename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + caCertFilename)
+	}
+	return pool, nil
+}
+
+// applyClientAuth makes the given TLS configuration require client
+// certificates, if enabled with RequireClientCert
+func (ac *Config) applyClientAuth(config *tls.Config) {
+	if ac.clientCAs == nil {
+		return
+	}
+	config.ClientCAs = ac.clientCAs
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+}
+
+// clientCertHandler rejects requests without a verified client certificate,
+// if client certificates are required. With TLS, such connections are already
+// refused during the handshake, but this also covers plain HTTP.
+func (ac *Config) clientCertHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ac.clientCAs != nil && ClientCertificate(req) == nil {
+			http.Error(w, "A valid client certificate is required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ClientCertificate returns the verified client certificate for the given
+// request, or nil
+func ClientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// ClientCertificateInfo returns the subject, issuer, serial number and
+// expiry time of the verified client certificate, or nil
+func ClientCertificateInfo(req *http.Request) map[string]string {
+	cert := ClientCertificate(req)
+	if cert == nil {
+		return nil
+	}
+	return map[string]string{
+		"subject":  cert.Subject.String(),
+		"issuer":   cert.Issuer.String(),
+		"serial":   cert.SerialNumber.String(),
+		"notAfter": cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/didip/tollbooth"
+	"github.com/didip/tollbooth/limiter"
+)
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges.
+// Returns the parsed networks, together with the entries that could not be parsed.
+func parseTrustedProxies(entries []string) ([]*net.IPNet, []string) {
+	var (
+		networks []*net.IPNet
+		invalid  []string
+	)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			// A single IP address
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				invalid = append(invalid, entry)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			invalid = append(invalid, entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks, invalid
+}
+
+// trustedProxy checks if the given IP address belongs to a trusted proxy
+func (ac *Config) trustedProxy(ipString string) bool {
+	ip := net.ParseIP(ipString)
+	if ip == nil {
+		return false
+	}
+	for _, network := range ac.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client. If the request comes from a
+// trusted proxy, the X-Forwarded-For header is examined from right to left,
+// and the first address that is not a trusted proxy is returned. If the
+// request does not come from a trusted proxy, X-Forwarded-For is ignored.
+func (ac *Config) ClientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if !ac.trustedProxy(ip) {
+		return ip
+	}
+	forwardedFor := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwardedIP := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(forwardedIP) == nil {
+			// Not a valid IP address, stop here and use the last good one
+			break
+		}
+		ip = forwardedIP
+		if !ac.trustedProxy(forwardedIP) {
+			break
+		}
+	}
+	return ip
+}
+
+// LimitFuncHandler is like tollbooth.LimitFuncHandler, but uses the client IP
+// as found by ClientIP, so that clients behind trusted proxies are rate limited
+// individually.
+func (ac *Config) LimitFuncHandler(lmt *limiter.Limiter, nextFunc func(http.ResponseWriter, *http.Request)) http.Handler {
+	limited := tollbooth.LimitFuncHandler(lmt, nextFunc)
+	if len(ac.trustedProxies) == 0 {
+		return limited
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := ac.ClientIP(req)
+		if host, port, err := net.SplitHostPort(req.RemoteAddr); err == nil && host != ip {
+			// Use a shallow copy of the request, with the address of the client
+			clientReq := *req
+			clientReq.RemoteAddr = net.JoinHostPort(ip, port)
+			req = &clientReq
+		}
+		limited.ServeHTTP(w, req)
+	})
+}
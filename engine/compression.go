@@ -0,0 +1,196 @@
+package engine
+
+// Response compression for dynamic content, like the output from Lua scripts
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/xyproto/recwatch"
+)
+
+const defaultCompressionMinSize = 1024
+
+var (
+	// Content types that are compressed by default
+	defaultCompressionTypes = []string{"text/", "application/javascript", "application/json", "application/xml", "image/svg+xml"}
+
+	// Content types that are already compressed, and that should never be compressed again
+	precompressedTypes = []string{"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz", "application/x-7z-compressed", "application/x-rar-compressed", "application/pdf"}
+)
+
+// acceptsEncoding checks if the given Accept-Encoding header value accepts
+// the given encoding, taking q-values into account
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// hasPrefixIn checks if the given string has one of the given prefixes
+func hasPrefixIn(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressWriter is a http.ResponseWriter that compresses the response,
+// if the client supports it and the response is large enough and of a
+// suitable content type. Close must be called when the response is done.
+type CompressWriter struct {
+	http.ResponseWriter
+	ac       *Config
+	buf      bytes.Buffer
+	status   int
+	decided  bool
+	encoder  io.WriteCloser
+	flusher  func() error
+	encoding string
+}
+
+// NewCompressWriter wraps the given http.ResponseWriter. If compression is
+// disabled, the given http.ResponseWriter is returned as it is, together
+// with a no-op close function.
+func (ac *Config) NewCompressWriter(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, func()) {
+	if len(ac.compressionEncodings) == 0 || req.Method == http.MethodHead || req.Header.Get("Range") != "" {
+		return w, func() {}
+	}
+	// Select the first of the configured encodings that the client accepts
+	acceptEncoding := req.Header.Get("Accept-Encoding")
+	for _, encoding := range ac.compressionEncodings {
+		if acceptsEncoding(acceptEncoding, encoding) {
+			cw := &CompressWriter{ResponseWriter: w, ac: ac, encoding: encoding}
+			return cw, cw.Close
+		}
+	}
+	return w, func() {}
+}
+
+// WriteHeader stores the status code until it is known if the response
+// should be compressed or not
+func (cw *CompressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// shouldCompress checks the status code and headers of the response
+func (cw *CompressWriter) shouldCompress() bool {
+	if cw.buf.Len() < cw.ac.compressionMinSize {
+		return false
+	}
+	switch cw.status {
+	case 0, http.StatusOK:
+	default:
+		// Only compress complete responses
+		return false
+	}
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		// Already compressed, for instance by the file cache
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(cw.buf.Bytes())
+	}
+	contentType = strings.ToLower(contentType)
+	if hasPrefixIn(contentType, precompressedTypes) {
+		return false
+	}
+	return hasPrefixIn(contentType, cw.ac.compressionTypes)
+}
+
+// decide checks if the response should be compressed, then writes the
+// headers and the data that has been buffered so far
+func (cw *CompressWriter) decide() {
+	cw.decided = true
+	if cw.shouldCompress() {
+		header := cw.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+		switch cw.encoding {
+		case "br":
+			bw := brotli.NewWriter(cw.ResponseWriter)
+			cw.encoder, cw.flusher = bw, bw.Flush
+		default:
+			gw := gzip.NewWriter(cw.ResponseWriter)
+			cw.encoder, cw.flusher = gw, gw.Flush
+		}
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if cw.buf.Len() > 0 {
+		if cw.encoder != nil {
+			cw.encoder.Write(cw.buf.Bytes())
+		} else {
+			cw.ResponseWriter.Write(cw.buf.Bytes())
+		}
+		cw.buf.Reset()
+	}
+}
+
+// Write buffers data until there is enough to decide if the response
+// should be compressed, then writes the data, possibly compressed
+func (cw *CompressWriter) Write(data []byte) (int, error) {
+	if !cw.decided {
+		cw.buf.Write(data)
+		if cw.buf.Len() >= cw.ac.compressionMinSize {
+			cw.decide()
+		}
+		return len(data), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush sends the data that has been written so far to the client
+func (cw *CompressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.flusher != nil {
+		cw.flusher()
+	}
+	recwatch.Flush(cw.ResponseWriter)
+}
+
+// Close writes any remaining data and ends the compressed stream
+func (cw *CompressWriter) Close() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/xyproto/algernon/themes"
+)
+
+// RequestLimiter limits the number of requests that are handled at the
+// same time. Requests over the limit wait in a queue of limited depth.
+type RequestLimiter struct {
+	slots      chan struct{}
+	queueDepth int64
+	queued     int64
+	rejected   uint64
+}
+
+// RequestLimiterStats contains the current state of a RequestLimiter
+type RequestLimiterStats struct {
+	Max        int
+	QueueDepth int
+	InFlight   int
+	Queued     int
+	Rejected   uint64
+}
+
+// NewRequestLimiter creates a RequestLimiter that allows max requests to be
+// handled at the same time, with up to queueDepth requests waiting
+func NewRequestLimiter(max, queueDepth int) *RequestLimiter {
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+	return &RequestLimiter{
+		slots:      make(chan struct{}, max),
+		queueDepth: int64(queueDepth),
+	}
+}
+
+// Acquire waits for a free slot. Returns false if the queue is full, or if
+// the client went away while waiting. Release must be called after a
+// successful Acquire.
+func (rl *RequestLimiter) Acquire(req *http.Request) bool {
+	select {
+	case rl.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt64(&rl.queued, 1) > rl.queueDepth {
+		atomic.AddInt64(&rl.queued, -1)
+		atomic.AddUint64(&rl.rejected, 1)
+		return false
+	}
+	defer atomic.AddInt64(&rl.queued, -1)
+	select {
+	case rl.slots <- struct{}{}:
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+// Release frees a slot
+func (rl *RequestLimiter) Release() {
+	<-rl.slots
+}
+
+// Stats returns the current number of requests in flight and in the queue,
+// and how many requests have been rejected
+func (rl *RequestLimiter) Stats() RequestLimiterStats {
+	return RequestLimiterStats{
+		Max:        cap(rl.slots),
+		QueueDepth: int(rl.queueDepth),
+		InFlight:   len(rl.slots),
+		Queued:     int(atomic.LoadInt64(&rl.queued)),
+		Rejected:   atomic.LoadUint64(&rl.rejected),
+	}
+}
+
+// loadRequestLimiter returns the current RequestLimiter, or nil
+func (ac *Config) loadRequestLimiter() *RequestLimiter {
+	rl, _ := ac.requestLimiter.Load().(*RequestLimiter)
+	return rl
+}
+
+// setRequestLimiter replaces the current RequestLimiter. nil disables the limit.
+func (ac *Config) setRequestLimiter(rl *RequestLimiter) {
+	ac.requestLimiter.Store(rl)
+}
+
+// concurrencyHandler limits the number of requests that are handled at the
+// same time, if configured with SetMaxConcurrentRequests. Requests that can
+// not be queued get "503 Service Unavailable".
+func (ac *Config) concurrencyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rl := ac.loadRequestLimiter()
+		if rl == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if !rl.Acquire(req) {
+			page := themes.MessagePage("Server busy", "<div style='color:red'>The server is too busy to handle the request. Please try again later.</div>", ac.defaultTheme)
+			w.Header().Set("Content-Type", "text/html;charset=utf-8")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(page))
+			ac.LogAccess(req, http.StatusServiceUnavailable, int64(len(page)))
+			return
+		}
+		defer rl.Release()
+		next.ServeHTTP(w, req)
+	})
+}
@@ -3,10 +3,12 @@ package engine
 
 import (
 	"bytes"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	internallog "log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	babel "github.com/jvatic/goja-babel"
@@ -127,10 +130,28 @@ type Config struct {
 	limitRequests       int64 // rate limit to this many requests per client per second
 	disableRateLimiting bool
 
+	// How URL paths with and without a trailing slash are handled, as set
+	// with SetTrailingSlash. Empty is the same as "ignore".
+	trailingSlash string
+
+	// Ignore the case of URL paths, and redirect to the lowercase form,
+	// as enabled with SetCaseInsensitivePaths
+	caseInsensitivePaths bool
+
+	// User and admin URL path prefixes with uppercase letters, that are also
+	// added in lowercase if SetCaseInsensitivePaths is enabled later on
+	mixedCasePrefixes []permissionPrefix
+
+	// URL path prefixes that require HTTP Basic Authentication, as configured with BasicAuth
+	basicAuths []BasicAuthConfig
+
 	// Access logs
 	commonAccessLogFilename   string // NCSA access log
 	combinedAccessLogFilename string // CLF access log
 
+	// The share of the 2xx responses that are logged in the access log, from 0 to 1
+	logSamplingRate float64
+
 	// For the version flag
 	showVersion bool
 
@@ -146,9 +167,43 @@ type Config struct {
 	// Large file support (threshold for not reading into memory)
 	largeFileSize uint64
 
+	// Timeout when reading a request from a client, in seconds
+	readTimeout uint64
+
 	// Timeout when writing to a client, in seconds
 	writeTimeout uint64
 
+	// Security headers for all responses, as configured with SetSecurityHeaders
+	securityHeaders http.Header
+	hstsHeader      string // Strict-Transport-Security, only sent over HTTPS
+
+	// CA certificates for verifying client certificates. If set, client certificates are required.
+	clientCAs *x509.CertPool
+
+	// Automatic HTTPS certificates with ACME (Let's Encrypt)
+	autoTLSDomains  []string
+	autoTLSEmail    string
+	autoTLSCacheDir string // Where certificates are stored
+
+	// Advertise HTTP/3 with the Alt-Svc header. Enabled by default when serving QUIC.
+	altSvc           bool
+	altSvcConfigured bool
+	altSvcMaxAge     int // in seconds
+
+	// Allow requests that a proxy has forwarded from TLS early data (0-RTT).
+	// The server itself never accepts early data.
+	proxyEarlyData bool
+
+	// Minify generated CSS and served .css files
+	cssMinify bool
+
+	// Timeout for idle keep-alive connections, both for HTTP and QUIC, in seconds
+	idleTimeout uint64
+
+	// QUIC stream and flow control limits, or 0 for the defaults of the QUIC package
+	quicMaxStreams    int
+	quicReceiveWindow uint64 // in bytes
+
 	// HTTP headers
 	noHeaders       bool
 	stricterHeaders bool
@@ -157,6 +212,9 @@ type Config struct {
 	quietMode bool
 	noBanner  bool
 
+	// Custom ANSI graphics for the banner, as set by SetBanner
+	customBanner string
+
 	// If a single Lua file is provided, or Server() is used.
 	luaServerFilename string
 
@@ -242,6 +300,154 @@ type Config struct {
 
 	// Secret to be used when setting and getting user login cookies
 	cookieSecret string
+
+	// Response compression for dynamic content. Disabled if there are no encodings.
+	compressionEncodings []string // In order of preference, like "br" and "gzip"
+	compressionMinSize   int      // Minimum response size, in bytes
+	compressionTypes     []string // Content type prefixes that may be compressed
+
+	// Proxies that are trusted to set the X-Forwarded-For header
+	trustedProxies []*net.IPNet
+
+	// ETags for static files: "strong", "weak" or "off"
+	etagMode string
+
+	// Directory listings
+	noDirListing       bool   // Don't list directories that have no index file
+	dirListingHidden   bool   // Include hidden files in directory listings
+	dirListingTemplate string // Pongo2 template for directory listings
+
+	// Maximum request body size in bytes, and overrides per URL path prefix. 0 is no limit.
+	maxBodyBytes         int64
+	maxBodyBytesPrefixes map[string]int64
+
+	// Routes with path parameters, as configured with Route
+	routes []*Route
+
+	// Render statistics, available at /debug/render-stats in debug mode
+	renderStats *RenderStats
+
+	// Environment variables that can be read with env()
+	envAllowed map[string]bool
+
+	// Closed at shutdown or reload, for stopping scheduled tasks
+	scheduleStopChan chan struct{}
+	scheduleStopped  bool // true after shutdown
+	scheduleMutex    *sync.Mutex
+
+	// Held while calling Lua functions from the configuration scripts,
+	// since they share the global variables of the configuration script
+	configMutex *sync.Mutex
+
+	// Embedded filesystems that are served when there is no such file on disk
+	embeddedMounts []EmbeddedMount
+
+	// Files that are served instead of "404 Not Found", for single-page applications
+	spaFallbacks []SPAFallback
+
+	// Pongo2 templates for error pages, by HTTP status code or "default"
+	errorPages map[string]string
+
+	// Reverse proxies, as configured by ReverseProxy in a configuration script
+	reverseProxies []ReverseProxyConfig
+
+	// Directories that are served over WebDAV, as configured by EnableWebDAV
+	webDAVs []WebDAVConfig
+
+	// Resumable uploads
+	resumableUploadPath string // URL path, like "/uploads"
+	resumableUploadDir  string // Directory for partial and completed uploads
+
+	// URL path prefix for the pprof handlers, or empty if disabled
+	pprofPrefix string
+
+	// URL paths for the liveness and readiness endpoints, or empty if disabled
+	healthLivePath  string
+	healthReadyPath string
+
+	// URL path for the sitemap, or empty if disabled, and the priorities
+	// for the URL paths in it, as set with SetSitemapPriority
+	sitemapPath       string
+	sitemapPriorities []SitemapPriority
+
+	// The scheme and host for the URLs in the sitemap, like
+	// "https://example.com", or empty for using the host of the request
+	sitemapBaseURL string
+
+	// The pages in the sitemap, found recently
+	sitemapCache *sitemapCache
+
+	// The configuration for robots.txt, as set with SetRobots, or nil
+	robots *Robots
+
+	// Exports a span for each request, if enabled with EnableTracing, or nil
+	tracer *Tracer
+
+	// How long a session lasts after it was last changed, as set with SetSessionTTL
+	sessionTTL time.Duration
+
+	// Cached feature flags
+	featureFlags *FeatureFlags
+
+	// Lua handlers, compiled to bytecode
+	luaCompileCache *LuaCompileCache
+
+	// Lua function that is called when a Lua handler fails, as set with OnError
+	onErrorFunc *ConfigFunction
+
+	// Include the Lua stack traceback in error messages
+	debugTraceback bool
+
+	// Hubs for broadcasting to WebSocket connections, by name
+	hubs      map[string]*Hub
+	hubsMutex *sync.Mutex
+
+	// Limits the number of simultaneous connections per client IP
+	connLimiter *connLimiter
+
+	// The maximum number of simultaneous requests per client IP, or 0
+	maxRequestsPerIP   int
+	requestsPerIP      map[string]int
+	requestsPerIPMutex *sync.Mutex
+
+	// Limits the number of requests that are handled at the same time.
+	// Can be changed by the configuration scripts while requests are handled.
+	requestLimiter *atomic.Value // *RequestLimiter, may be nil
+
+	// The timeout for handling a request, with overrides for URL path prefixes
+	handlerTimeoutDuration time.Duration
+	handlerTimeoutPrefixes map[string]time.Duration
+
+	// Cached hashes of assets, for the URLs from asset()
+	assetHashes      map[string]assetHash
+	assetHashesMutex *sync.Mutex
+
+	// Values and Lua functions that are available in all Pongo2 templates
+	templateGlobals map[string]interface{}
+	templateFuncs   map[string]*ConfigFunction
+	templateMutex   *sync.Mutex
+
+	// Messages for several locales, as loaded with LoadTranslations, or nil
+	translations *Translations
+
+	// Filenames that are served instead of a directory listing, as set with
+	// SetIndexFiles, or nil for the default filenames
+	indexFiles []string
+
+	// The log file, as set with --log or LogTo, and the signal for reopening it
+	logFile         *LogFile
+	logFileMutex    *sync.Mutex
+	logReopenSignal string
+
+	// For reloading the configuration scripts when SIGHUP is received.
+	// A reload runs the scripts on a copy of the latest configuration, which
+	// then handles the new requests.
+	reloadMutex     *sync.Mutex
+	reloadedConfig  *Config       // the latest configuration, or nil
+	reloadedHandler *atomic.Value // http.Handler, set after a reload
+
+	// The TLS certificate that was most recently served with AutoTLS
+	servedCert *atomic.Value // *tls.Certificate
 }
 
 // ErrVersion is returned when the initialization quits because all that is done
@@ -256,8 +462,23 @@ func New(versionString, description string) (*Config, error) {
 	ac := &Config{
 		curlSupport: true,
 
+		etagMode: etagWeak,
+
+		compressionMinSize: defaultCompressionMinSize,
+		compressionTypes:   defaultCompressionTypes,
+
 		shutdownTimeout: 10 * time.Second,
 
+		sessionTTL:   defaultSessionTTL,
+		featureFlags: NewFeatureFlags(),
+
+		readTimeout: 10,
+		idleTimeout: 120,
+
+		logSamplingRate: 1,
+
+		altSvcMaxAge: defaultAltSvcMaxAge,
+
 		defaultWebColonPort:       ":3000",
 		defaultRedisColonPort:     ":6379",
 		defaultEventColonPort:     ":5553",
@@ -297,6 +518,37 @@ func New(versionString, description string) (*Config, error) {
 		// Mutex for rendering Pongo2 pages
 		pongomutex: &sync.RWMutex{},
 
+		// Mutexes for the state that is shared with reloaded configurations
+		scheduleMutex:      &sync.Mutex{},
+		configMutex:        &sync.Mutex{},
+		hubsMutex:          &sync.Mutex{},
+		requestsPerIPMutex: &sync.Mutex{},
+		assetHashesMutex:   &sync.Mutex{},
+		templateMutex:      &sync.Mutex{},
+		logFileMutex:       &sync.Mutex{},
+		reloadMutex:        &sync.Mutex{},
+
+		// WebSocket hubs and request counts per client IP, shared with reloaded configurations
+		hubs:          make(map[string]*Hub),
+		requestsPerIP: make(map[string]int),
+		connLimiter:   newConnLimiter(),
+
+		reloadedHandler: &atomic.Value{},
+		servedCert:      &atomic.Value{},
+		requestLimiter:  &atomic.Value{},
+
+		// Statistics for rendering templates
+		renderStats: NewRenderStats(),
+
+		// Show Lua stack tracebacks for errors
+		debugTraceback: true,
+
+		// Signal for reopening the log file, for log rotation
+		logReopenSignal: defaultLogReopenSignal,
+
+		// Lua handlers that have been compiled to bytecode
+		luaCompileCache: NewLuaCompileCache(),
+
 		// Program for opening URLs
 		defaultOpenExecutable: platformdep.DefaultOpenExecutable,
 
@@ -433,13 +685,10 @@ func (ac *Config) initFilesAndCache() error {
 func (ac *Config) setupLogging() {
 	// Log to a file as JSON, if a log file has been specified
 	if ac.serverLogFile != "" {
-		f, errJSONLog := os.OpenFile(ac.serverLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, ac.defaultPermissions)
-		if errJSONLog != nil {
+		if errJSONLog := ac.logToFile(ac.serverLogFile); errJSONLog != nil {
 			log.Warnf("Could not log to %s: %s", ac.serverLogFile, errJSONLog)
 		} else {
-			// Log to the given log filename
 			log.SetFormatter(&log.JSONFormatter{})
-			log.SetOutput(f)
 		}
 	} else if ac.quietMode {
 		// If quiet mode is enabled and no log file has been specified, disable logging
@@ -561,6 +810,60 @@ func unique(sl []string) []string {
 	return nl
 }
 
+// registerConfiguredHandlers registers the handlers that may be enabled by the
+// configuration scripts, like resumable uploads and reverse proxies
+func (ac *Config) registerConfiguredHandlers(mux *http.ServeMux) {
+	// Register the handler for resumable uploads, if enabled by a configuration script
+	if ac.resumableUploadPath != "" {
+		if ac.perm == nil {
+			log.Warn("Resumable uploads requires a database backend")
+		} else if err := ac.RegisterResumableUploads(mux, ac.resumableUploadPath); err != nil {
+			log.Errorf("Could not enable resumable uploads: %s", err)
+		}
+	}
+
+	// Register the health check endpoints, if enabled by a configuration script
+	if ac.healthLivePath != "" || ac.healthReadyPath != "" {
+		ac.RegisterHealthChecks(mux, ac.healthLivePath, ac.healthReadyPath)
+	}
+
+	// Register the sitemap, if enabled by a configuration script
+	if ac.sitemapPath != "" {
+		mux.HandleFunc(ac.sitemapPath, ac.SitemapHandler)
+	}
+
+	// Register robots.txt, if configured by a configuration script
+	if ac.robots != nil {
+		mux.HandleFunc(robotsPath, ac.RobotsHandler)
+	}
+
+	// Register the pprof handlers, if enabled by a configuration script
+	if ac.pprofPrefix != "" {
+		if ac.perm == nil {
+			log.Warn("pprof requires a database backend, for the admin rights")
+		} else {
+			ac.RegisterPprof(mux, ac.pprofPrefix)
+		}
+	}
+
+	// Serve statistics for how long it takes to render templates, and the
+	// startup banner, in debug mode
+	if ac.debugMode {
+		mux.Handle(renderStatsPath, ac.renderStats)
+		mux.HandleFunc(bannerPath, ac.BannerHandler)
+	}
+
+	// Register the reverse proxies, if configured by a configuration script
+	for _, rp := range ac.reverseProxies {
+		ac.RegisterReverseProxy(mux, rp.Prefix, rp.Target)
+	}
+
+	// Register the WebDAV handlers, if configured by a configuration script
+	for _, wd := range ac.webDAVs {
+		ac.RegisterWebDAV(mux, wd.Prefix, wd.Dir, wd.Admin)
+	}
+}
+
 // MustServe sets up a server with handlers
 func (ac *Config) MustServe(mux *http.ServeMux) error {
 	var err error
@@ -641,14 +944,9 @@ func (ac *Config) MustServe(mux *http.ServeMux) error {
 		ac.serveJustHTTP = true
 	}
 
-	// Console output
-	if !ac.quietMode && !ac.singleFileMode && !ac.simpleMode && !ac.noBanner {
-		// Output a colorful ansi logo if a proper terminal is available
-		fmt.Println(platformdep.Banner(ac.versionString, ac.description))
-	} else if !ac.quietMode {
-		timestamp := time.Now().Format("2006-01-02 15:04")
-		colorstring.Println("[cyan]" + ac.versionString + "[dark_gray] - " + timestamp + "[reset]")
-	}
+	// Console output. The banner is output after the configuration scripts
+	// have been run, since they may change it with SetBanner.
+	showBanner := !ac.quietMode && !ac.singleFileMode && !ac.simpleMode
 
 	// Disable the database backend if the BoltDB filename is the /dev/null file (or OS equivalent)
 	if ac.boltFilename == os.DevNull {
@@ -736,6 +1034,15 @@ func (ac *Config) MustServe(mux *http.ServeMux) error {
 	// Only keep the active ones. Used when outputting server information.
 	ac.serverConfigurationFilenames = ranConfigurationFilenames
 
+	if showBanner && !ac.noBanner {
+		// Output a colorful ansi logo if a proper terminal is available
+		fmt.Print(c.Color("[reset]"))
+		fmt.Println(ac.ServerBanner())
+	} else if !ac.quietMode {
+		timestamp := time.Now().Format("2006-01-02 15:04")
+		colorstring.Println("[cyan]" + ac.versionString + "[dark_gray] - " + timestamp + "[reset]")
+	}
+
 	// Run the standalone Lua server, if specified
 	if ac.luaServerFilename != "" {
 		// Run the Lua server file and set up handlers
@@ -757,6 +1064,9 @@ func (ac *Config) MustServe(mux *http.ServeMux) error {
 		ac.RegisterHandlers(mux, "/", ac.serverDirOrFilename, ac.serverAddDomain)
 	}
 
+	// Register the handlers that are enabled by configuration scripts
+	ac.registerConfiguredHandlers(mux)
+
 	// Set the values that has not been set by flags nor scripts
 	// (and can be set by both)
 	ranServerReadyFunction := ac.finalConfiguration(ac.serverHost)
@@ -836,6 +1146,12 @@ func (ac *Config) MustServe(mux *http.ServeMux) error {
 		platformdep.IgnoreTerminalResizeSignal()
 	}
 
+	// Reload the configuration scripts when SIGHUP is received
+	ac.ReloadOnSignal()
+
+	// Reopen the log file when SIGUSR1 (or the configured signal) is received
+	ac.ReopenLogOnSignal()
+
 	// Run the shutdown functions if graceful does not
 	defer ac.GenerateShutdownFunction(nil, nil)()
 
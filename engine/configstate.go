@@ -0,0 +1,71 @@
+package engine
+
+// Calling Lua functions from the configuration scripts, after the scripts have run
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// configLockedKey is the context key for requests that are handled while
+// holding ac.configMutex, by a function from a configuration script
+type configLockedKey struct{}
+
+// ConfigFunction is a Lua function from a configuration script, together
+// with the Lua state of the configuration script. The function uses the
+// global variables of that state, which are not safe for concurrent use, so
+// the state is kept out of the Lua pool, and the function is only called
+// while holding ac.configMutex.
+type ConfigFunction struct {
+	L  *lua.LState
+	fn *lua.LFunction
+}
+
+// NewConfigFunction returns the given Lua function, together with the Lua
+// state of the configuration script that is running it
+func NewConfigFunction(L *lua.LState, fn *lua.LFunction) *ConfigFunction {
+	return &ConfigFunction{L, fn}
+}
+
+// callConfigFunction calls the given Lua function with the given arguments,
+// and returns the first returned value
+func (ac *Config) callConfigFunction(cf *ConfigFunction, args ...lua.LValue) (lua.LValue, error) {
+	ac.configMutex.Lock()
+	defer ac.configMutex.Unlock()
+	return cf.call(args...)
+}
+
+// withConfigLocked marks the given request as handled while holding
+// ac.configMutex, so that the Lua functions from the configuration script
+// that are called while handling it, like template functions, do not wait
+// for the mutex
+func withConfigLocked(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), configLockedKey{}, true))
+}
+
+// callConfigFunctionFor calls the given Lua function, like callConfigFunction,
+// while handling the given request
+func (ac *Config) callConfigFunctionFor(req *http.Request, cf *ConfigFunction, args ...lua.LValue) (lua.LValue, error) {
+	if req != nil && req.Context().Value(configLockedKey{}) != nil {
+		return cf.call(args...)
+	}
+	return ac.callConfigFunction(cf, args...)
+}
+
+// call calls the Lua function in the Lua state of the configuration script.
+// ac.configMutex must be held.
+func (cf *ConfigFunction) call(args ...lua.LValue) (lua.LValue, error) {
+	L := cf.L
+	L.Push(cf.fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(args), 1, nil); err != nil {
+		return lua.LNil, err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return result, nil
+}
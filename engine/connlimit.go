@@ -0,0 +1,152 @@
+package engine
+
+// Limiting the number of simultaneous connections from each client IP
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How long a QUIC client address is counted after the last packet from it,
+// if no idle timeout is configured. This is the default of the QUIC package.
+const defaultQUICPeerIdle = 30 * time.Second
+
+// connLimiter counts the open TCP connections and the active QUIC client
+// addresses for each client IP, and refuses the ones over the limit, as set
+// with SetMaxConnsPerIP. It is shared with reloaded configurations.
+type connLimiter struct {
+	mut   sync.Mutex
+	max   int
+	conns map[string]int                  // TCP connections per IP
+	peers map[string]map[string]time.Time // QUIC client addresses per IP, with the time of the last packet
+	swept time.Time
+}
+
+// newConnLimiter returns a connLimiter without a limit
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		conns: make(map[string]int),
+		peers: make(map[string]map[string]time.Time),
+	}
+}
+
+// setMax sets the maximum number of connections per client IP. 0 disables the limit.
+func (cl *connLimiter) setMax(max int) {
+	cl.mut.Lock()
+	cl.max = max
+	cl.mut.Unlock()
+}
+
+// addrIP returns the IP address of the given network address
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// connState counts the TCP connections for each client IP, and closes new
+// connections over the limit before anything is read from them. Meant to be
+// used as the ConnState function of a http.Server.
+func (cl *connLimiter) connState(conn net.Conn, state http.ConnState) {
+	ip := addrIP(conn.RemoteAddr())
+	cl.mut.Lock()
+	defer cl.mut.Unlock()
+	switch state {
+	case http.StateNew:
+		cl.conns[ip]++
+		if cl.max > 0 && cl.conns[ip] > cl.max {
+			// The connection is counted down again when it is closed
+			log.Warn("Too many connections from ", ip)
+			conn.Close()
+		}
+	case http.StateClosed, http.StateHijacked:
+		if cl.conns[ip] <= 1 {
+			delete(cl.conns, ip)
+		} else {
+			cl.conns[ip]--
+		}
+	}
+}
+
+// allowPacket checks if a UDP packet from the given address can be passed on
+// to the QUIC server. Each client address that has sent packets within the
+// given idle time counts as a connection. Packets from new client addresses
+// are dropped if the client IP already has the maximum number of connections,
+// so that the QUIC handshake can not complete.
+func (cl *connLimiter) allowPacket(addr net.Addr, idle time.Duration) bool {
+	cl.mut.Lock()
+	defer cl.mut.Unlock()
+	if cl.max <= 0 {
+		return true
+	}
+	now := time.Now()
+	if now.Sub(cl.swept) > idle {
+		// Forget the client addresses that have been idle for too long
+		for ip, peers := range cl.peers {
+			for peer, seen := range peers {
+				if now.Sub(seen) > idle {
+					delete(peers, peer)
+				}
+			}
+			if len(peers) == 0 {
+				delete(cl.peers, ip)
+			}
+		}
+		cl.swept = now
+	}
+	ip, peer := addrIP(addr), addr.String()
+	peers := cl.peers[ip]
+	if peers == nil {
+		peers = make(map[string]time.Time)
+		cl.peers[ip] = peers
+	}
+	if seen, ok := peers[peer]; ok && now.Sub(seen) <= idle {
+		peers[peer] = now
+		return true
+	}
+	delete(peers, peer)
+	for p, seen := range peers {
+		if now.Sub(seen) > idle {
+			delete(peers, p)
+		}
+	}
+	if len(peers) >= cl.max {
+		return false
+	}
+	peers[peer] = now
+	return true
+}
+
+// limitedPacketConn is a net.PacketConn for the QUIC server that drops the
+// packets from client addresses over the limit
+type limitedPacketConn struct {
+	net.PacketConn
+	cl   *connLimiter
+	idle time.Duration
+}
+
+// ReadFrom reads the next packet that is allowed by the connection limit
+func (lpc *limitedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := lpc.PacketConn.ReadFrom(p)
+		if err != nil || lpc.cl.allowPacket(addr, lpc.idle) {
+			return n, addr, err
+		}
+	}
+}
+
+// limitPacketConn wraps the given UDP connection for the QUIC server, so that
+// the number of QUIC connections from each client IP is limited
+func (ac *Config) limitPacketConn(conn net.PacketConn) net.PacketConn {
+	idle := time.Duration(ac.idleTimeout) * time.Second
+	if idle <= 0 {
+		idle = defaultQUICPeerIdle
+	}
+	return &limitedPacketConn{PacketConn: conn, cl: ac.connLimiter, idle: idle}
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+// testConn is a net.Conn with a remote address, that keeps track of if it was closed
+type testConn struct {
+	net.Conn
+	addr   net.Addr
+	closed bool
+}
+
+func (c *testConn) RemoteAddr() net.Addr { return c.addr }
+func (c *testConn) Close() error         { c.closed = true; return nil }
+
+func TestConnLimiterConnState(t *testing.T) {
+	cl := newConnLimiter()
+	cl.setMax(2)
+	newConn := func(ip string, port int) *testConn {
+		return &testConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+	}
+	a, b, c := newConn("10.0.0.1", 1000), newConn("10.0.0.1", 1001), newConn("10.0.0.1", 1002)
+	other := newConn("10.0.0.2", 1000)
+	for _, conn := range []*testConn{a, b, c, other} {
+		cl.connState(conn, http.StateNew)
+	}
+	assert.Equal(t, a.closed || b.closed, false)
+	assert.Equal(t, c.closed, true)
+	assert.Equal(t, other.closed, false)
+
+	// When connections are closed, new ones can be opened
+	cl.connState(c, http.StateClosed)
+	cl.connState(a, http.StateHijacked)
+	d := newConn("10.0.0.1", 1003)
+	cl.connState(d, http.StateNew)
+	assert.Equal(t, d.closed, false)
+	cl.connState(b, http.StateClosed)
+	cl.connState(d, http.StateClosed)
+	cl.connState(other, http.StateClosed)
+	assert.Equal(t, len(cl.conns), 0)
+}
+
+func TestConnLimiterAllowPacket(t *testing.T) {
+	cl := newConnLimiter()
+	peer := func(ip string, port int) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+	}
+	idle := time.Minute
+
+	// No limit
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.1", 1000), idle), true)
+
+	cl.setMax(1)
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.1", 1000), idle), true)
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.1", 1000), idle), true)
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.1", 1001), idle), false)
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.2", 1000), idle), true)
+
+	// Idle client addresses are no longer counted
+	cl.peers["10.0.0.1"]["10.0.0.1:1000"] = time.Now().Add(-2 * idle)
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.1", 1001), idle), true)
+	assert.Equal(t, cl.allowPacket(peer("10.0.0.1", 1000), idle), false)
+}
@@ -0,0 +1,169 @@
+package engine
+
+// Reading and writing cookies, other than the login cookie
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// sameSiteNoneMode is the same as http.SameSiteNoneMode, which needs Go 1.13.
+// With earlier versions of Go, the SameSite attribute is then left out.
+const sameSiteNoneMode http.SameSite = 4
+
+// signCookieValue returns the given value with a HMAC signature appended.
+// The name of the cookie is signed together with the value, so that a signed
+// value can not be moved to a cookie with another name.
+func signCookieValue(name, value, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(name + "\x00" + value))
+	return value + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCookieValue returns the value from the given signed value, if the
+// signature is valid for the cookie with the given name
+func verifyCookieValue(name, signed, secret string) (string, bool) {
+	pos := strings.LastIndex(signed, ".")
+	if pos < 0 {
+		return "", false
+	}
+	value := signed[:pos]
+	return value, hmac.Equal([]byte(signCookieValue(name, value, secret)), []byte(signed))
+}
+
+// cookieSigningSecret returns the secret that signed cookies are signed with,
+// which is the same as for the login cookies
+func (ac *Config) cookieSigningSecret() (string, error) {
+	secret := ac.cookieSecret
+	if ac.perm != nil {
+		secret = ac.perm.UserState().CookieSecret()
+	}
+	if secret == "" {
+		return "", errors.New("a cookie secret is needed for signed cookies")
+	}
+	return secret, nil
+}
+
+// Table2Cookie applies the options in the given Lua table to the given
+// cookie. The options are "maxage" (in seconds), "path", "domain", "secure",
+// "httponly" and "samesite" ("lax", "strict" or "none").
+func Table2Cookie(table *lua.LTable, cookie *http.Cookie) error {
+	if table == nil {
+		return nil
+	}
+	if v, ok := table.RawGetString("maxage").(lua.LNumber); ok {
+		cookie.MaxAge = int(v)
+	}
+	if v, ok := table.RawGetString("path").(lua.LString); ok {
+		cookie.Path = string(v)
+	}
+	if v, ok := table.RawGetString("domain").(lua.LString); ok {
+		cookie.Domain = string(v)
+	}
+	if v, ok := table.RawGetString("secure").(lua.LBool); ok {
+		cookie.Secure = bool(v)
+	}
+	if v, ok := table.RawGetString("httponly").(lua.LBool); ok {
+		cookie.HttpOnly = bool(v)
+	}
+	if v, ok := table.RawGetString("samesite").(lua.LString); ok {
+		switch strings.ToLower(string(v)) {
+		case "lax":
+			cookie.SameSite = http.SameSiteLaxMode
+		case "strict":
+			cookie.SameSite = http.SameSiteStrictMode
+		case "none":
+			cookie.SameSite = sameSiteNoneMode
+		default:
+			return errors.New("samesite must be \"lax\", \"strict\" or \"none\"")
+		}
+	}
+	return nil
+}
+
+// LoadCookieFunctions makes functions for reading and writing cookies available
+func (ac *Config) LoadCookieFunctions(w http.ResponseWriter, req *http.Request, L *lua.LState) {
+
+	// Return the value of the cookie with the given name, or an empty string.
+	// If the second argument is true, the signature is checked, and an empty
+	// string is returned if it is not valid.
+	L.SetGlobal("getcookie", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			L.Push(lua.LString(""))
+			return 1 // number of results
+		}
+		value := cookie.Value
+		if L.OptBool(2, false) {
+			secret, err := ac.cookieSigningSecret()
+			if err != nil {
+				L.Push(lua.LString(""))
+				return 1 // number of results
+			}
+			var ok bool
+			if value, ok = verifyCookieValue(name, value, secret); !ok {
+				L.Push(lua.LString(""))
+				return 1 // number of results
+			}
+		}
+		L.Push(lua.LString(value))
+		return 1 // number of results
+	}))
+
+	// Set a cookie, given a name, a value and an optional table with options.
+	// If "signed" is true in the table, the value is signed with the cookie
+	// secret. Returns true, or false and an error string.
+	L.SetGlobal("setcookie", L.NewFunction(func(L *lua.LState) int {
+		cookie := &http.Cookie{
+			Name:     L.CheckString(1),
+			Value:    L.CheckString(2),
+			Path:     "/",
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		}
+		options := L.OptTable(3, nil)
+		err := Table2Cookie(options, cookie)
+		if err == nil && options != nil && lua.LVAsBool(options.RawGetString("signed")) {
+			var secret string
+			if secret, err = ac.cookieSigningSecret(); err == nil {
+				cookie.Value = signCookieValue(cookie.Name, cookie.Value, secret)
+			}
+		}
+		if err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		http.SetCookie(w, cookie)
+		L.Push(lua.LTrue)
+		return 1 // number of results
+	}))
+
+	// Remove the cookie with the given name. Takes an optional table with
+	// "path" and "domain", which must be the same as when it was set.
+	L.SetGlobal("delcookie", L.NewFunction(func(L *lua.LState) int {
+		cookie := &http.Cookie{
+			Name:   L.CheckString(1),
+			Path:   "/",
+			MaxAge: -1,
+		}
+		if options := L.OptTable(2, nil); options != nil {
+			if v, ok := options.RawGetString("path").(lua.LString); ok {
+				cookie.Path = string(v)
+			}
+			if v, ok := options.RawGetString("domain").(lua.LString); ok {
+				cookie.Domain = string(v)
+			}
+		}
+		http.SetCookie(w, cookie)
+		return 0 // number of results
+	}))
+
+}
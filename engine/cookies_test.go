@@ -0,0 +1,30 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestVerifyCookieValue(t *testing.T) {
+	signed := signCookieValue("theme", "dark", "secret")
+	for _, tc := range []struct {
+		name, signed, secret string
+		value                string
+		valid                bool
+	}{
+		{"theme", signed, "secret", "dark", true},
+		{"theme", signed, "other secret", "dark", false},
+		{"lang", signed, "secret", "dark", false},
+		{"theme", "light" + signed[len("dark"):], "secret", "light", false},
+		{"theme", signed + "0", "secret", "dark", false},
+		{"theme", "dark", "secret", "", false},
+		{"theme", "", "secret", "", false},
+	} {
+		value, ok := verifyCookieValue(tc.name, tc.signed, tc.secret)
+		assert.Equal(t, ok, tc.valid)
+		if tc.valid || tc.value != "" {
+			assert.Equal(t, value, tc.value)
+		}
+	}
+}
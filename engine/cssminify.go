@@ -0,0 +1,121 @@
+package engine
+
+// Minification of CSS, for GCSS output and for served .css files
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// minifiedCSSFile is a minified .css file, together with the modification
+// time of the source file at the time it was minified
+type minifiedCSSFile struct {
+	modTime time.Time
+	data    []byte
+}
+
+var (
+	// Minified .css files, by filename
+	minifiedCSSFiles = make(map[string]*minifiedCSSFile)
+	minifiedCSSMutex sync.RWMutex
+)
+
+// cssSeparator checks if whitespace around the given byte can be removed
+func cssSeparator(b byte) bool {
+	switch b {
+	case '{', '}', ';', ',':
+		return true
+	}
+	return false
+}
+
+// cssSeparatorBefore checks if whitespace after the given byte can be
+// removed. Whitespace after a colon can be removed, but not before it, since
+// "a :hover" and "a:hover" are different selectors.
+func cssSeparatorBefore(b byte) bool {
+	return cssSeparator(b) || b == ':'
+}
+
+// isSpace checks if the given byte is CSS whitespace
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\r', '\f':
+		return true
+	}
+	return false
+}
+
+// MinifyCSS removes comments and whitespace that is not needed from the given CSS.
+// Strings are left as they are.
+func MinifyCSS(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	pendingSpace := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			// Skip the comment
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end == -1 {
+				i = len(data)
+			} else {
+				// Continue at the final "/" of the comment
+				i += end + 3
+			}
+			pendingSpace = true
+		case c == '"' || c == '\'':
+			if pendingSpace && buf.Len() > 0 && !cssSeparatorBefore(buf.Bytes()[buf.Len()-1]) {
+				buf.WriteByte(' ')
+			}
+			pendingSpace = false
+			// Copy the string, including escaped quotes
+			start := i
+			for i++; i < len(data) && data[i] != c; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(data) {
+				i = len(data) - 1
+			}
+			buf.Write(data[start : i+1])
+		case isSpace(c):
+			pendingSpace = true
+		default:
+			if c == '}' && buf.Len() > 0 && buf.Bytes()[buf.Len()-1] == ';' {
+				// The last semicolon in a block is not needed
+				buf.Truncate(buf.Len() - 1)
+			}
+			if pendingSpace && buf.Len() > 0 && !cssSeparator(c) && !cssSeparatorBefore(buf.Bytes()[buf.Len()-1]) {
+				buf.WriteByte(' ')
+			}
+			pendingSpace = false
+			buf.WriteByte(c)
+		}
+	}
+	return buf.Bytes()
+}
+
+// minifiedCSS returns the minified contents of the given .css file.
+// The result is kept in memory until the file is modified.
+func minifiedCSS(filename string, fInfo os.FileInfo) ([]byte, error) {
+	minifiedCSSMutex.RLock()
+	mf, found := minifiedCSSFiles[filename]
+	minifiedCSSMutex.RUnlock()
+	if found && mf.modTime.Equal(fInfo.ModTime()) {
+		return mf.data, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mf = &minifiedCSSFile{fInfo.ModTime(), MinifyCSS(data)}
+	minifiedCSSMutex.Lock()
+	minifiedCSSFiles[filename] = mf
+	minifiedCSSMutex.Unlock()
+	return mf.data, nil
+}
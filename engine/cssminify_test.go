@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestMinifyCSS(t *testing.T) {
+	for _, tc := range []struct {
+		css, minified string
+	}{
+		{"body {\n  color: red;\n  margin : 0;\n}\n", "body{color:red;margin :0}"},
+		{"/* comment */ a , b { color: blue; }", "a,b{color:blue}"},
+		{"a :hover { color: red }", "a :hover{color:red}"},
+		{"a:hover { color: red }", "a:hover{color:red}"},
+		{"ul > li { margin: 0 }", "ul > li{margin:0}"},
+		{"div *:first-child { padding: 0 }", "div *:first-child{padding:0}"},
+		{"a::after { content: \"  a ; b  \" }", "a::after{content:\"  a ; b  \"}"},
+		{"@media (max-width: 600px) { a { color: red; } }", "@media (max-width:600px){a{color:red}}"},
+	} {
+		assert.Equal(t, string(MinifyCSS([]byte(tc.css))), tc.minified)
+	}
+}
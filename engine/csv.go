@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+)
+
+// For reading and writing CSV files
+
+// csvOptions are the options that can be given to csv_read and csv_write
+type csvOptions struct {
+	delimiter  rune
+	comment    rune
+	header     bool
+	lazyQuotes bool
+	quoteAll   bool
+	columns    []string
+}
+
+// parseCSVOptions reads the options from an optional Lua table
+func parseCSVOptions(table *lua.LTable) (*csvOptions, error) {
+	opts := &csvOptions{delimiter: ','}
+	if table == nil {
+		return opts, nil
+	}
+	if s, ok := table.RawGetString("delimiter").(lua.LString); ok {
+		r, size := utf8.DecodeRuneInString(string(s))
+		if r == utf8.RuneError || size != len(s) {
+			return nil, errors.New("the delimiter must be a single character")
+		}
+		opts.delimiter = r
+	}
+	if s, ok := table.RawGetString("comment").(lua.LString); ok {
+		r, size := utf8.DecodeRuneInString(string(s))
+		if r == utf8.RuneError || size != len(s) {
+			return nil, errors.New("the comment character must be a single character")
+		}
+		opts.comment = r
+	}
+	opts.header = lua.LVAsBool(table.RawGetString("header"))
+	opts.lazyQuotes = lua.LVAsBool(table.RawGetString("lazy_quotes"))
+	opts.quoteAll = lua.LVAsBool(table.RawGetString("quote_all"))
+	if columns, ok := table.RawGetString("columns").(*lua.LTable); ok {
+		opts.columns = convert.Table2strings(columns)
+	}
+	return opts, nil
+}
+
+// ReadCSV reads all records from a CSV file. If the header option is set,
+// the first record is used as keys for the following records.
+func ReadCSV(L *lua.LState, filename string, opts *csvOptions) (*lua.LTable, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = opts.delimiter
+	r.Comment = opts.comment
+	r.LazyQuotes = opts.lazyQuotes
+	r.FieldsPerRecord = -1
+
+	rows := L.NewTable()
+	var header []string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if opts.header && header == nil {
+			header = record
+			continue
+		}
+		row := L.NewTable()
+		for i, field := range record {
+			if header != nil && i < len(header) {
+				row.RawSetString(header[i], lua.LString(field))
+			} else {
+				row.Append(lua.LString(field))
+			}
+		}
+		rows.Append(row)
+	}
+	return rows, nil
+}
+
+// quoteCSVField quotes a field, even if it is not needed
+func quoteCSVField(field string) string {
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
+
+// WriteCSV writes the rows in the given Lua table to a CSV file. Rows are
+// either lists of values or, if the header option is set, tables with
+// column names as keys.
+func WriteCSV(filename string, rows *lua.LTable, opts *csvOptions, perm os.FileMode) error {
+	var records [][]string
+
+	if opts.header {
+		columns := opts.columns
+		if len(columns) == 0 {
+			// Use the sorted keys of the first row as the columns
+			if first, ok := rows.RawGetInt(1).(*lua.LTable); ok {
+				first.ForEach(func(key, _ lua.LValue) {
+					columns = append(columns, key.String())
+				})
+				sort.Strings(columns)
+			}
+		}
+		records = append(records, columns)
+		for i := 1; i <= rows.Len(); i++ {
+			row, ok := rows.RawGetInt(i).(*lua.LTable)
+			if !ok {
+				return errors.New("each row must be a table")
+			}
+			record := make([]string, len(columns))
+			for j, column := range columns {
+				if value := row.RawGetString(column); value != lua.LNil {
+					record[j] = value.String()
+				}
+			}
+			records = append(records, record)
+		}
+	} else {
+		for i := 1; i <= rows.Len(); i++ {
+			row, ok := rows.RawGetInt(i).(*lua.LTable)
+			if !ok {
+				return errors.New("each row must be a table")
+			}
+			record := make([]string, row.Len())
+			for j := range record {
+				record[j] = row.RawGetInt(j + 1).String()
+			}
+			records = append(records, record)
+		}
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if opts.quoteAll {
+		// The csv package only quotes fields when needed
+		delimiter := string(opts.delimiter)
+		for _, record := range records {
+			quoted := make([]string, len(record))
+			for i, field := range record {
+				quoted[i] = quoteCSVField(field)
+			}
+			if _, err := io.WriteString(f, strings.Join(quoted, delimiter)+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := csv.NewWriter(f)
+	w.Comma = opts.delimiter
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// LoadCSV makes functions for reading and writing CSV files available.
+// Filenames are relative to the given directory.
+func (ac *Config) LoadCSV(L *lua.LState, scriptdir string) {
+
+	// Read a CSV file and return a table of rows, or nil and an error message
+	L.SetGlobal("csv_read", L.NewFunction(func(L *lua.LState) int {
+		filename := filepath.Join(scriptdir, L.CheckString(1))
+		opts, err := parseCSVOptions(L.OptTable(2, nil))
+		if err != nil {
+			L.ArgError(2, err.Error())
+		}
+		rows, err := ReadCSV(L, filename, opts)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(rows)
+		return 1 // number of results
+	}))
+
+	// Write a table of rows to a CSV file. Returns true, or false and an error message.
+	L.SetGlobal("csv_write", L.NewFunction(func(L *lua.LState) int {
+		filename := filepath.Join(scriptdir, L.CheckString(1))
+		rows := L.CheckTable(2)
+		opts, err := parseCSVOptions(L.OptTable(3, nil))
+		if err != nil {
+			L.ArgError(3, err.Error())
+		}
+		if err := WriteCSV(filename, rows, opts, ac.defaultPermissions); err != nil {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+}
@@ -4,14 +4,18 @@ package engine
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-gcfg/gcfg"
 	log "github.com/sirupsen/logrus"
 	"github.com/xyproto/algernon/themes"
 	"github.com/xyproto/algernon/utils"
+	"github.com/xyproto/pongo2"
 )
 
 var (
@@ -33,16 +37,31 @@ type DirConfig struct {
 	}
 }
 
-// DirectoryListing serves the given directory as a web page with links the the contents
-func (ac *Config) DirectoryListing(w http.ResponseWriter, req *http.Request, rootdir, dirname, theme string) {
+// IndexFilenames returns the filenames that are served instead of a directory
+// listing, in order of priority, as set with SetIndexFiles
+func (ac *Config) IndexFilenames() []string {
+	if len(ac.indexFiles) > 0 {
+		return ac.indexFiles
+	}
+	return indexFilenames
+}
+
+// DirEntry is an entry in a directory listing, as passed to directory listing templates
+type DirEntry struct {
+	Name    string
+	URL     string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// DirectoryEntries returns the entries of the given directory, for use in a
+// directory listing. Hidden files are only included if enabled in the configuration.
+func (ac *Config) DirectoryEntries(rootdir, dirname string) []DirEntry {
 	var (
-		buf          bytes.Buffer
+		entries      []DirEntry
 		fullFilename string
-		URLpath      string
-		title        = dirname
 	)
-
-	// Fill the coming HTML body with a list of all the filenames in `dirname`
 	for _, filename := range utils.GetFilenames(dirname) {
 
 		if filename == dirconfFilename {
@@ -50,6 +69,11 @@ func (ac *Config) DirectoryListing(w http.ResponseWriter, req *http.Request, roo
 			continue
 		}
 
+		// Skip hidden files, unless they should be listed
+		if !ac.dirListingHidden && strings.HasPrefix(filename, ".") {
+			continue
+		}
+
 		// Find the full name
 		fullFilename = dirname
 
@@ -61,12 +85,32 @@ func (ac *Config) DirectoryListing(w http.ResponseWriter, req *http.Request, roo
 		// Add the filename at the end
 		fullFilename += filename
 
-		// Remove the root directory from the link path
-		URLpath = fullFilename[len(rootdir)+1:]
-
-		// Output different entries for files and directories
-		buf.WriteString(themes.HTMLLink(filename, URLpath, ac.fs.IsDir(fullFilename)))
+		entry := DirEntry{
+			Name: filename,
+			// Remove the root directory from the link path
+			URL:   "/" + fullFilename[len(rootdir)+1:],
+			IsDir: ac.fs.IsDir(fullFilename),
+		}
+		if entry.IsDir {
+			entry.URL += "/"
+		}
+		if fi, err := os.Stat(fullFilename); err == nil {
+			entry.Size = fi.Size()
+			entry.ModTime = fi.ModTime()
+		}
+		entries = append(entries, entry)
 	}
+	return entries
+}
+
+// DirectoryListing serves the given directory as a web page with links the the contents
+func (ac *Config) DirectoryListing(w http.ResponseWriter, req *http.Request, rootdir, dirname, theme string) {
+	var (
+		buf   bytes.Buffer
+		title = dirname
+	)
+
+	entries := ac.DirectoryEntries(rootdir, dirname)
 
 	// Read directory configuration, if present
 	fullDirConfFilename := filepath.Join(dirname, dirconfFilename)
@@ -91,12 +135,35 @@ func (ac *Config) DirectoryListing(w http.ResponseWriter, req *http.Request, roo
 		}
 	}
 
-	// Check if the current page contents are empty
-	if buf.Len() == 0 {
-		buf.WriteString("Empty directory")
-	}
+	var htmldata []byte
+	if ac.dirListingTemplate != "" {
+		// Render the directory listing with the given Pongo2 template
+		data, err := ac.dirListingPage(req, title, entries)
+		if err != nil {
+			if ac.debugMode {
+				templateData, _ := ioutil.ReadFile(ac.dirListingTemplate)
+				ac.PrettyError(w, req, ac.dirListingTemplate, templateData, err.Error(), "pongo2")
+			} else {
+				log.Errorf("Could not render the directory listing template %s: %s", ac.dirListingTemplate, err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		htmldata = data
+	} else {
+		// Fill the coming HTML body with a list of all the filenames in `dirname`
+		for _, entry := range entries {
+			// Output different entries for files and directories
+			buf.WriteString(themes.HTMLLink(entry.Name, strings.TrimSuffix(entry.URL[1:], "/"), entry.IsDir))
+		}
+
+		// Check if the current page contents are empty
+		if buf.Len() == 0 {
+			buf.WriteString("Empty directory")
+		}
 
-	htmldata := themes.MessagePageBytes(title, buf.Bytes(), theme)
+		htmldata = themes.MessagePageBytes(title, buf.Bytes(), theme)
+	}
 
 	// If the auto-refresh feature has been enabled
 	if ac.autoRefresh {
@@ -109,6 +176,34 @@ func (ac *Config) DirectoryListing(w http.ResponseWriter, req *http.Request, roo
 	ac.DataToClient(w, req, dirname, htmldata)
 }
 
+// dirListingPage renders the directory listing template. The template is given
+// the title, the URL path and a list of entries with name, url, size, modtime and isdir.
+func (ac *Config) dirListingPage(req *http.Request, title string, entries []DirEntry) ([]byte, error) {
+	templateData, err := ac.cache.Read(ac.dirListingTemplate, ac.shouldCache(filepath.Ext(ac.dirListingTemplate)))
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := pongo2.FromString(templateData.String())
+	if err != nil {
+		return nil, err
+	}
+	entryMaps := make([]pongo2.Context, len(entries))
+	for i, entry := range entries {
+		entryMaps[i] = pongo2.Context{
+			"name":    entry.Name,
+			"url":     entry.URL,
+			"size":    entry.Size,
+			"modtime": entry.ModTime,
+			"isdir":   entry.IsDir,
+		}
+	}
+	return tpl.ExecuteBytes(pongo2.Context{
+		"title":   title,
+		"path":    req.URL.Path,
+		"entries": entryMaps,
+	})
+}
+
 // DirPage serves a directory, using index.* files, if present.
 // The directory must exist.
 // rootdir is the base directory (can be ".")
@@ -132,7 +227,7 @@ func (ac *Config) DirPage(w http.ResponseWriter, req *http.Request, rootdir, dir
 
 	// Handle the serving of index files, if needed
 	var filename string
-	for _, indexfile := range indexFilenames {
+	for _, indexfile := range ac.IndexFilenames() {
 		filename = filepath.Join(dirname, indexfile)
 		if ac.fs.Exists(filename) {
 			ac.FilePage(w, req, filename, ac.defaultLuaDataFilename)
@@ -140,6 +235,12 @@ func (ac *Config) DirPage(w http.ResponseWriter, req *http.Request, rootdir, dir
 		}
 	}
 
+	// Directory listings may have been disabled in the server configuration
+	if ac.noDirListing {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	// Serve a directory listing if no index file is found
 	ac.DirectoryListing(w, req, rootdir, dirname, theme)
 }
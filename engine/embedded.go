@@ -0,0 +1,114 @@
+//go:build go1.16
+// +build go1.16
+
+package engine
+
+// Serving static files that are embedded in the executable.
+// Go 1.15 and earlier use embedded_go115.go instead, since io/fs is needed.
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/xyproto/datablock"
+)
+
+// EmbeddedMount is an embedded filesystem that is served at a URL path prefix
+type EmbeddedMount struct {
+	Name   string // The name that the filesystem was registered with
+	Prefix string // URL path prefix, like "/static"
+}
+
+var (
+	// Embedded filesystems, by name
+	embeddedFileSystems = make(map[string]fs.FS)
+	embeddedMutex       sync.RWMutex
+
+	// Data blocks for embedded files that have been served,
+	// by filesystem name and path. The files never change.
+	embeddedBlocks sync.Map
+)
+
+// RegisterEmbeddedFS makes a filesystem, typically an embed.FS, available to
+// ServeEmbedded in the server configuration script. This is meant to be called
+// from the main package of an executable that embeds a directory of assets:
+//
+//	//go:embed site
+//	var site embed.FS
+//
+//	engine.RegisterEmbeddedFS("site", site)
+func RegisterEmbeddedFS(name string, fsys fs.FS) {
+	embeddedMutex.Lock()
+	embeddedFileSystems[name] = fsys
+	embeddedMutex.Unlock()
+}
+
+// embeddedFS returns the embedded filesystem with the given name, if registered
+func embeddedFS(name string) (fs.FS, bool) {
+	embeddedMutex.RLock()
+	defer embeddedMutex.RUnlock()
+	fsys, ok := embeddedFileSystems[name]
+	return fsys, ok
+}
+
+// embeddedFile finds the embedded file for the given URL path, if any.
+// Returns the filesystem, the filesystem name and the path within the
+// filesystem. For directories, index.html is used.
+func (ac *Config) embeddedFile(urlpath string) (fs.FS, string, string, bool) {
+	for _, mount := range ac.embeddedMounts {
+		if !strings.HasPrefix(urlpath, mount.Prefix) {
+			continue
+		}
+		fsys, ok := embeddedFS(mount.Name)
+		if !ok {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(urlpath, mount.Prefix)), "/")
+		if name == "" {
+			name = "."
+		}
+		fInfo, err := fs.Stat(fsys, name)
+		if err != nil {
+			continue
+		}
+		if fInfo.IsDir() {
+			name = path.Join(name, "index.html")
+			if _, err := fs.Stat(fsys, name); err != nil {
+				continue
+			}
+		}
+		return fsys, mount.Name, name, true
+	}
+	return nil, "", "", false
+}
+
+// EmbeddedPage serves a file from an embedded filesystem. The file data is
+// kept in a data block, so that it is only compressed once.
+func (ac *Config) EmbeddedPage(w http.ResponseWriter, req *http.Request, fsys fs.FS, fsName, name string) error {
+	key := fsName + ":" + name
+	var block *datablock.DataBlock
+	if v, ok := embeddedBlocks.Load(key); ok {
+		block = v.(*datablock.DataBlock)
+	} else {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		block = datablock.NewDataBlock(data, true)
+		embeddedBlocks.Store(key, block)
+	}
+	if ac.mimereader != nil {
+		ac.mimereader.SetHeader(w, path.Ext(name))
+	}
+	block.ToClient(w, req, name, ac.ClientCanGzip(req), gzipThreshold)
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build !go1.16
+// +build !go1.16
+
+package engine
+
+// Embedded filesystems need io/fs, from Go 1.16. Go 1.16 and later use
+// embedded.go instead.
+
+import (
+	"errors"
+	"net/http"
+)
+
+// EmbeddedMount is an embedded filesystem that is served at a URL path prefix
+type EmbeddedMount struct {
+	Name   string // The name that the filesystem was registered with
+	Prefix string // URL path prefix, like "/static"
+}
+
+// embeddedFileSystem stands in for fs.FS
+type embeddedFileSystem interface{}
+
+// embeddedFS returns false, since no embedded filesystems can be registered
+func embeddedFS(name string) (embeddedFileSystem, bool) {
+	return nil, false
+}
+
+// embeddedFile returns false, since no embedded filesystems can be registered
+func (ac *Config) embeddedFile(urlpath string) (embeddedFileSystem, string, string, bool) {
+	return nil, "", "", false
+}
+
+// EmbeddedPage returns an error, since embedded filesystems need Go 1.16
+func (ac *Config) EmbeddedPage(w http.ResponseWriter, req *http.Request, fsys embeddedFileSystem, fsName, name string) error {
+	return errors.New("embedded filesystems need Go 1.16 or later")
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/pongo2"
+)
+
+// errorPageTemplate returns the Pongo2 template filename that is configured
+// for the given HTTP status code, or for all error codes, if any
+func (ac *Config) errorPageTemplate(code int) string {
+	if code < 400 || len(ac.errorPages) == 0 {
+		return ""
+	}
+	if templateFilename, ok := ac.errorPages[strconv.Itoa(code)]; ok {
+		return templateFilename
+	}
+	return ac.errorPages["default"]
+}
+
+// ErrorPage renders the error page template that is configured for the given
+// HTTP status code, if any, and writes it to the client together with the
+// status code. The template receives "code", "status", "message" and "path".
+// Returns false if no template is configured or the template could not be
+// rendered, in which case nothing is written.
+func (ac *Config) ErrorPage(w http.ResponseWriter, req *http.Request, code int, message string) bool {
+	templateFilename := ac.errorPageTemplate(code)
+	if templateFilename == "" {
+		return false
+	}
+	templateData, err := ac.cache.Read(templateFilename, ac.shouldCache(".po2"))
+	if err != nil {
+		log.Errorf("Unable to read %s: %s", templateFilename, err)
+		return false
+	}
+	tpl, err := pongo2.FromString(templateData.String())
+	if err != nil {
+		log.Errorf("Could not compile the error page template %s: %s", templateFilename, err)
+		return false
+	}
+	var buf bytes.Buffer
+	if err := tpl.ExecuteWriter(pongo2.Context{
+		"code":    code,
+		"status":  http.StatusText(code),
+		"message": message,
+		"path":    req.URL.Path,
+	}, &buf); err != nil {
+		log.Errorf("Could not render the error page template %s: %s", templateFilename, err)
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+	return true
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Possible ETag modes, as set with SetETag in the server configuration
+const (
+	etagStrong = "strong"
+	etagWeak   = "weak"
+	etagOff    = "off"
+)
+
+// fileETag returns an ETag for the given file, based on the size and
+// modification time, or an empty string if ETags are disabled
+func (ac *Config) fileETag(fInfo os.FileInfo) string {
+	tag := "\"" + strconv.FormatInt(fInfo.Size(), 16) + "-" + strconv.FormatInt(fInfo.ModTime().UnixNano(), 16) + "\""
+	switch ac.etagMode {
+	case etagStrong:
+		return tag
+	case etagWeak:
+		return "W/" + tag
+	}
+	return ""
+}
+
+// etagMatches checks if the given If-None-Match header value matches the
+// given ETag, using the weak comparison function from RFC 7232
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// NotModified sets the ETag and Last-Modified headers for the given file.
+// If the client already has the current version of the file, according to
+// If-None-Match or If-Modified-Since, "304 Not Modified" is written and true
+// is returned.
+func (ac *Config) NotModified(w http.ResponseWriter, req *http.Request, fInfo os.FileInfo) bool {
+	etag := ac.fileETag(fInfo)
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", fInfo.ModTime().UTC().Format(http.TimeFormat))
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		t, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !fInfo.ModTime().Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package engine
+
+// Feature flags, stored in the database backend
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/pinterface"
+)
+
+const (
+	// The name of the KeyValue where the feature flags are stored
+	featureFlagsName = "flunix_flags"
+
+	// How long a feature flag is cached before it is read again
+	featureFlagCacheDuration = 5 * time.Second
+)
+
+// featureFlag is a cached feature flag. The percentage is 0 when the flag is
+// off and 100 when it is on, or in between for a partial rollout.
+type featureFlag struct {
+	percentage int
+	read       time.Time
+}
+
+// FeatureFlags reads and writes feature flags, and caches them briefly
+type FeatureFlags struct {
+	mut   sync.Mutex
+	cache map[string]featureFlag
+}
+
+// NewFeatureFlags creates a new, empty FeatureFlags cache
+func NewFeatureFlags() *FeatureFlags {
+	return &FeatureFlags{cache: make(map[string]featureFlag)}
+}
+
+// parsePercentage converts a stored flag to a percentage, from 0 to 100
+func parsePercentage(value string) int {
+	switch value {
+	case "true":
+		return 100
+	case "", "false":
+		return 0
+	}
+	percentage, err := strconv.Atoi(value)
+	if err != nil || percentage < 0 {
+		return 0
+	} else if percentage > 100 {
+		return 100
+	}
+	return percentage
+}
+
+// Percentage returns how many percent of the users the given flag is on for
+func (ff *FeatureFlags) Percentage(creator pinterface.ICreator, name string) int {
+	ff.mut.Lock()
+	cached, ok := ff.cache[name]
+	ff.mut.Unlock()
+	if ok && time.Since(cached.read) < featureFlagCacheDuration {
+		return cached.percentage
+	}
+	kv, err := creator.NewKeyValue(featureFlagsName)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+	// A flag that has not been set is off
+	value, _ := kv.Get(name)
+	percentage := parsePercentage(value)
+	ff.mut.Lock()
+	ff.cache[name] = featureFlag{percentage: percentage, read: time.Now()}
+	ff.mut.Unlock()
+	return percentage
+}
+
+// Set stores the given flag, as a percentage from 0 to 100
+func (ff *FeatureFlags) Set(creator pinterface.ICreator, name string, percentage int) error {
+	kv, err := creator.NewKeyValue(featureFlagsName)
+	if err != nil {
+		return err
+	}
+	if err := kv.Set(name, strconv.Itoa(percentage)); err != nil {
+		return err
+	}
+	ff.mut.Lock()
+	delete(ff.cache, name)
+	ff.mut.Unlock()
+	return nil
+}
+
+// Enabled checks if the given flag is on for everyone
+func (ff *FeatureFlags) Enabled(creator pinterface.ICreator, name string) bool {
+	return ff.Percentage(creator, name) >= 100
+}
+
+// EnabledFor checks if the given flag is on for the given username. The same
+// username always gets the same result for the same flag and percentage.
+func (ff *FeatureFlags) EnabledFor(creator pinterface.ICreator, name, username string) bool {
+	percentage := ff.Percentage(creator, name)
+	if percentage <= 0 {
+		return false
+	} else if percentage >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + username))
+	return int(h.Sum32()%100) < percentage
+}
+
+// LoadFeatureFlagFunctions makes functions for reading and writing feature
+// flags available
+func (ac *Config) LoadFeatureFlagFunctions(req *http.Request, L *lua.LState, userstate pinterface.IUserState) {
+	creator := userstate.Creator()
+
+	// Check if the given feature flag is on for everyone
+	L.SetGlobal("Flag", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(ac.featureFlags.Enabled(creator, L.CheckString(1))))
+		return 1 // number of results
+	}))
+
+	// Check if the given feature flag is on for the given username, which
+	// may be partially rolled out to a percentage of the users
+	L.SetGlobal("FlagForUser", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(ac.featureFlags.EnabledFor(creator, L.CheckString(1), L.CheckString(2))))
+		return 1 // number of results
+	}))
+
+	// Turn the given feature flag on or off, or on for a percentage of the
+	// users, from 0 to 100. Requires admin rights. Returns true, or false and
+	// an error string.
+	L.SetGlobal("SetFlag", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		var percentage int
+		switch v := L.CheckAny(2).(type) {
+		case lua.LBool:
+			if v {
+				percentage = 100
+			}
+		case lua.LNumber:
+			percentage = int(v)
+			if percentage < 0 || percentage > 100 {
+				L.ArgError(2, "the percentage must be from 0 to 100")
+				return 0 // number of results
+			}
+		default:
+			L.ArgError(2, "bool or number expected")
+			return 0 // number of results
+		}
+		if req != nil && !userstate.AdminRights(req) {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString("admin rights are required for setting feature flags"))
+			return 2 // number of results
+		}
+		if err := ac.featureFlags.Set(creator, name, percentage); err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LTrue)
+		return 1 // number of results
+	}))
+
+}
@@ -159,6 +159,10 @@ func (ac *Config) FilePage(w http.ResponseWriter, req *http.Request, filename, d
 			// Write the data to the client
 			ac.DataToClient(w, req, filename, htmldata)
 		} else {
+			// Check if the client already has the current version of the file
+			if fInfo, err := os.Stat(filename); err == nil && ac.NotModified(w, req, fInfo) {
+				return
+			}
 			// Serve the file
 			htmlblock.ToClient(w, req, filename, ac.ClientCanGzip(req), gzipThreshold)
 		}
@@ -258,8 +262,20 @@ func (ac *Config) FilePage(w http.ResponseWriter, req *http.Request, filename, d
 				recwatch.Flush(w)
 			}
 			// Run the lua script, without the possibility to flush
-			if err := ac.RunLua(recorder, req, filename, flushFunc, httpStatus); err != nil {
-				errortext := err.Error()
+			err := ac.RunLua(recorder, req, filename, flushFunc, httpStatus)
+			if webSocketUpgraded(req) {
+				// The connection has been taken over, there is nothing more to write
+				if err != nil {
+					log.Error("Error in " + filename + ": " + ac.LuaErrorText(err))
+				}
+				return
+			}
+			if err != nil {
+				// Let the OnError function handle the error, if set
+				if ac.RunErrorHandler(w, req, filename, err, false) {
+					return
+				}
+				errortext := ac.LuaErrorText(err)
 				fileblock, err := ac.cache.Read(filename, ac.shouldCache(ext))
 				if err != nil {
 					// If the file could not be read, use the error message as the data
@@ -279,18 +295,22 @@ func (ac *Config) FilePage(w http.ResponseWriter, req *http.Request, filename, d
 				utils.WriteRecorder(w, recorder)
 			}
 		} else {
+			// Keep track of if the response has been started, for the OnError function
+			sw := NewStatusWriter(w)
 			// The flush function just flushes the ResponseWriter
 			flushFunc := func() {
-				recwatch.Flush(w)
+				recwatch.Flush(sw)
 			}
 			// Run the lua script, with the flush feature
-			if err := ac.RunLua(w, req, filename, flushFunc, nil); err != nil {
+			if err := ac.RunLua(sw, req, filename, flushFunc, nil); err != nil {
 				// Output the non-fatal error message to the log
-				if strings.HasPrefix(err.Error(), filename) {
-					log.Error("Error at " + err.Error())
+				if errortext := ac.LuaErrorText(err); strings.HasPrefix(errortext, filename) {
+					log.Error("Error at " + errortext)
 				} else {
-					log.Error("Error in " + filename + ": " + err.Error())
+					log.Error("Error in " + filename + ": " + errortext)
 				}
+				// Let the OnError function handle the error, if set
+				ac.RunErrorHandler(w, req, filename, err, sw.Written())
 			}
 		}
 		return
@@ -384,6 +404,14 @@ func (ac *Config) FilePage(w http.ResponseWriter, req *http.Request, filename, d
 		return
 	}
 
+	// Let clients cache assets with a content hash in the URL for a long time
+	ac.setAssetCacheHeaders(w, req, filename)
+
+	// Check if the client already has the current version of the file
+	if ac.NotModified(w, req, fInfo) {
+		return
+	}
+
 	// Check if the file is so large that it needs to be streamed directly
 	fileSize := uint64(fInfo.Size())
 	// Cache size can be set to a low number to trigger this behavior
@@ -398,6 +426,28 @@ func (ac *Config) FilePage(w http.ResponseWriter, req *http.Request, filename, d
 		return
 	}
 
+	// Range requests are served directly from the file, without compression,
+	// so that the byte ranges are correct. This is needed for seeking in
+	// video and audio files, and for resuming downloads.
+	if req.Header.Get("Range") != "" {
+		http.ServeContent(w, req, fInfo.Name(), fInfo.ModTime(), f)
+		return
+	}
+
+	// Let clients know that ranges are supported, even if the full response is compressed
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// Serve minified CSS, if enabled with SetCSSMinify
+	if ext == ".css" && ac.cssMinify {
+		data, err := minifiedCSS(filename, fInfo)
+		if err != nil {
+			log.Error("Could not minify " + filename + ": " + err.Error())
+			return
+		}
+		ac.DataToClient(w, req, filename, data)
+		return
+	}
+
 	// Read the file (possibly in compressed format, straight from the cache)
 	if dataBlock, err := ac.ReadAndLogErrors(w, filename, ext); err == nil { // if no error
 		// Serve the file
@@ -420,6 +470,10 @@ func (ac *Config) ServerHeaders(w http.ResponseWriter) {
 		w.Header().Set("Content-Security-Policy",
 			"connect-src 'self'; object-src 'self'; form-action 'self'")
 	}
+	// Headers configured with SetSecurityHeaders take precedence
+	for key, values := range ac.securityHeaders {
+		w.Header()[key] = values
+	}
 	// w.Header().Set("X-Powered-By", name+"/"+version)
 }
 
@@ -462,6 +516,12 @@ func (ac *Config) RegisterHandlers(mux *http.ServeMux, handlePath, servedir stri
 
 		urlpath := req.URL.Path
 		filename := utils.URL2filename(servedir, urlpath)
+		// Find the file on disk when ignoring case, if enabled with SetCaseInsensitivePaths
+		if ac.caseInsensitivePaths && !ac.fs.Exists(filename) {
+			if resolved, ok := resolveFilenameCase(servedir, filename); ok {
+				filename = resolved
+			}
+		}
 		// Remove the trailing slash from the filename, if any
 		noslash := filename
 		if strings.HasSuffix(filename, utils.Pathsep) {
@@ -471,6 +531,17 @@ func (ac *Config) RegisterHandlers(mux *http.ServeMux, handlePath, servedir stri
 		dirname := filename
 		hasfile := ac.fs.Exists(noslash)
 
+		// Directories are served with a trailing slash and files without one,
+		// if configured with SetTrailingSlash
+		if hasdir || hasfile {
+			if ac.redirectTrailingSlash(w, req, hasdir) {
+				return
+			}
+			if ac.trailingSlash == trailingSlashStrict && trailingSlashMismatch(urlpath, hasdir) {
+				hasdir, hasfile = false, false
+			}
+		}
+
 		// Set the server headers, if not disabled
 		if !ac.noHeaders {
 			ac.ServerHeaders(w)
@@ -478,23 +549,58 @@ func (ac *Config) RegisterHandlers(mux *http.ServeMux, handlePath, servedir stri
 
 		// Share the directory or file
 		if hasdir {
-			// Prepare to count bytes written
-			sc := sheepcounter.New(w)
+			// Prepare to record the status code and count bytes written
+			sw := NewStatusWriter(w)
+			sc := sheepcounter.New(sw)
+			// Compress the response, if enabled and suitable
+			cw, closeCompression := ac.NewCompressWriter(sc, req)
 			// Get the directory page
-			ac.DirPage(sc, req, servedir, dirname, theme)
+			ac.DirPage(cw, req, servedir, dirname, theme)
+			closeCompression()
 			// Log the access
-			ac.LogAccess(req, http.StatusOK, sc.Counter())
+			ac.LogAccess(req, sw.Status(), sc.Counter())
 			return
 		} else if !hasdir && hasfile {
-			// Prepare to count bytes written
-			sc := sheepcounter.New(w)
+			// Prepare to record the status code and count bytes written
+			sw := NewStatusWriter(w)
+			sc := sheepcounter.New(sw)
+			// Compress the response, if enabled and suitable
+			cw, closeCompression := ac.NewCompressWriter(sc, req)
 			// Share a single file instead of a directory
-			ac.FilePage(sc, req, noslash, ac.defaultLuaDataFilename)
+			ac.FilePage(cw, req, noslash, ac.defaultLuaDataFilename)
+			closeCompression()
 			// Log the access
-			ac.LogAccess(req, http.StatusOK, sc.Counter())
+			ac.LogAccess(req, sw.Status(), sc.Counter())
+			return
+		}
+		// Not found on disk, serve the file from an embedded filesystem if configured
+		if fsys, fsName, name, ok := ac.embeddedFile(urlpath); ok {
+			sw := NewStatusWriter(w)
+			sc := sheepcounter.New(sw)
+			cw, closeCompression := ac.NewCompressWriter(sc, req)
+			if err := ac.EmbeddedPage(cw, req, fsys, fsName, name); err != nil {
+				log.Error("Could not serve embedded file " + name + ": " + err.Error())
+			}
+			closeCompression()
+			ac.LogAccess(req, sw.Status(), sc.Counter())
+			return
+		}
+		// Not found, serve the index file of a single-page application if configured
+		if fallback := ac.spaFallback(req); fallback != nil && ac.fs.Exists(fallback.Filename) {
+			sw := NewStatusWriter(w)
+			sc := sheepcounter.New(sw)
+			cw, closeCompression := ac.NewCompressWriter(sc, req)
+			ac.FilePage(cw, req, fallback.Filename, ac.defaultLuaDataFilename)
+			closeCompression()
+			ac.LogAccess(req, sw.Status(), sc.Counter())
+			return
+		}
+		// Not found, use the error page template if configured
+		sc := sheepcounter.New(w)
+		if ac.ErrorPage(sc, req, http.StatusNotFound, "") {
+			ac.LogAccess(req, http.StatusNotFound, sc.Counter())
 			return
 		}
-		// Not found
 		w.WriteHeader(http.StatusNotFound)
 		data := themes.NoPage(filename, theme)
 		ac.LogAccess(req, http.StatusNotFound, int64(len(data)))
@@ -508,6 +614,6 @@ func (ac *Config) RegisterHandlers(mux *http.ServeMux, handlePath, servedir stri
 		limiter := tollbooth.NewLimiter(float64(ac.limitRequests), nil)
 		limiter.SetMessage(themes.MessagePage("Rate-limit exceeded", "<div style='color:red'>You have reached the maximum request limit.</div>", theme))
 		limiter.SetMessageContentType("text/html;charset=utf-8")
-		mux.Handle(handlePath, tollbooth.LimitFuncHandler(limiter, allRequests))
+		mux.Handle(handlePath, ac.LimitFuncHandler(limiter, allRequests))
 	}
 }
@@ -0,0 +1,70 @@
+package engine
+
+// Liveness and readiness endpoints, for load balancers and container orchestration
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The name of the KeyValue that is written to when checking the database
+// backend, when Redis is not used
+const healthKeyValueName = "health"
+
+// checkDatabase checks that the database backend can be reached, if there is one
+func (ac *Config) checkDatabase() error {
+	if ac.perm == nil {
+		return nil
+	}
+	if state, ok := ac.redisUserState(); ok {
+		conn := state.Pool().Get(state.DatabaseIndex())
+		defer conn.Close()
+		_, err := conn.Do("PING")
+		return err
+	}
+	kv, err := ac.perm.UserState().Creator().NewKeyValue(healthKeyValueName)
+	if err != nil {
+		return err
+	}
+	return kv.Set("checked", strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// LiveHandler responds with 200 OK for as long as the server is running
+func (ac *Config) LiveHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "OK")
+}
+
+// ReadyHandler responds with 200 OK if the database backend can be reached,
+// and with 503 Service Unavailable if not, or if the server is shutting down
+func (ac *Config) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	select {
+	case <-ac.scheduleStop():
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "shutting down")
+		return
+	default:
+	}
+	if err := ac.checkDatabase(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "database backend: "+err.Error())
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// RegisterHealthChecks registers the liveness and readiness endpoints. These
+// are registered directly, so the permission prefixes do not apply.
+func (ac *Config) RegisterHealthChecks(mux *http.ServeMux, livePath, readyPath string) {
+	if livePath != "" {
+		mux.HandleFunc(livePath, ac.LiveHandler)
+	}
+	if readyPath != "" {
+		mux.HandleFunc(readyPath, ac.ReadyHandler)
+	}
+}
@@ -0,0 +1,219 @@
+package engine
+
+// Hubs for broadcasting messages to joined WebSocket connections
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// Identifier for the Hub class in Lua
+	lHubClass = "Hub"
+)
+
+var errHubDenied = errors.New("permission denied")
+
+// Hub keeps track of the WebSocket connections that have joined it
+type Hub struct {
+	name    string
+	level   string // "", "user" or "admin"
+	mut     sync.Mutex
+	members map[*WebSocketConn]bool
+}
+
+// hubHandle is a hub, as seen from the request that uses it
+type hubHandle struct {
+	hub *Hub
+	req *http.Request
+}
+
+// hub returns the hub with the given name, creating it if needed.
+// The permission level is set when the hub is created, and an error is
+// returned if an existing hub has a different permission level.
+func (ac *Config) hub(name, level string) (*Hub, error) {
+	ac.hubsMutex.Lock()
+	defer ac.hubsMutex.Unlock()
+	if ac.hubs == nil {
+		ac.hubs = make(map[string]*Hub)
+	}
+	hub, ok := ac.hubs[name]
+	if !ok {
+		hub = &Hub{name: name, level: level, members: make(map[*WebSocketConn]bool)}
+		ac.hubs[name] = hub
+	} else if hub.level != level {
+		return nil, fmt.Errorf("the hub %s was created with the permission level %q, not %q", name, hub.level, level)
+	}
+	return hub, nil
+}
+
+// hubAllowed checks if the user of the given request may join the hub
+func (ac *Config) hubAllowed(hub *Hub, req *http.Request) bool {
+	if ac.perm == nil {
+		return hub.level == ""
+	}
+	userstate := ac.perm.UserState()
+	switch hub.level {
+	case "admin":
+		return userstate.AdminRights(req)
+	case "user":
+		return userstate.UserRights(req)
+	}
+	return true
+}
+
+// Join adds a WebSocket connection to the hub
+func (hub *Hub) Join(wc *WebSocketConn) {
+	hub.mut.Lock()
+	hub.members[wc] = true
+	hub.mut.Unlock()
+	wc.mut.Lock()
+	if wc.hubs != nil {
+		wc.hubs[hub] = true
+	}
+	wc.mut.Unlock()
+}
+
+// Leave removes a WebSocket connection from the hub
+func (hub *Hub) Leave(wc *WebSocketConn) {
+	hub.mut.Lock()
+	delete(hub.members, wc)
+	hub.mut.Unlock()
+	wc.mut.Lock()
+	delete(wc.hubs, hub)
+	wc.mut.Unlock()
+}
+
+// Count returns the number of joined connections
+func (hub *Hub) Count() int {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	return len(hub.members)
+}
+
+// Broadcast sends a message to all joined connections. Connections that
+// can not be written to are removed from the hub. Returns the number of
+// connections that received the message.
+func (hub *Hub) Broadcast(message string) int {
+	hub.mut.Lock()
+	members := make([]*WebSocketConn, 0, len(hub.members))
+	for wc := range hub.members {
+		members = append(members, wc)
+	}
+	hub.mut.Unlock()
+
+	var (
+		wg    sync.WaitGroup
+		mut   sync.Mutex
+		count int
+	)
+	for _, wc := range members {
+		wg.Add(1)
+		go func(wc *WebSocketConn) {
+			defer wg.Done()
+			if err := wc.Send(message); err != nil {
+				hub.Leave(wc)
+				return
+			}
+			mut.Lock()
+			count++
+			mut.Unlock()
+		}(wc)
+	}
+	wg.Wait()
+	return count
+}
+
+// Get the first argument, "self", and cast it from userdata to a hub
+func checkHub(L *lua.LState) *hubHandle {
+	ud := L.CheckUserData(1)
+	if h, ok := ud.Value.(*hubHandle); ok {
+		return h
+	}
+	L.ArgError(1, "Hub expected")
+	return nil
+}
+
+// LoadHubFunctions makes functions for joining and broadcasting to hubs available
+func (ac *Config) LoadHubFunctions(L *lua.LState, req *http.Request) {
+
+	// The hub methods that are to be registered
+	hubMethods := map[string]lua.LGFunction{
+
+		// Join the hub with the WebSocket connection of the current request,
+		// upgrading the connection if needed. Returns true on success, or
+		// false and an error message.
+		"join": func(L *lua.LState) int {
+			h := checkHub(L) // arg 1
+			if !ac.hubAllowed(h.hub, h.req) {
+				L.Push(lua.LBool(false))
+				L.Push(lua.LString(errHubDenied.Error()))
+				return 2 // number of results
+			}
+			wc, err := ac.upgradeWebSocket(h.req)
+			if err != nil {
+				L.Push(lua.LBool(false))
+				L.Push(lua.LString(err.Error()))
+				return 2 // number of results
+			}
+			h.hub.Join(wc)
+			L.Push(lua.LBool(true))
+			return 1 // number of results
+		},
+
+		// Leave the hub with the WebSocket connection of the current request
+		"leave": func(L *lua.LState) int {
+			h := checkHub(L) // arg 1
+			if state, ok := h.req.Context().Value(webSocketKey{}).(*webSocketState); ok && state.conn != nil {
+				h.hub.Leave(state.conn)
+			}
+			return 0 // number of results
+		},
+
+		// Send a message to all joined connections. Returns the number of receivers.
+		"broadcast": func(L *lua.LState) int {
+			h := checkHub(L) // arg 1
+			L.Push(lua.LNumber(h.hub.Broadcast(L.CheckString(2))))
+			return 1 // number of results
+		},
+
+		// Return the number of joined connections
+		"count": func(L *lua.LState) int {
+			h := checkHub(L) // arg 1
+			L.Push(lua.LNumber(h.hub.Count()))
+			return 1 // number of results
+		},
+	}
+
+	// Register the Hub class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lHubClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, hubMethods)
+
+	// Get or create a hub. Takes a name and an optional permission level,
+	// "user" or "admin", that is needed for joining. Returns nil and an
+	// error string if the hub exists with a different permission level.
+	L.SetGlobal("Hub", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		level := L.OptString(2, "")
+		if level != "" && level != "user" && level != "admin" {
+			L.ArgError(2, "the permission level must be \"user\" or \"admin\"")
+		}
+		hub, err := ac.hub(name, level)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		ud := L.NewUserData()
+		ud.Value = &hubHandle{hub: hub, req: req}
+		L.SetMetatable(ud, L.GetTypeMetatable(lHubClass))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
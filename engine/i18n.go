@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// The locale that is used when no other locale matches
+const defaultLocale = "en"
+
+// Translations contains messages for several locales, by key
+type Translations struct {
+	messages      map[string]map[string]string
+	defaultLocale string
+}
+
+// normalizeLocale converts a locale like "en_US" to "en-us"
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// flattenMessages adds the messages in a decoded file to the given map.
+// Nested maps use keys like "nav.home".
+func flattenMessages(prefix string, value interface{}, messages map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, element := range v {
+			flattenMessages(prefix+key+".", element, messages)
+		}
+	case map[interface{}]interface{}:
+		for key, element := range v {
+			flattenMessages(prefix+fmt.Sprintf("%v", key)+".", element, messages)
+		}
+	case nil:
+	default:
+		messages[strings.TrimSuffix(prefix, ".")] = fmt.Sprintf("%v", v)
+	}
+}
+
+// LoadTranslations reads message files from the given directory. Each file
+// is named after a locale, like "en.json", "nb.yaml" or "pt-BR.toml", and
+// contains keys and messages. Nested keys are flattened to keys like "nav.home".
+func LoadTranslations(dir, fallbackLocale string) (*Translations, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &Translations{
+		messages:      make(map[string]map[string]string),
+		defaultLocale: normalizeLocale(fallbackLocale),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		locale := normalizeLocale(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var decoded interface{}
+		switch ext {
+		case ".json":
+			err = json.Unmarshal(data, &decoded)
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, &decoded)
+		case ".toml":
+			var m map[string]interface{}
+			_, err = toml.Decode(string(data), &m)
+			decoded = m
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.New(entry.Name() + ": " + err.Error())
+		}
+		if t.messages[locale] == nil {
+			t.messages[locale] = make(map[string]string)
+		}
+		flattenMessages("", decoded, t.messages[locale])
+	}
+	return t, nil
+}
+
+// Locales returns the available locales, sorted
+func (t *Translations) Locales() []string {
+	locales := make([]string, 0, len(t.messages))
+	for locale := range t.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// match returns the available locale that matches the given locale, like
+// "en" for "en-US", or an empty string
+func (t *Translations) match(locale string) string {
+	locale = normalizeLocale(locale)
+	if _, ok := t.messages[locale]; ok {
+		return locale
+	}
+	if pos := strings.Index(locale, "-"); pos > 0 {
+		if _, ok := t.messages[locale[:pos]]; ok {
+			return locale[:pos]
+		}
+	}
+	return ""
+}
+
+// Negotiate returns the best available locale for the given Accept-Language
+// header, or the default locale
+func (t *Translations) Negotiate(acceptLanguage string) string {
+	type weighted struct {
+		locale string
+		q      float64
+	}
+	var candidates []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" || fields[0] == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		candidates = append(candidates, weighted{fields[0], q})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, candidate := range candidates {
+		if candidate.q <= 0 {
+			continue
+		}
+		if locale := t.match(candidate.locale); locale != "" {
+			return locale
+		}
+	}
+	return t.defaultLocale
+}
+
+// Translate returns the message for the given key and locale, with "{name}"
+// replaced by the given arguments. The default locale is used if the key is
+// missing for the locale. If the key is missing there too, a warning is
+// logged and the key is returned.
+func (t *Translations) Translate(locale, key string, args map[string]string) string {
+	message, ok := t.messages[t.match(locale)][key]
+	if !ok {
+		message, ok = t.messages[t.defaultLocale][key]
+	}
+	if !ok {
+		log.Warn("Missing translation for " + key + " (" + locale + ")")
+		return key
+	}
+	for name, value := range args {
+		message = strings.Replace(message, "{"+name+"}", value, -1)
+	}
+	return message
+}
+
+// translate returns the message for the given key, for the locale that is
+// given, or else for the locale of the request
+func (ac *Config) translate(req *http.Request, key, locale string, args map[string]string) string {
+	t := ac.translations
+	if t == nil {
+		log.Warn("Missing translation for " + key + ", no translations have been loaded")
+		return key
+	}
+	if locale == "" {
+		locale = t.Negotiate(req.Header.Get("Accept-Language"))
+	}
+	return t.Translate(locale, key, args)
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"net/http"
+
+	"github.com/xyproto/algernon/themes"
+)
+
+// acquireClientRequest counts a request from the given client IP that is
+// being served. Returns false if the client already has the maximum number
+// of requests in progress.
+func (ac *Config) acquireClientRequest(ip string) bool {
+	ac.requestsPerIPMutex.Lock()
+	defer ac.requestsPerIPMutex.Unlock()
+	if ac.requestsPerIP == nil {
+		ac.requestsPerIP = make(map[string]int)
+	}
+	if ac.requestsPerIP[ip] >= ac.maxRequestsPerIP {
+		return false
+	}
+	ac.requestsPerIP[ip]++
+	return true
+}
+
+// releaseClientRequest counts down the requests in progress from the given client IP
+func (ac *Config) releaseClientRequest(ip string) {
+	ac.requestsPerIPMutex.Lock()
+	defer ac.requestsPerIPMutex.Unlock()
+	if ac.requestsPerIP[ip] <= 1 {
+		delete(ac.requestsPerIP, ip)
+		return
+	}
+	ac.requestsPerIP[ip]--
+}
+
+// perIPLimitHandler limits the number of simultaneous requests from each
+// client, if configured with SetMaxRequestsPerIP. A request is counted for
+// as long as it is being served, including WebSocket connections and
+// Server-Sent Events, both for HTTP/1, HTTP/2 and QUIC. Idle keep-alive
+// connections are not counted, and each request that is multiplexed over an
+// HTTP/2 or QUIC connection is counted. The client IP is found with ClientIP,
+// so that clients behind trusted proxies are counted individually. Requests
+// over the limit get "429 Too Many Requests".
+func (ac *Config) perIPLimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ac.maxRequestsPerIP <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ip := ac.ClientIP(req)
+		if !ac.acquireClientRequest(ip) {
+			page := themes.MessagePage("Too many requests", "<div style='color:red'>There are too many simultaneous requests from your address.</div>", ac.defaultTheme)
+			w.Header().Set("Content-Type", "text/html;charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(page))
+			ac.LogAccess(req, http.StatusTooManyRequests, int64(len(page)))
+			return
+		}
+		defer ac.releaseClientRequest(ip)
+		next.ServeHTTP(w, req)
+	})
+}
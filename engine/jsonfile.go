@@ -157,6 +157,61 @@ func jfileGet(L *lua.LState) int {
 	return 1 // number of results
 }
 
+// Takes a JFile, a JSON path and a Lua function.
+// Calls the function with the index and a JNode for each element in the
+// JSON array at the given path. Stops if the function returns false.
+func jfileEach(L *lua.LState) int {
+	jfile := checkJFile(L) // arg 1
+	jsonpath := L.ToString(2)
+	if jsonpath == "" {
+		L.ArgError(2, "JSON path expected")
+	}
+	fn := L.CheckFunction(3)
+	node, err := jfile.GetNode(jsonpath)
+	if err != nil {
+		log.Error(err)
+		return 0 // number of results
+	}
+	list, ok := node.CheckNodeList()
+	if !ok {
+		log.Error("Not a JSON array: " + jsonpath)
+		return 0 // number of results
+	}
+	for i, element := range list {
+		ud := L.NewUserData()
+		ud.Value = element
+		L.SetMetatable(ud, L.GetTypeMetatable(jnode.Class))
+		L.Push(fn)
+		L.Push(lua.LNumber(i + 1))
+		L.Push(ud)
+		L.Call(2, 1)
+		ret := L.Get(-1)
+		L.Pop(1)
+		if ret == lua.LFalse {
+			break
+		}
+	}
+	return 0 // number of results
+}
+
+// Takes a JFile and a JSON path.
+// Returns the number of elements in the JSON array, or 0.
+func jfileCount(L *lua.LState) int {
+	jfile := checkJFile(L) // arg 1
+	jsonpath := L.ToString(2)
+	if jsonpath == "" {
+		L.ArgError(2, "JSON path expected")
+	}
+	count := 0
+	if node, err := jfile.GetNode(jsonpath); err == nil {
+		if list, ok := node.CheckList(); ok {
+			count = len(list)
+		}
+	}
+	L.Push(lua.LNumber(count))
+	return 1 // number of results
+}
+
 // Take a JFile, a JSON path and a string.
 // Returns a value or an empty string.
 func jfileSet(L *lua.LState) int {
@@ -239,6 +294,8 @@ var jfileMethods = map[string]lua.LGFunction{
 	"get":        jfileGet,
 	"set":        jfileSet,
 	"delkey":     jfileDelKey,
+	"each":       jfileEach,
+	"count":      jfileCount,
 	"string":     jfileJSON, // undocumented
 }
 
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+)
+
+// For reading JSON documents with one JSON value per line (NDJSON)
+
+const (
+	// Identifier for the JLinesReader class in Lua
+	lJLinesReaderClass = "JLinesReader"
+)
+
+// JLinesReader decodes one JSON value per line from a file, without reading
+// the whole file into memory
+type JLinesReader struct {
+	file   *os.File
+	reader *bufio.Reader
+	line   int
+}
+
+// NewJLinesReader opens the given file for reading JSON values line by line
+func NewJLinesReader(filename string) (*JLinesReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &JLinesReader{file: f, reader: bufio.NewReader(f)}, nil
+}
+
+// Next decodes the next non-empty line. Returns io.EOF when there are no
+// more lines. A line that can not be decoded returns an error, but the
+// following lines can still be read.
+func (jr *JLinesReader) Next() (interface{}, error) {
+	if jr.file == nil {
+		return nil, io.EOF
+	}
+	for {
+		data, err := jr.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(data) == 0 && err == io.EOF {
+			jr.Close()
+			return nil, io.EOF
+		}
+		jr.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		var value interface{}
+		if decodeErr := json.Unmarshal(data, &value); decodeErr != nil {
+			return nil, &jsonLineError{line: jr.line, err: decodeErr}
+		}
+		return value, nil
+	}
+}
+
+// Close closes the file. Calling Close more than once is fine.
+func (jr *JLinesReader) Close() error {
+	if jr.file == nil {
+		return nil
+	}
+	err := jr.file.Close()
+	jr.file = nil
+	return err
+}
+
+// jsonLineError is a decoding error for a specific line
+type jsonLineError struct {
+	line int
+	err  error
+}
+
+func (e *jsonLineError) Error() string {
+	return "line " + strconv.Itoa(e.line) + ": " + e.err.Error()
+}
+
+// Get the first argument, "self", and cast it from userdata to a JLinesReader
+func checkJLinesReader(L *lua.LState) *JLinesReader {
+	ud := L.CheckUserData(1)
+	if jr, ok := ud.Value.(*JLinesReader); ok {
+		return jr
+	}
+	L.ArgError(1, "JSON lines reader expected")
+	return nil
+}
+
+// Takes a JLinesReader.
+// Returns the next JSON value, nil when done, or nil and an error message.
+func jlinesNext(L *lua.LState) int {
+	jr := checkJLinesReader(L) // arg 1
+	value, err := jr.Next()
+	if err == io.EOF {
+		L.Push(lua.LNil)
+		return 1 // number of results
+	} else if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(convert.Interface2LValue(L, value))
+	return 1 // number of results
+}
+
+// Takes a JLinesReader and closes the file
+func jlinesClose(L *lua.LState) int {
+	jr := checkJLinesReader(L) // arg 1
+	if err := jr.Close(); err != nil {
+		log.Error(err)
+	}
+	return 0 // number of results
+}
+
+// The JLinesReader methods that are to be registered
+var jlinesMethods = map[string]lua.LGFunction{
+	"next":  jlinesNext,
+	"close": jlinesClose,
+}
+
+// LoadJLinesReader makes functions for reading JSON lines available. If an
+// output buffer is given, the files that are still open are closed when the
+// request is done.
+func (ac *Config) LoadJLinesReader(L *lua.LState, scriptdir string, ob *OutputBuffer) {
+
+	// Register the JLinesReader class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lJLinesReaderClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, jlinesMethods)
+
+	// The constructor takes a filename, relative to the script directory
+	L.SetGlobal("JLinesReader", L.NewFunction(func(L *lua.LState) int {
+		filename := filepath.Join(scriptdir, L.CheckString(1))
+		jr, err := NewJLinesReader(filename)
+		if err != nil {
+			log.Error(err)
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		if ob != nil {
+			ob.closeAtEnd(jr)
+		}
+		ud := L.NewUserData()
+		ud.Value = jr
+		L.SetMetatable(ud, L.GetTypeMetatable(lJLinesReaderClass))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
@@ -0,0 +1,136 @@
+package engine
+
+// Locks that are shared between servers, using Redis. With Bolt, which can
+// only be used by one server process at the time, a KeyValue is used instead.
+// Other database backends can not make the locks atomic between servers, so
+// locking returns an error for those.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	bolt "github.com/xyproto/permissionbolt"
+	"github.com/xyproto/pinterface"
+)
+
+// The name of the KeyValue that is used for locks, when Bolt is used
+const lockKeyValueName = "locks"
+
+// Deletes KEYS[1] only if it is held by ARGV[1]
+const redisUnlockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+var (
+	// ErrLockTTL is returned when trying to lock for a duration that is not positive
+	ErrLockTTL = errors.New("the lock duration must be positive")
+
+	// ErrLockNotHeld is returned when unlocking a lock that is not held by this server
+	ErrLockNotHeld = errors.New("lock not held")
+
+	// ErrLockBackend is returned when the database backend can not provide
+	// locks that are atomic between servers
+	ErrLockBackend = errors.New("locks are only supported with Redis, or with Bolt for a single server")
+
+	redisUnlock = redis.NewScript(1, redisUnlockScript)
+
+	// lockToken identifies the locks that are held by this server process
+	lockToken = newLockToken()
+
+	// For making locking atomic within this server, when Bolt is used
+	lockMutex sync.Mutex
+)
+
+// newLockToken returns a random hex string
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// lockKeyValue returns the KeyValue that is used for locks, when Bolt is
+// used. A Bolt database can only be opened by one process at the time, so a
+// mutex within this process is enough for making the locks atomic. The SQL
+// backends may be shared between servers, and are not supported.
+func (ac *Config) lockKeyValue() (pinterface.IKeyValue, error) {
+	if ac.perm == nil {
+		return nil, ErrLockBackend
+	}
+	if _, ok := ac.perm.UserState().(*bolt.UserState); !ok {
+		return nil, ErrLockBackend
+	}
+	return ac.perm.UserState().Creator().NewKeyValue(lockKeyValueName)
+}
+
+// Lock tries to acquire the lock with the given name, for the given duration.
+// Returns false if the lock is already held, by this or another server.
+func (ac *Config) Lock(name string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, ErrLockTTL
+	}
+	if state, ok := ac.redisUserState(); ok {
+		conn := state.Pool().Get(state.DatabaseIndex())
+		defer conn.Close()
+		reply, err := conn.Do("SET", "lock:"+name, lockToken, "NX", "PX", int64(ttl/time.Millisecond))
+		if err != nil {
+			return false, err
+		}
+		// A nil reply means that the key was already set
+		return reply != nil, nil
+	}
+	kv, err := ac.lockKeyValue()
+	if err != nil {
+		return false, err
+	}
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+	// The value is the token, followed by when the lock expires
+	if value, err := kv.Get(name); err == nil && value != "" {
+		if fields := strings.SplitN(value, ":", 2); len(fields) == 2 {
+			if expires, err := strconv.ParseInt(fields[1], 10, 64); err == nil && time.Now().UnixNano() < expires {
+				return false, nil
+			}
+		}
+	}
+	expires := time.Now().Add(ttl).UnixNano()
+	if err := kv.Set(name, lockToken+":"+strconv.FormatInt(expires, 10)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock releases the lock with the given name, if it is held by this server
+func (ac *Config) Unlock(name string) error {
+	if state, ok := ac.redisUserState(); ok {
+		conn := state.Pool().Get(state.DatabaseIndex())
+		defer conn.Close()
+		deleted, err := redis.Int(redisUnlock.Do(conn, "lock:"+name, lockToken))
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrLockNotHeld
+		}
+		return nil
+	}
+	kv, err := ac.lockKeyValue()
+	if err != nil {
+		return err
+	}
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+	value, err := kv.Get(name)
+	if err != nil || !strings.HasPrefix(value, lockToken+":") {
+		return ErrLockNotHeld
+	}
+	return kv.Del(name)
+}
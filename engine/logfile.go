@@ -0,0 +1,120 @@
+package engine
+
+// Log files that can be reopened, for log rotation
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/platformdep"
+)
+
+// The default signal for reopening the log file
+const defaultLogReopenSignal = "USR1"
+
+// LogFile is a log file that can be reopened after it has been moved away,
+// without losing any lines that are being written
+type LogFile struct {
+	mut      sync.Mutex
+	filename string
+	perm     os.FileMode
+	f        *os.File
+	closed   bool
+}
+
+// OpenLogFile opens or creates the given log file, for appending
+func OpenLogFile(filename string, perm os.FileMode) (*LogFile, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFile{filename: filename, perm: perm, f: f}, nil
+}
+
+// Write writes to the current log file
+func (lf *LogFile) Write(p []byte) (int, error) {
+	lf.mut.Lock()
+	defer lf.mut.Unlock()
+	return lf.f.Write(p)
+}
+
+// Reopen opens the log file again, by filename. The new file is opened before
+// the old one is closed, so if opening fails, the old file is kept.
+func (lf *LogFile) Reopen() error {
+	f, err := os.OpenFile(lf.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, lf.perm)
+	if err != nil {
+		return err
+	}
+	lf.mut.Lock()
+	if lf.closed {
+		lf.mut.Unlock()
+		return f.Close()
+	}
+	old := lf.f
+	lf.f = f
+	lf.mut.Unlock()
+	return old.Close()
+}
+
+// Close closes the current log file
+func (lf *LogFile) Close() error {
+	lf.mut.Lock()
+	defer lf.mut.Unlock()
+	lf.closed = true
+	return lf.f.Close()
+}
+
+// logToFile directs the logging to the given file. The log format is kept.
+func (ac *Config) logToFile(filename string) error {
+	lf, err := OpenLogFile(filename, ac.defaultPermissions)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(lf)
+	ac.setLogFile(lf)
+	return nil
+}
+
+// setLogFile sets the log file that is reopened on a signal, and closes the
+// previous log file, if any. The log output must already have been changed.
+func (ac *Config) setLogFile(lf *LogFile) {
+	ac.logFileMutex.Lock()
+	old := ac.logFile
+	ac.logFile = lf
+	ac.logFileMutex.Unlock()
+	if old != nil && old != lf {
+		if err := old.Close(); err != nil {
+			log.Error("Could not close the previous log file: ", err)
+		}
+	}
+}
+
+// ReopenLogOnSignal reopens the log file, if there is one, every time the
+// configured signal is received
+func (ac *Config) ReopenLogOnSignal() {
+	if ac.logReopenSignal == "" {
+		return
+	}
+	sig, ok := platformdep.Signal(ac.logReopenSignal)
+	if !ok {
+		log.Warn("Can not reopen the log file on SIG" + ac.logReopenSignal + " on this platform")
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	go func() {
+		for range c {
+			ac.logFileMutex.Lock()
+			lf := ac.logFile
+			ac.logFileMutex.Unlock()
+			if lf == nil {
+				continue
+			}
+			if err := lf.Reopen(); err != nil {
+				log.Error("Could not reopen the log file: ", err)
+			}
+		}
+	}()
+}
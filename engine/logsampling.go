@@ -0,0 +1,62 @@
+package engine
+
+// Sampling the access log, for servers with a lot of traffic
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+)
+
+// How a request is logged in the access log, as selected with nolog or forcelog
+const (
+	accessLogSampled int32 = iota // logged according to the sampling rate
+	accessLogNever                // not logged, unless the response is not 2xx
+	accessLogAlways               // always logged
+)
+
+// accessLogModeKey is the context key for the access log mode of a request
+type accessLogModeKey struct{}
+
+// accessLogMode is the access log mode of a request. It is stored as a pointer
+// in the request context, so that it can be changed by the Lua handler.
+type accessLogMode struct {
+	mode int32
+}
+
+// setAccessLogMode selects how the given request is logged in the access log
+func setAccessLogMode(req *http.Request, mode int32) {
+	if m, ok := req.Context().Value(accessLogModeKey{}).(*accessLogMode); ok {
+		atomic.StoreInt32(&m.mode, mode)
+	}
+}
+
+// shouldLogAccess checks if the given request should be logged in the access
+// log. Responses that are not 2xx are always logged.
+func (ac *Config) shouldLogAccess(req *http.Request, statusCode int) bool {
+	if statusCode < 200 || statusCode >= 300 {
+		return true
+	}
+	if m, ok := req.Context().Value(accessLogModeKey{}).(*accessLogMode); ok {
+		switch atomic.LoadInt32(&m.mode) {
+		case accessLogNever:
+			return false
+		case accessLogAlways:
+			return true
+		}
+	}
+	return ac.logSamplingRate >= 1 || rand.Float64() < ac.logSamplingRate
+}
+
+// accessLogModeHandler makes it possible for Lua handlers to select how the
+// request is logged, with nolog and forcelog, if an access log is enabled
+func (ac *Config) accessLogModeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ac.commonAccessLogFilename == "" && ac.combinedAccessLogFilename == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), accessLogModeKey{}, &accessLogMode{})))
+	})
+}
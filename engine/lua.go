@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/breaker"
 	"github.com/xyproto/algernon/lua/codelib"
 	"github.com/xyproto/algernon/lua/convert"
 	"github.com/xyproto/algernon/lua/datastruct"
@@ -15,15 +16,24 @@ import (
 	"github.com/xyproto/algernon/lua/onthefly"
 	"github.com/xyproto/algernon/lua/pquery"
 	"github.com/xyproto/algernon/lua/pure"
+	"github.com/xyproto/algernon/lua/s3"
 	"github.com/xyproto/algernon/lua/upload"
 	"github.com/xyproto/algernon/lua/users"
 	"github.com/xyproto/algernon/utils"
 	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/pinterface"
 )
 
 // LoadCommonFunctions adds most of the available Lua functions in algernon to
-// the given Lua state struct
-func (ac *Config) LoadCommonFunctions(w http.ResponseWriter, req *http.Request, filename string, L *lua.LState, flushFunc func(), httpStatus *FutureStatus) {
+// the given Lua state struct. Returns the output buffer that the Lua functions
+// write to. Output that is still buffered when the script is done can be sent
+// with StopAll.
+func (ac *Config) LoadCommonFunctions(w http.ResponseWriter, req *http.Request, filename string, L *lua.LState, flushFunc func(), httpStatus *FutureStatus) *OutputBuffer {
+
+	// All output goes through an output buffer, that buffers when buffer_start() is used
+	ob := NewOutputBuffer(w)
+	w = ob
+	LoadOutputBufferFunctions(L, ob)
 
 	// Make basic functions, like print, available to the Lua script.
 	// Only exports functions that can relate to HTTP responses or requests.
@@ -35,6 +45,9 @@ func (ac *Config) LoadCommonFunctions(w http.ResponseWriter, req *http.Request,
 	// Functions for rendering markdown or amber
 	ac.LoadRenderFunctions(w, req, L)
 
+	// Functions for reading and writing cookies
+	ac.LoadCookieFunctions(w, req, L)
+
 	// If there is a database backend
 	if ac.perm != nil {
 
@@ -61,7 +74,14 @@ func (ac *Config) LoadCommonFunctions(w http.ResponseWriter, req *http.Request,
 		datastruct.LoadList(L, creator)
 		datastruct.LoadSet(L, creator)
 		datastruct.LoadHash(L, creator)
-		datastruct.LoadKeyValue(L, creator)
+		datastruct.LoadKeyValue(L, ac.atomicCreator(creator))
+		datastruct.LoadQueue(L, ac.atomicCreator(creator))
+
+		// Server-side sessions
+		ac.LoadSessionFunctions(w, req, L, userstate)
+
+		// Feature flags
+		ac.LoadFeatureFlagFunctions(req, L, userstate)
 
 		// For saving and loading Lua functions
 		codelib.Load(L, creator)
@@ -73,6 +93,8 @@ func (ac *Config) LoadCommonFunctions(w http.ResponseWriter, req *http.Request,
 	// For handling JSON data
 	jnode.LoadJSONFunctions(L)
 	ac.LoadJFile(L, filepath.Dir(filename))
+	ac.LoadJLinesReader(L, filepath.Dir(filename), ob)
+	ac.LoadCSV(L, filepath.Dir(filename))
 	jnode.Load(L)
 
 	// Extras
@@ -92,19 +114,55 @@ func (ac *Config) LoadCommonFunctions(w http.ResponseWriter, req *http.Request,
 
 	// File uploads
 	upload.Load(L, w, req, filepath.Dir(filename))
+	if ac.perm != nil {
+		ac.LoadResumableUploadFunctions(L, w, req, filepath.Dir(filename), ac.perm.UserState().Creator())
+	}
 
-	// HTTP Client
-	httpclient.Load(L, ac.serverHeaderName)
+	// Reading the request body in chunks
+	ac.LoadBodyReaderFunctions(L, req)
+
+	// HTTP Client, which propagates the trace context, if tracing is enabled
+	httpclient.LoadWithHeaders(L, ac.serverHeaderName, traceHeaders(req))
+
+	// S3 compatible object storage
+	s3.Load(L)
+
+	// Circuit breakers for outgoing requests
+	breaker.Load(L)
+
+	// WebSocket connections and hubs
+	ac.LoadWebSocketFunctions(L, req)
+
+	// The request context, for checking if the request has timed out
+	ac.LoadRequestContextFunctions(L, req)
+
+	return ob
 }
 
 // RunLua uses a Lua file as the HTTP handler. Also has access to the userstate
 // and permissions. Returns an error if there was a problem with running the lua
-// script, otherwise nil.
-func (ac *Config) RunLua(w http.ResponseWriter, req *http.Request, filename string, flushFunc func(), fust *FutureStatus) error {
+// script, otherwise nil. Panics are returned as errors.
+func (ac *Config) RunLua(w http.ResponseWriter, req *http.Request, filename string, flushFunc func(), fust *FutureStatus) (err error) {
 
 	// Retrieve a Lua state
 	L := ac.luapool.Get()
-	defer ac.luapool.Put(L)
+
+	// A panic in a handler must never crash the server. The Lua state may
+	// be left half-way through a call, so it is closed instead of reused.
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+			L.Close()
+			return
+		}
+		ac.luapool.Put(L)
+	}()
+
+	// Stop the script if the request times out, as set with SetHandlerTimeout
+	if _, ok := req.Context().Deadline(); ok {
+		L.SetContext(req.Context())
+		defer L.RemoveContext()
+	}
 
 	// Warn if the connection is closed before the script has finished.
 	// Requires that the requestWriter has CloseNotify.
@@ -145,11 +203,16 @@ func (ac *Config) RunLua(w http.ResponseWriter, req *http.Request, filename stri
 
 	// Export functions to the Lua state
 	// Flush can be an uninitialized channel, it is handled in the function.
-	ac.LoadCommonFunctions(w, req, filename, L, flushFunc, fust)
+	ob := ac.LoadCommonFunctions(w, req, filename, L, flushFunc, fust)
 
-	// Run the script and return the error value.
+	// Run the compiled script and return the error value.
 	// Logging and/or HTTP response is handled elsewhere.
-	return L.DoFile(filename)
+	err = ac.DoCompiledFile(L, filename)
+
+	// Send any output that is still buffered
+	ob.StopAll()
+
+	return err
 }
 
 // RunConfiguration runs a Lua file as a configuration script. Also has access
@@ -184,7 +247,11 @@ func (ac *Config) RunConfiguration(filename string, mux *http.ServeMux, withHand
 		datastruct.LoadList(L, creator)
 		datastruct.LoadSet(L, creator)
 		datastruct.LoadHash(L, creator)
-		datastruct.LoadKeyValue(L, creator)
+		datastruct.LoadKeyValue(L, ac.atomicCreator(creator))
+		datastruct.LoadQueue(L, ac.atomicCreator(creator))
+
+		// Feature flags, which can be set without admin rights here
+		ac.LoadFeatureFlagFunctions(nil, L, userstate)
 
 		// For saving and loading Lua functions
 		codelib.Load(L, creator)
@@ -196,6 +263,8 @@ func (ac *Config) RunConfiguration(filename string, mux *http.ServeMux, withHand
 	// For handling JSON data
 	jnode.LoadJSONFunctions(L)
 	ac.LoadJFile(L, filepath.Dir(filename))
+	ac.LoadJLinesReader(L, filepath.Dir(filename), nil)
+	ac.LoadCSV(L, filepath.Dir(filename))
 	jnode.Load(L)
 
 	// Extras
@@ -213,6 +282,12 @@ func (ac *Config) RunConfiguration(filename string, mux *http.ServeMux, withHand
 	// HTTP Client
 	httpclient.Load(L, ac.serverHeaderName)
 
+	// S3 compatible object storage
+	s3.Load(L)
+
+	// Circuit breakers for outgoing requests
+	breaker.Load(L)
+
 	if withHandlerFunctions {
 		// Lua HTTP handlers
 		ac.LoadLuaHandlerFunctions(L, filename, mux, false, nil, ac.defaultTheme)
@@ -227,8 +302,9 @@ func (ac *Config) RunConfiguration(filename string, mux *http.ServeMux, withHand
 		return err
 	}
 
-	// Only put the Lua state back if there were no errors
-	ac.luapool.Put(L)
+	// The Lua state is not put back into the pool, since the functions that
+	// were given to handle, Every, Worker and similar functions use its global
+	// variables. See ConfigFunction.
 
 	return nil
 }
@@ -362,3 +438,13 @@ func (ac *Config) LuaFunctionMap(w http.ResponseWriter, req *http.Request, luada
 	// Return the map of functions
 	return funcs, nil
 }
+
+// atomicCreator wraps the given creator so that KeyValues can do an atomic
+// compare-and-swap, and job queues can atomically take the first job, in
+// Redis, if Redis is used as the database backend
+func (ac *Config) atomicCreator(creator pinterface.ICreator) pinterface.ICreator {
+	if state, ok := ac.redisUserState(); ok {
+		return datastruct.NewRedisCASCreator(creator, state.Pool(), state.DatabaseIndex())
+	}
+	return creator
+}
@@ -0,0 +1,90 @@
+package engine
+
+// Compiling Lua handlers to bytecode, once, instead of parsing them for every request
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/gopher-lua/parse"
+)
+
+// compiledLua is a compiled Lua script and the modification time of the file
+type compiledLua struct {
+	proto   *lua.FunctionProto
+	modTime time.Time
+}
+
+// LuaCompileCache caches compiled Lua scripts, by filename.
+// A FunctionProto can safely be shared between Lua states.
+type LuaCompileCache struct {
+	mut    sync.RWMutex
+	protos map[string]*compiledLua
+}
+
+// NewLuaCompileCache creates a new and empty cache for compiled Lua scripts
+func NewLuaCompileCache() *LuaCompileCache {
+	return &LuaCompileCache{protos: make(map[string]*compiledLua)}
+}
+
+// Clear removes all compiled Lua scripts from the cache
+func (lc *LuaCompileCache) Clear() {
+	lc.mut.Lock()
+	lc.protos = make(map[string]*compiledLua)
+	lc.mut.Unlock()
+}
+
+// CompileLua parses and compiles the given Lua file
+func CompileLua(filename string) (*lua.FunctionProto, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunk, err := parse.Parse(bufio.NewReader(f), filename)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, filename)
+}
+
+// Get returns the compiled Lua script for the given filename, compiling it if
+// needed. If checkModTime is true, the file is compiled again if it has changed.
+func (lc *LuaCompileCache) Get(filename string, checkModTime bool) (*lua.FunctionProto, error) {
+	lc.mut.RLock()
+	compiled, ok := lc.protos[filename]
+	lc.mut.RUnlock()
+	if ok && !checkModTime {
+		return compiled.proto, nil
+	}
+	fInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if ok && compiled.modTime.Equal(fInfo.ModTime()) {
+		return compiled.proto, nil
+	}
+	proto, err := CompileLua(filename)
+	if err != nil {
+		return nil, err
+	}
+	lc.mut.Lock()
+	lc.protos[filename] = &compiledLua{proto, fInfo.ModTime()}
+	lc.mut.Unlock()
+	return proto, nil
+}
+
+// DoCompiledFile runs the given Lua file in the given Lua state, like L.DoFile,
+// but using the compiled bytecode from the cache. The file is only checked for
+// changes when not in production mode.
+func (ac *Config) DoCompiledFile(L *lua.LState, filename string) error {
+	proto, err := ac.luaCompileCache.Get(filename, !ac.productionMode)
+	if err != nil {
+		return err
+	}
+	L.Push(L.NewFunctionFromProto(proto))
+	return L.PCall(0, lua.MultRet, nil)
+}
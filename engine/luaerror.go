@@ -0,0 +1,82 @@
+package engine
+
+// Reporting errors in Lua handlers to a Lua function, as set with OnError
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/gopher-lua"
+)
+
+// luaErrorParts returns the error message and the Lua stack traceback, if any
+func luaErrorParts(err error) (string, string) {
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		return apiErr.Object.String(), apiErr.StackTrace
+	}
+	return err.Error(), ""
+}
+
+// LuaErrorText returns the error message, followed by the Lua stack
+// traceback if tracebacks are enabled with SetDebugTraceback
+func (ac *Config) LuaErrorText(err error) string {
+	message, traceback := luaErrorParts(err)
+	if !ac.debugTraceback || traceback == "" {
+		return message
+	}
+	return message + "\n" + traceback
+}
+
+// panicError converts a recovered panic to an error
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("panic: %s", err)
+	}
+	return fmt.Errorf("panic: %v", r)
+}
+
+// RunErrorHandler calls the Lua function that has been set with OnError, if
+// any, with a table that describes the error and the request. The function
+// can output a custom error page. The status code is 500, unless the function
+// sets another one, or the response was already started. Returns true if the
+// error was handled.
+func (ac *Config) RunErrorHandler(w http.ResponseWriter, req *http.Request, filename string, handlerErr error, responded bool) bool {
+	if ac.onErrorFunc == nil {
+		return false
+	}
+
+	// The function uses the Lua state of the configuration script
+	ac.configMutex.Lock()
+	defer ac.configMutex.Unlock()
+	L := ac.onErrorFunc.L
+	req = withConfigLocked(req)
+
+	httpStatus := &FutureStatus{}
+	ob := ac.LoadCommonFunctions(w, req, filename, L, nil, httpStatus)
+
+	message, traceback := luaErrorParts(handlerErr)
+	info := L.NewTable()
+	info.RawSetString("message", lua.LString(message))
+	info.RawSetString("traceback", lua.LString(traceback))
+	info.RawSetString("filename", lua.LString(filename))
+	info.RawSetString("method", lua.LString(req.Method))
+	info.RawSetString("path", lua.LString(req.URL.Path))
+	info.RawSetString("url", lua.LString(req.URL.String()))
+	info.RawSetString("ip", lua.LString(ac.ClientIP(req)))
+
+	// Output is buffered, so that the status code can be written first
+	ob.Start()
+	if _, err := ac.onErrorFunc.call(info); err != nil {
+		ob.StopAll()
+		log.Error("The OnError function failed: ", err)
+		return false
+	}
+	// The status code can not be changed if the failed handler or the
+	// OnError function has already started the response
+	if httpStatus.code == 0 && !responded && !ob.Responded() {
+		ob.WriteHeader(http.StatusInternalServerError)
+	}
+	ob.StopAll()
+	return true
+}
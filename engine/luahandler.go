@@ -3,20 +3,18 @@ package engine
 import (
 	"net/http"
 	"path/filepath"
-	"sync"
 
 	"github.com/didip/tollbooth"
 	log "github.com/sirupsen/logrus"
 	"github.com/xyproto/algernon/themes"
 	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/sheepcounter"
 )
 
 // LoadLuaHandlerFunctions makes functions related to handling HTTP requests
 // available to Lua scripts
 func (ac *Config) LoadLuaHandlerFunctions(L *lua.LState, filename string, mux *http.ServeMux, addDomain bool, httpStatus *FutureStatus, theme string) {
 
-	luahandlermutex := &sync.RWMutex{}
-
 	L.SetGlobal("handle", L.NewFunction(func(L *lua.LState) int {
 
 		handlePath := L.ToString(1)
@@ -27,16 +25,43 @@ func (ac *Config) LoadLuaHandlerFunctions(L *lua.LState, filename string, mux *h
 
 		wrappedHandleFunc := func(w http.ResponseWriter, req *http.Request) {
 
-			// Set up a new Lua state with the current http.ResponseWriter and *http.Request
-			luahandlermutex.Lock()
-			ac.LoadCommonFunctions(w, req, filename, L, nil, httpStatus)
-			luahandlermutex.Unlock()
+			// Prepare to record the status code and count bytes written
+			sw := NewStatusWriter(w)
+			sc := sheepcounter.New(sw)
+			defer func() {
+				ac.LogAccess(req, sw.Status(), sc.Counter())
+			}()
+
+			// Compress the response, if enabled and suitable
+			w, closeCompression := ac.NewCompressWriter(sc, req)
+			defer closeCompression()
+
+			// The Lua state of the configuration script is shared with the
+			// other handlers, and with scheduled tasks, workers and subscribers
+			ac.configMutex.Lock()
+
+			// Set up the Lua state with the current http.ResponseWriter and *http.Request
+			ob := ac.LoadCommonFunctions(w, withConfigLocked(req), filename, L, nil, httpStatus)
 
-			// Then run the given Lua function
+			// Then run the given Lua function, and stop it if the request
+			// times out, so that the other handlers are not kept waiting
+			if _, ok := req.Context().Deadline(); ok {
+				L.SetContext(req.Context())
+			}
 			L.Push(handleFunc)
-			if err := L.PCall(0, lua.MultRet, nil); err != nil {
+			err := L.PCall(0, lua.MultRet, nil)
+			L.RemoveContext()
+
+			// Send any output that is still buffered
+			ob.StopAll()
+
+			ac.configMutex.Unlock()
+
+			if err != nil {
 				// Non-fatal error
-				log.Error("Handler for "+handlePath+" failed:", err)
+				log.Error("Handler for "+handlePath+" failed: ", ac.LuaErrorText(err))
+				// Let the OnError function handle the error, if set
+				ac.RunErrorHandler(w, req, filename, err, ob.Responded())
 			}
 
 			// Then exit after the first request, if specified
@@ -52,7 +77,7 @@ func (ac *Config) LoadLuaHandlerFunctions(L *lua.LState, filename string, mux *h
 			limiter := tollbooth.NewLimiter(float64(ac.limitRequests), nil)
 			limiter.SetMessage(themes.MessagePage("Rate-limit exceeded", "<div style='color:red'>You have reached the maximum request limit.</div>", theme))
 			limiter.SetMessageContentType("text/html;charset=utf-8")
-			mux.Handle(handlePath, tollbooth.LimitFuncHandler(limiter, wrappedHandleFunc))
+			mux.Handle(handlePath, ac.LimitFuncHandler(limiter, wrappedHandleFunc))
 		}
 
 		return 0 // number of results
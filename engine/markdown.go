@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"bytes"
+
+	"github.com/xyproto/algernon/utils"
+	"github.com/xyproto/gopher-lua"
+	"gopkg.in/russross/blackfriday.v2"
+)
+
+// markdownExtensions maps the options that can be given to the markdown Lua
+// function to blackfriday extensions
+var markdownExtensions = map[string]blackfriday.Extensions{
+	"tables":     blackfriday.Tables,
+	"autolinks":  blackfriday.Autolink,
+	"footnotes":  blackfriday.Footnotes,
+	"hardbreaks": blackfriday.HardLineBreak,
+}
+
+// MarkdownOptions is a Markdown flavor, as used when rendering Markdown
+type MarkdownOptions struct {
+	Extensions blackfriday.Extensions
+	TaskLists  bool
+}
+
+// DefaultMarkdownOptions returns the options that are used when no options
+// are given, which are the blackfriday defaults, without task lists
+func DefaultMarkdownOptions() MarkdownOptions {
+	return MarkdownOptions{Extensions: blackfriday.CommonExtensions}
+}
+
+// Table2MarkdownOptions modifies the default options with the boolean "tables",
+// "tasklists", "autolinks", "footnotes" and "hardbreaks" fields in the given table
+func Table2MarkdownOptions(table *lua.LTable) MarkdownOptions {
+	options := DefaultMarkdownOptions()
+	if value, ok := table.RawGetString("tasklists").(lua.LBool); ok {
+		options.TaskLists = bool(value)
+	}
+	for name, extension := range markdownExtensions {
+		value, ok := table.RawGetString(name).(lua.LBool)
+		switch {
+		case !ok:
+			continue
+		case bool(value):
+			options.Extensions |= extension
+		default:
+			options.Extensions &^= extension
+		}
+	}
+	return options
+}
+
+// RenderMarkdown converts the given Markdown to HTML, using the given options
+func RenderMarkdown(data []byte, options MarkdownOptions) []byte {
+	htmlbody := blackfriday.Run(data, blackfriday.WithExtensions(options.Extensions))
+	if options.TaskLists {
+		htmlbody = markdownTaskLists(htmlbody)
+	}
+	return htmlbody
+}
+
+// markdownTaskLists replaces list items that start with "[ ]" or "[x]" with
+// disabled checkboxes
+func markdownTaskLists(htmlbody []byte) []byte {
+	htmlbody = bytes.Replace(htmlbody, []byte("<li>[ ] "), []byte("<li><input type=\"checkbox\" disabled> "), utils.EveryInstance)
+	htmlbody = bytes.Replace(htmlbody, []byte("<li><p>[ ] "), []byte("<li><p><input type=\"checkbox\" disabled> "), utils.EveryInstance)
+	htmlbody = bytes.Replace(htmlbody, []byte("<li>[x] "), []byte("<li><input type=\"checkbox\" disabled checked> "), utils.EveryInstance)
+	htmlbody = bytes.Replace(htmlbody, []byte("<li>[X] "), []byte("<li><input type=\"checkbox\" disabled checked> "), utils.EveryInstance)
+	htmlbody = bytes.Replace(htmlbody, []byte("<li><p>[x] "), []byte("<li><p><input type=\"checkbox\" disabled checked> "), utils.EveryInstance)
+	return htmlbody
+}
@@ -0,0 +1,171 @@
+package engine
+
+// Output buffering for Lua scripts, with buffer_start() and buffer_flush()
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/recwatch"
+)
+
+// OutputBuffer is a http.ResponseWriter that can collect the output in a
+// stack of buffers, instead of sending it directly. While buffering, the
+// status code is also held back, so that headers can still be changed.
+type OutputBuffer struct {
+	http.ResponseWriter
+	buffers   []*bytes.Buffer
+	status    int
+	responded bool        // true when the headers or output have been passed on
+	closers   []io.Closer // files that are closed when the request is done
+}
+
+// NewOutputBuffer wraps the given http.ResponseWriter. Output is not
+// buffered until Start is called.
+func NewOutputBuffer(w http.ResponseWriter) *OutputBuffer {
+	return &OutputBuffer{ResponseWriter: w}
+}
+
+// Buffering returns true if output is currently being buffered
+func (ob *OutputBuffer) Buffering() bool {
+	return len(ob.buffers) > 0
+}
+
+// Responded returns true if the status code, headers or output have been
+// passed on to the wrapped http.ResponseWriter, after which the status code
+// and headers can no longer be changed
+func (ob *OutputBuffer) Responded() bool {
+	return ob.responded
+}
+
+// closeAtEnd registers something that should be closed by StopAll, when the
+// request is done, in case the Lua script does not close it
+func (ob *OutputBuffer) closeAtEnd(c io.Closer) {
+	ob.closers = append(ob.closers, c)
+}
+
+// Start starts buffering output. Buffers can be nested.
+func (ob *OutputBuffer) Start() {
+	ob.buffers = append(ob.buffers, &bytes.Buffer{})
+}
+
+// writeStatus writes the status code that was held back, if any
+func (ob *OutputBuffer) writeStatus() {
+	if ob.status != 0 {
+		ob.ResponseWriter.WriteHeader(ob.status)
+		ob.status = 0
+		ob.responded = true
+	}
+}
+
+// WriteHeader holds back the status code while buffering
+func (ob *OutputBuffer) WriteHeader(status int) {
+	if ob.Buffering() {
+		ob.status = status
+		return
+	}
+	ob.ResponseWriter.WriteHeader(status)
+	ob.responded = true
+}
+
+// Write writes to the current buffer, or to the wrapped http.ResponseWriter
+// if output is not being buffered
+func (ob *OutputBuffer) Write(data []byte) (int, error) {
+	if ob.Buffering() {
+		return ob.buffers[len(ob.buffers)-1].Write(data)
+	}
+	ob.writeStatus()
+	ob.responded = true
+	return ob.ResponseWriter.Write(data)
+}
+
+// End stops the current buffer and returns the contents, without writing it
+func (ob *OutputBuffer) End() []byte {
+	if !ob.Buffering() {
+		return nil
+	}
+	last := len(ob.buffers) - 1
+	data := ob.buffers[last].Bytes()
+	ob.buffers = ob.buffers[:last]
+	return data
+}
+
+// Get returns the contents of the current buffer
+func (ob *OutputBuffer) Get() []byte {
+	if !ob.Buffering() {
+		return nil
+	}
+	return ob.buffers[len(ob.buffers)-1].Bytes()
+}
+
+// Stop stops the current buffer and writes the contents to the buffer below,
+// or to the wrapped http.ResponseWriter if this was the last buffer
+func (ob *OutputBuffer) Stop() {
+	if ob.Buffering() {
+		ob.Write(ob.End())
+	}
+}
+
+// StopAll stops all buffers and writes the output, together with the status
+// code that was held back, if any. Files that were opened for the request
+// are then closed.
+func (ob *OutputBuffer) StopAll() {
+	for ob.Buffering() {
+		ob.Stop()
+	}
+	ob.writeStatus()
+	for _, c := range ob.closers {
+		if err := c.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+	ob.closers = nil
+}
+
+// Flush sends the output so far to the client, if output is not being buffered
+func (ob *OutputBuffer) Flush() {
+	if !ob.Buffering() {
+		ob.writeStatus()
+		recwatch.Flush(ob.ResponseWriter)
+		ob.responded = true
+	}
+}
+
+// LoadOutputBufferFunctions makes functions for buffering output available to Lua
+func LoadOutputBufferFunctions(L *lua.LState, ob *OutputBuffer) {
+
+	// Start collecting the output, instead of sending it. Can be nested.
+	L.SetGlobal("buffer_start", L.NewFunction(func(L *lua.LState) int {
+		ob.Start()
+		return 0 // number of results
+	}))
+
+	// Stop collecting output and send the collected output
+	L.SetGlobal("buffer_flush", L.NewFunction(func(L *lua.LState) int {
+		ob.Stop()
+		return 0 // number of results
+	}))
+
+	// Return the output that has been collected so far
+	L.SetGlobal("buffer_get", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(string(ob.Get())))
+		return 1 // number of results
+	}))
+
+	// Stop collecting output and return the collected output, without sending it
+	L.SetGlobal("buffer_end", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(string(ob.End())))
+		return 1 // number of results
+	}))
+
+	// Check if the status code, headers or output have been sent, after which
+	// status and setheader no longer have an effect
+	L.SetGlobal("responded", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(ob.Responded()))
+		return 1 // number of results
+	}))
+
+}
@@ -0,0 +1,54 @@
+package engine
+
+// Profiling with net/http/pprof, behind the admin permission prefix
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/xyproto/sheepcounter"
+)
+
+// The default URL path prefix for the pprof handlers
+const defaultPprofPrefix = "/debug/pprof"
+
+// RegisterPprof serves the net/http/pprof handlers at the given URL path prefix.
+// The prefix is registered as an admin prefix, so that only logged in
+// administrators can reach the profiling data.
+func (ac *Config) RegisterPprof(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	ac.addPermissionPrefix(prefix, true)
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		// Rejecting requests is handled by the permission system
+		if ac.perm.Rejected(w, req) {
+			sc := sheepcounter.New(w)
+			ac.perm.DenyFunction()(sc, req)
+			ac.LogAccess(req, http.StatusForbidden, sc.Counter())
+			return
+		}
+		sw := NewStatusWriter(w)
+		sc := sheepcounter.New(sw)
+		switch name := strings.TrimPrefix(req.URL.Path, prefix+"/"); name {
+		case "cmdline":
+			pprof.Cmdline(sc, req)
+		case "profile":
+			pprof.Profile(sc, req)
+		case "symbol":
+			pprof.Symbol(sc, req)
+		case "trace":
+			pprof.Trace(sc, req)
+		default:
+			// pprof.Index expects the path to start with /debug/pprof/
+			r := *req
+			u := *req.URL
+			u.Path = "/debug/pprof/" + name
+			r.URL = &u
+			pprof.Index(sc, &r)
+		}
+		ac.LogAccess(req, sw.Status(), sc.Counter())
+	}
+
+	mux.HandleFunc(prefix+"/", handler)
+}
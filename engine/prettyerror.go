@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"html"
 	"net/http"
 	"strconv"
 	"strings"
@@ -103,6 +104,22 @@ func (ac *Config) PrettyError(w http.ResponseWriter, req *http.Request, filename
 		code = string(bytes.Join(bytelines, []byte("\n")))
 	}
 
+	// Show the Lua stack traceback separately from the error message
+	traceback := ""
+	if lang == "lua" {
+		if pos := strings.Index(errormessage, "stack traceback:"); pos > 0 {
+			errormessage, traceback = errormessage[:pos], errormessage[pos:]
+		}
+	}
+	tracebackHTML := ""
+	if traceback != "" {
+		tracebackHTML = `
+    Traceback:
+    <div>
+      <pre id="wrap"><code class="nohighlight">` + html.EscapeString(strings.TrimSpace(traceback)) + `</code></pre>
+    </div>`
+	}
+
 	// Set an appropriate title
 	title := errorPageTitle(lang)
 
@@ -155,7 +172,7 @@ func (ac *Config) PrettyError(w http.ResponseWriter, req *http.Request, filename
     Error message:
     <div>
       <pre id="wrap"><code style="color: #A00000;" class="` + errorclass + `">` + strings.TrimSpace(errormessage) + `</code></pre>
-    </div>
+    </div>` + tracebackHTML + `
     <div id="right">` + ac.versionString + `</div>
 `)
 
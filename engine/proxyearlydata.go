@@ -0,0 +1,38 @@
+package engine
+
+// Support for RFC 8470, for requests that a proxy in front of the server has
+// received as TLS 1.3 or QUIC early data (0-RTT). The TLS and QUIC listeners
+// of the server do not accept early data, so this only concerns proxies.
+
+import (
+	"net/http"
+)
+
+// statusTooEarly is "425 Too Early", from RFC 8470. http.StatusTooEarly
+// needs Go 1.12.
+const statusTooEarly = 425
+
+// IsProxyEarlyData checks if a proxy or load balancer in front of the server has
+// marked the given request as sent, in full or in part, as TLS 1.3 early data
+// (0-RTT), which may be replayed by an attacker. The proxy marks such requests
+// with the "Early-Data: 1" header, as described in RFC 8470. This is only a
+// check of that header. Neither the TLS server nor the QUIC server accepts
+// early data by itself, so requests that are received directly are never
+// early data.
+func IsProxyEarlyData(req *http.Request) bool {
+	return req.Header.Get("Early-Data") == "1"
+}
+
+// proxyEarlyDataHandler responds with "425 Too Early" to requests that a proxy has
+// marked as early data, unless early data has been enabled with SetProxyEarlyData.
+// The proxy and the client are then expected to retry the request after the
+// handshake.
+func (ac *Config) proxyEarlyDataHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !ac.proxyEarlyData && IsProxyEarlyData(req) {
+			http.Error(w, "Too Early", statusTooEarly)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,140 @@
+package engine
+
+// Publish/subscribe, using Redis when available, and polling a KeyValue otherwise
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/gopher-lua"
+	permissions "github.com/xyproto/permissions2"
+	"github.com/xyproto/pinterface"
+)
+
+const (
+	// How often subscribers check for new messages, when Redis is not used
+	pubsubPollInterval = 250 * time.Millisecond
+
+	// How many messages to keep per channel, when Redis is not used
+	pubsubKeepMessages = 100
+)
+
+// redisUserState returns the Redis userstate, if Redis is used as the database backend
+func (ac *Config) redisUserState() (*permissions.UserState, bool) {
+	if ac.perm == nil {
+		return nil, false
+	}
+	state, ok := ac.perm.UserState().(*permissions.UserState)
+	return state, ok
+}
+
+// pubsubKeyValue returns the KeyValue that is used for a channel, when Redis is not used
+func (ac *Config) pubsubKeyValue(channel string) (pinterface.IKeyValue, error) {
+	return ac.perm.UserState().Creator().NewKeyValue("pubsub:" + channel)
+}
+
+// Publish sends a message to all subscribers of the given channel
+func (ac *Config) Publish(channel, message string) error {
+	if state, ok := ac.redisUserState(); ok {
+		conn := state.Pool().Get(state.DatabaseIndex())
+		defer conn.Close()
+		_, err := conn.Do("PUBLISH", channel, message)
+		return err
+	}
+	kv, err := ac.pubsubKeyValue(channel)
+	if err != nil {
+		return err
+	}
+	seqString, err := kv.Inc("seq")
+	if err != nil {
+		return err
+	}
+	if err := kv.Set("msg:"+seqString, message); err != nil {
+		return err
+	}
+	// Remove an old message, to keep the number of stored messages down
+	if seq, err := strconv.Atoi(seqString); err == nil && seq > pubsubKeepMessages {
+		kv.Del("msg:" + strconv.Itoa(seq-pubsubKeepMessages))
+	}
+	return nil
+}
+
+// runSubscriber calls the given Lua function from the configuration script
+// with a message and a channel
+func (ac *Config) runSubscriber(luaFunc *ConfigFunction, channel, message string) {
+	if _, err := ac.callConfigFunction(luaFunc, lua.LString(message), lua.LString(channel)); err != nil {
+		log.Error("Subscriber for ", channel, " failed: ", err)
+	}
+}
+
+// Subscribe calls the given Lua function for each message that is published
+// on the given channel, until shutdown
+func (ac *Config) Subscribe(channel string, luaFunc *ConfigFunction) error {
+	stop := ac.scheduleStop()
+	if state, ok := ac.redisUserState(); ok {
+		psc := redis.PubSubConn{Conn: state.Pool().Get(state.DatabaseIndex())}
+		if err := psc.Subscribe(channel); err != nil {
+			psc.Close()
+			return err
+		}
+		go func() {
+			<-stop
+			// Closing the connection makes Receive return
+			psc.Unsubscribe()
+			psc.Close()
+		}()
+		go func() {
+			for {
+				switch v := psc.Receive().(type) {
+				case redis.Message:
+					ac.runSubscriber(luaFunc, v.Channel, string(v.Data))
+				case error:
+					select {
+					case <-stop:
+					default:
+						log.Error("Subscription to ", channel, " ended: ", v)
+					}
+					return
+				}
+			}
+		}()
+		return nil
+	}
+	kv, err := ac.pubsubKeyValue(channel)
+	if err != nil {
+		return err
+	}
+	// Only messages that are published after subscribing are received
+	seqString, _ := kv.Get("seq")
+	lastSeq, _ := strconv.Atoi(seqString)
+	go func() {
+		ticker := time.NewTicker(pubsubPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			seqString, err := kv.Get("seq")
+			if err != nil {
+				continue
+			}
+			seq, _ := strconv.Atoi(seqString)
+			if seq-lastSeq > pubsubKeepMessages {
+				// Some messages have already been removed
+				lastSeq = seq - pubsubKeepMessages
+			}
+			for ; lastSeq < seq; lastSeq++ {
+				message, err := kv.Get("msg:" + strconv.Itoa(lastSeq+1))
+				if err != nil {
+					continue
+				}
+				ac.runSubscriber(luaFunc, channel, message)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/xyproto/quic"
+	"github.com/xyproto/quic/http3"
+)
+
+// The congestion control algorithms that are supported by the QUIC package
+var quicCongestionAlgorithms = map[string]bool{
+	"cubic": true,
+}
+
+// NewQUICConfig returns the configuration for QUIC connections
+func (ac *Config) NewQUICConfig() *quic.Config {
+	config := &quic.Config{
+		IdleTimeout:        time.Duration(ac.idleTimeout) * time.Second,
+		MaxIncomingStreams: ac.quicMaxStreams,
+	}
+	if ac.quicReceiveWindow > 0 {
+		// Keep the same ratio between the stream and connection windows as
+		// the defaults of the QUIC package, which are 1 MB and 1.5 MB
+		config.MaxReceiveStreamFlowControlWindow = ac.quicReceiveWindow
+		config.MaxReceiveConnectionFlowControlWindow = ac.quicReceiveWindow * 3 / 2
+	}
+	return config
+}
+
+// ListenAndServeQUIC listens for both HTTPS (TLS over TCP) and QUIC (over UDP)
+// on the given address, and serves the given handler. Returns when one of the two servers returns an error.
+// This is similar to http3.ListenAndServe, but uses the configured timeouts.
+// Client certificates are not supported.
+func (ac *Config) ListenAndServeQUIC(addr, certFile, keyFile string, handler http.Handler) error {
+	if ac.clientCAs != nil {
+		return errQUICClientCert
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	// Open the listeners
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
+	}
+	tcpConn, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return err
+	}
+	defer tcpConn.Close()
+
+	tlsConn := tls.NewListener(tcpConn, config)
+	defer tlsConn.Close()
+
+	// Configure the servers
+	httpServer := &http.Server{
+		Addr:           addr,
+		TLSConfig:      config,
+		ReadTimeout:    time.Duration(ac.readTimeout) * time.Second,
+		WriteTimeout:   time.Duration(ac.writeTimeout) * time.Second,
+		IdleTimeout:    time.Duration(ac.idleTimeout) * time.Second,
+		MaxHeaderBytes: 1 << 20,
+		// Limit the number of connections per client IP, if configured
+		ConnState: ac.connLimiter.connState,
+	}
+	quicServer := &http3.Server{
+		Server:     httpServer,
+		QuicConfig: ac.NewQUICConfig(),
+	}
+	// The Alt-Svc header is set by wrapHandler
+	httpServer.Handler = ac.reloadHandler(ac.wrapHandler(handler))
+
+	// Start the servers
+	hErr := make(chan error)
+	qErr := make(chan error)
+	go func() {
+		hErr <- httpServer.Serve(tlsConn)
+	}()
+	go func() {
+		qErr <- quicServer.Serve(ac.limitPacketConn(udpConn))
+	}()
+
+	select {
+	case err := <-hErr:
+		quicServer.Close()
+		return err
+	case err := <-qErr:
+		return err
+	}
+}
@@ -0,0 +1,204 @@
+package engine
+
+// Reloading the server configuration scripts when SIGHUP is received
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	bolt "github.com/xyproto/permissionbolt"
+	redis "github.com/xyproto/permissions2"
+	mariadb "github.com/xyproto/permissionsql"
+	"github.com/xyproto/pinterface"
+	postgres "github.com/xyproto/pstore"
+)
+
+// The permission prefixes that all the database backends start out with
+var (
+	defaultAdminPrefixes = []string{"/admin"}
+	defaultUserPrefixes  = []string{"/repo", "/data"}
+)
+
+// reloadHandler serves requests with the handlers of the latest reloaded
+// configuration, if the configuration has been reloaded, and with the given
+// handler otherwise
+func (ac *Config) reloadHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if reloaded, ok := ac.reloadedHandler.Load().(http.Handler); ok {
+			reloaded.ServeHTTP(w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// newPermissions returns new permissions for the same database backend as
+// the given permissions, with the default permission prefixes
+func newPermissions(perm pinterface.IPermissions) (pinterface.IPermissions, error) {
+	switch state := perm.UserState().(type) {
+	case *redis.UserState:
+		return redis.NewPermissions(state), nil
+	case *bolt.UserState:
+		return bolt.NewPermissions(state), nil
+	case *mariadb.UserState:
+		return mariadb.NewPermissions(state), nil
+	case *postgres.UserState:
+		return postgres.NewPermissions(state), nil
+	}
+	return nil, errors.New("can not reload the permissions for this database backend")
+}
+
+// reloadCopy returns a copy of the configuration, where the configuration
+// that the configuration scripts add to is reset, so that the scripts can be
+// run again. The caches, the Lua pool, the user state, the WebSocket hubs and
+// similar state is shared with the copy. Settings that are not reset keep
+// their current values.
+func (ac *Config) reloadCopy() (*Config, error) {
+	next := new(Config)
+	*next = *ac
+	if ac.perm != nil {
+		perm, err := newPermissions(ac.perm)
+		if err != nil {
+			return nil, err
+		}
+		if ac.clearDefaultPathPrefixes {
+			perm.Clear()
+		} else {
+			perm.SetAdminPath(defaultAdminPrefixes)
+			perm.SetUserPath(defaultUserPrefixes)
+		}
+		perm.SetDenyFunction(redis.PermissionDenied)
+		next.perm = perm
+	}
+	next.routes = nil
+	next.embeddedMounts = nil
+	next.spaFallbacks = nil
+	next.errorPages = nil
+	next.reverseProxies = nil
+	next.webDAVs = nil
+	next.maxBodyBytesPrefixes = nil
+	next.handlerTimeoutPrefixes = nil
+	next.envAllowed = nil
+	next.resumableUploadPath = ""
+	next.pprofPrefix = ""
+	next.healthLivePath = ""
+	next.healthReadyPath = ""
+	next.sitemapPath = ""
+	next.sitemapPriorities = nil
+	next.sitemapBaseURL = ""
+	next.sitemapCache = nil
+	next.robots = nil
+	next.logSamplingRate = 1
+	next.trailingSlash = ""
+	next.caseInsensitivePaths = false
+	next.mixedCasePrefixes = nil
+	next.basicAuths = nil
+	next.tracer = nil
+	next.onErrorFunc = nil
+	next.templateGlobals = nil
+	next.templateFuncs = nil
+	next.templateMutex = &sync.Mutex{}
+	next.translations = nil
+	next.indexFiles = nil
+	next.debugTraceback = true
+
+	// The scheduled tasks, workers and subscriptions of the copy are
+	// started by the scripts, and stopped separately
+	next.scheduleStopChan = nil
+	next.scheduleStopped = false
+	next.scheduleMutex = &sync.Mutex{}
+
+	// Only the first configuration keeps track of the reloads
+	next.reloadedConfig = nil
+	next.reloadedHandler = &atomic.Value{}
+
+	// The copy starts out with the current limit on concurrent requests,
+	// but changing it must not affect this configuration
+	next.requestLimiter = &atomic.Value{}
+	next.setRequestLimiter(ac.loadRequestLimiter())
+
+	return next, nil
+}
+
+// Reload runs the server configuration scripts again, on a copy of the latest
+// configuration. If the scripts succeed, the copy handles the new requests,
+// while ongoing requests are not interrupted. If they fail, the latest
+// configuration is kept.
+func (ac *Config) Reload() error {
+	ac.reloadMutex.Lock()
+	defer ac.reloadMutex.Unlock()
+
+	if ac.onlyLuaMode {
+		return errors.New("nothing to reload when only running Lua")
+	}
+
+	current := ac
+	if ac.reloadedConfig != nil {
+		current = ac.reloadedConfig
+	}
+	next, err := current.reloadCopy()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	if err := next.runConfigurationScripts(mux); err != nil {
+		// Stop what the failed scripts may have started
+		next.stopScheduled()
+		return err
+	}
+	next.registerConfiguredHandlers(mux)
+
+	// Let changed files be read and compiled again
+	if next.cache != nil {
+		next.cache.Clear()
+	}
+	next.luaCompileCache.Clear()
+
+	ac.reloadedConfig = next
+	ac.reloadedHandler.Store(next.wrapHandler(mux))
+
+	// Stop the scheduled tasks, workers and subscriptions that the previous
+	// configuration started
+	current.stopScheduled()
+
+	return nil
+}
+
+// runConfigurationScripts runs the server configuration scripts, and the Lua
+// server file or the handlers for the server directory, for the given mux
+func (ac *Config) runConfigurationScripts(mux *http.ServeMux) error {
+	withHandlerFunctions := true
+	for _, filename := range ac.serverConfigurationFilenames {
+		if err := ac.RunConfiguration(filename, mux, withHandlerFunctions); err != nil {
+			return err
+		}
+	}
+	if ac.luaServerFilename != "" {
+		return ac.RunConfiguration(ac.luaServerFilename, mux, withHandlerFunctions)
+	}
+	ac.RegisterHandlers(mux, "/", ac.serverDirOrFilename, ac.serverAddDomain)
+	return nil
+}
+
+// ReloadOnSignal reloads the configuration scripts every time SIGHUP is received
+func (ac *Config) ReloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			log.Info("Reloading the configuration")
+			if err := ac.Reload(); err != nil {
+				log.Error("Could not reload the configuration: ", err)
+				continue
+			}
+			log.Info("Reloaded the configuration")
+		}
+	}()
+}
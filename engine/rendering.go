@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/eknkc/amber"
 	"github.com/xyproto/pongo2"
@@ -25,6 +26,9 @@ import (
 	"gopkg.in/russross/blackfriday.v2"
 )
 
+// Identifier for the userdata that is returned by raw() in Lua
+const rawClass = "RAW"
+
 // ValidGCSS checks if the given data is valid GCSS.
 // The error value is returned on the channel.
 func ValidGCSS(gcssdata []byte, errorReturn chan error) {
@@ -42,8 +46,13 @@ func (ac *Config) LoadRenderFunctions(w http.ResponseWriter, req *http.Request,
 	L.SetGlobal("mprint", L.NewFunction(func(L *lua.LState) int {
 		// Retrieve all the function arguments as a bytes.Buffer
 		buf := convert.Arguments2buffer(L, true)
+		// Leave out the front matter, if any
+		_, data, err := utils.SplitFrontMatter(buf.Bytes())
+		if err != nil {
+			log.Error(err)
+		}
 		// Convert the buffer to markdown and output the translated string
-		w.Write(blackfriday.Run(buf.Bytes()))
+		w.Write(blackfriday.Run(data))
 		return 0 // number of results
 	}))
 
@@ -68,6 +77,25 @@ func (ac *Config) LoadRenderFunctions(w http.ResponseWriter, req *http.Request,
 		return 0 // number of results
 	}))
 
+	// Mark a string as trusted HTML, that should not be escaped when used
+	// as a value in a Pongo2 template
+	rawMetatable := L.NewTypeMetatable(rawClass)
+	rawMetatable.RawSetH(lua.LString("__tostring"), L.NewFunction(func(L *lua.LState) int {
+		if value, ok := L.CheckUserData(1).Value.(*pongo2.Value); ok {
+			L.Push(lua.LString(value.String()))
+			return 1 // number of results
+		}
+		L.Push(lua.LString(""))
+		return 1 // number of results
+	}))
+	L.SetGlobal("raw", L.NewFunction(func(L *lua.LState) int {
+		ud := L.NewUserData()
+		ud.Value = pongo2.AsSafeValue(L.CheckString(1))
+		L.SetMetatable(ud, rawMetatable)
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
 	// Output text as rendered Pongo2
 	L.SetGlobal("poprint", L.NewFunction(func(L *lua.LState) int {
 		pongoMap := make(pongo2.Context)
@@ -84,6 +112,12 @@ func (ac *Config) LoadRenderFunctions(w http.ResponseWriter, req *http.Request,
 			for k, v := range mapSS {
 				pongoMap[k] = v
 			}
+			// Values that are marked with raw() are not escaped
+			L.CheckTable(2).ForEach(func(key, value lua.LValue) {
+				if ud, ok := value.(*lua.LUserData); ok {
+					pongoMap[key.String()] = ud.Value
+				}
+			})
 		}
 
 		// Retrieve all the function arguments as a bytes.Buffer
@@ -116,7 +150,8 @@ func (ac *Config) LoadRenderFunctions(w http.ResponseWriter, req *http.Request,
 		buf := convert.Arguments2buffer(L, true)
 		// Transform GCSS to CSS and output the result.
 		// Ignoring the number of bytes written.
-		if _, err := gcss.Compile(w, &buf); err != nil {
+		var cssbuf bytes.Buffer
+		if _, err := gcss.Compile(&cssbuf, &buf); err != nil {
 			if ac.debugMode {
 				fmt.Fprint(w, "Could not compile GCSS:\n\t"+err.Error()+"\n\n"+buf.String())
 			} else {
@@ -124,6 +159,11 @@ func (ac *Config) LoadRenderFunctions(w http.ResponseWriter, req *http.Request,
 			}
 			//return 0 // number of results
 		}
+		if ac.cssMinify {
+			w.Write(MinifyCSS(cssbuf.Bytes()))
+		} else {
+			w.Write(cssbuf.Bytes())
+		}
 		return 0 // number of results
 	}))
 
@@ -209,17 +249,32 @@ func (ac *Config) LoadRenderFunctions(w http.ResponseWriter, req *http.Request,
 
 // MarkdownPage write the given source bytes as markdown wrapped in HTML to a writer, with a title
 func (ac *Config) MarkdownPage(w http.ResponseWriter, req *http.Request, data []byte, filename string) {
+	defer ac.renderStats.Record("markdown", filename, time.Now())
+
 	// Prepare for receiving title and codeStyle information
 	searchKeywords := []string{"title", "codestyle", "theme", "replace_with_theme", "css", "favicon"}
 
 	// Also prepare for receiving meta tag information
 	searchKeywords = append(searchKeywords, themes.MetaKeywords...)
 
+	// Separate the YAML or TOML front matter, if any, from the Markdown
+	meta, data, err := utils.SplitFrontMatter(data)
+	if err != nil {
+		log.Errorf("%s: %s", filename, err)
+	}
+
 	// Extract keywords from the given data, and remove the lines with keywords,
 	// but only the first time that keyword occurs.
 	var kwmap map[string][]byte
 	data, kwmap = utils.ExtractKeywords(data, searchKeywords)
 
+	// Keywords may also be given in the front matter
+	for _, keyword := range searchKeywords {
+		if value, ok := meta[keyword]; ok && len(kwmap[keyword]) == 0 {
+			kwmap[keyword] = []byte(fmt.Sprintf("%v", value))
+		}
+	}
+
 	// Convert from Markdown to HTML
 	htmlbody := blackfriday.Run(data)
 
@@ -236,11 +291,7 @@ func (ac *Config) MarkdownPage(w http.ResponseWriter, req *http.Request, data []
 	}
 
 	// Checkboxes
-	htmlbody = bytes.Replace(htmlbody, []byte("<li>[ ] "), []byte("<li><input type=\"checkbox\" disabled> "), utils.EveryInstance)
-	htmlbody = bytes.Replace(htmlbody, []byte("<li><p>[ ] "), []byte("<li><p><input type=\"checkbox\" disabled> "), utils.EveryInstance)
-	htmlbody = bytes.Replace(htmlbody, []byte("<li>[x] "), []byte("<li><input type=\"checkbox\" disabled checked> "), utils.EveryInstance)
-	htmlbody = bytes.Replace(htmlbody, []byte("<li>[X] "), []byte("<li><input type=\"checkbox\" disabled checked> "), utils.EveryInstance)
-	htmlbody = bytes.Replace(htmlbody, []byte("<li><p>[x] "), []byte("<li><p><input type=\"checkbox\" disabled checked> "), utils.EveryInstance)
+	htmlbody = markdownTaskLists(htmlbody)
 
 	// These should work by default, but does not.
 	// TODO: Look into how blackfriday handles this.
@@ -258,6 +309,12 @@ func (ac *Config) MarkdownPage(w http.ResponseWriter, req *http.Request, data []
 		}
 	}
 
+	// If a layout is given in the front matter, render the HTML with that
+	if layout, ok := meta["layout"].(string); ok && layout != "" {
+		ac.markdownLayout(w, req, filename, layout, meta, title, htmlbody)
+		return
+	}
+
 	// Find the theme that should be used
 	theme := kwmap["theme"]
 	if len(theme) == 0 {
@@ -415,9 +472,37 @@ func (ac *Config) MarkdownPage(w http.ResponseWriter, req *http.Request, data []
 	ac.DataToClient(w, req, filename, htmldata)
 }
 
+// markdownLayout renders the given HTML, converted from Markdown, with the
+// given Pongo2 layout, relative to the Markdown file. The front matter, the
+// title and the HTML, as "content", are available in the layout.
+func (ac *Config) markdownLayout(w http.ResponseWriter, req *http.Request, filename, layout string, meta map[string]interface{}, title, htmlbody []byte) {
+	layoutFilename := filepath.Join(filepath.Dir(filename), layout)
+	pongoMap := make(pongo2.Context)
+	pongoMap.Update(meta)
+	pongoMap["meta"] = meta
+	pongoMap["title"] = string(title)
+	pongoMap["content"] = pongo2.AsSafeValue(string(htmlbody))
+	result, err := ac.renderPongoFile(req, layoutFilename, pongoMap)
+	if err != nil {
+		if ac.debugMode {
+			fmt.Fprintf(w, "Could not render %s: %s", layoutFilename, err)
+		} else {
+			log.Errorf("Could not render %s: %s", layoutFilename, err)
+		}
+		return
+	}
+	htmldata := []byte(result)
+	if ac.autoRefresh {
+		htmldata = ac.InsertAutoRefresh(req, htmldata)
+	}
+	ac.DataToClient(w, req, filename, htmldata)
+}
+
 // PongoPage write the given source bytes (ina Pongo2) converted to HTML, to a writer.
 // The filename is only used in error messages, if any.
 func (ac *Config) PongoPage(w http.ResponseWriter, req *http.Request, filename string, pongodata []byte, funcs template.FuncMap) {
+	defer ac.renderStats.Record("pongo2", filename, time.Now())
+
 	var (
 		buf                   bytes.Buffer
 		linkInGCSS, linkInCSS bool
@@ -461,7 +546,13 @@ func (ac *Config) PongoPage(w http.ResponseWriter, req *http.Request, filename s
 		return
 	}
 
-	okfuncs := make(pongo2.Context)
+	// Start with the values and functions that are available in all templates
+	okfuncs := ac.templateContext(req)
+
+	// Make asset URLs with a content hash available, like {{ asset("/js/app.js") }}
+	okfuncs["asset"] = func(urlpath string) string {
+		return ac.AssetURL(urlpath, filepath.Dir(filename))
+	}
 
 	// Go through the global Lua scope
 	for k, v := range funcs {
@@ -588,6 +679,7 @@ func (ac *Config) PongoPage(w http.ResponseWriter, req *http.Request, filename s
 // AmberPage the given source bytes (in Amber) converted to HTML, to a writer.
 // The filename is only used in error messages, if any.
 func (ac *Config) AmberPage(w http.ResponseWriter, req *http.Request, filename string, amberdata []byte, funcs template.FuncMap) {
+	defer ac.renderStats.Record("amber", filename, time.Now())
 
 	var buf bytes.Buffer
 
@@ -693,12 +785,18 @@ func (ac *Config) GCSSPage(w http.ResponseWriter, req *http.Request, filename st
 		return
 	}
 	// Write the resulting CSS to the client
+	if ac.cssMinify {
+		ac.DataToClient(w, req, filename, MinifyCSS(buf.Bytes()))
+		return
+	}
 	ac.DataToClient(w, req, filename, buf.Bytes())
 }
 
 // JSXPage writes the given source bytes (in JSX) converted to JS, to a writer.
 // The filename is only used in the error message, if any.
 func (ac *Config) JSXPage(w http.ResponseWriter, req *http.Request, filename string, jsxdata []byte) {
+	defer ac.renderStats.Record("jsx", filename, time.Now())
+
 	var buf bytes.Buffer
 	buf.Write(jsxdata)
 
@@ -731,6 +829,8 @@ func (ac *Config) JSXPage(w http.ResponseWriter, req *http.Request, filename str
 // HyperAppPage writes the given source bytes (in JSX for HyperApp) converted to JS, to a writer.
 // The filename is only used in the error message, if any.
 func (ac *Config) HyperAppPage(w http.ResponseWriter, req *http.Request, filename string, jsxdata []byte) {
+	defer ac.renderStats.Record("hyperapp", filename, time.Now())
+
 	var (
 		htmlbuf strings.Builder
 		jsxbuf  bytes.Buffer
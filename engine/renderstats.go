@@ -0,0 +1,79 @@
+package engine
+
+// Statistics for how long it takes to render templates and pages, for finding slow templates
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The URL path for the render statistics, only available in debug mode
+const renderStatsPath = "/debug/render-stats"
+
+// RenderStat is the number of renders and the total duration, for one file
+type RenderStat struct {
+	Kind     string        `json:"kind"`
+	Filename string        `json:"filename"`
+	Count    uint64        `json:"count"`
+	Total    time.Duration `json:"-"`
+}
+
+// RenderStats collects render statistics, by kind and filename
+type RenderStats struct {
+	mut   sync.Mutex
+	stats map[string]*RenderStat
+}
+
+// NewRenderStats creates a new and empty RenderStats struct
+func NewRenderStats() *RenderStats {
+	return &RenderStats{stats: make(map[string]*RenderStat)}
+}
+
+// Record adds a render of the given kind (like "pongo2") and filename, that
+// started at the given time. Meant to be used with defer.
+func (rs *RenderStats) Record(kind, filename string, start time.Time) {
+	elapsed := time.Since(start)
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+	key := kind + ":" + filename
+	stat, ok := rs.stats[key]
+	if !ok {
+		stat = &RenderStat{Kind: kind, Filename: filename}
+		rs.stats[key] = stat
+	}
+	stat.Count++
+	stat.Total += elapsed
+}
+
+// renderStatJSON is a RenderStat, together with the average duration, for JSON output
+type renderStatJSON struct {
+	*RenderStat
+	AverageMS float64 `json:"average_ms"`
+	TotalMS   float64 `json:"total_ms"`
+}
+
+// ServeHTTP outputs the render statistics as JSON, with the slowest files first
+func (rs *RenderStats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rs.mut.Lock()
+	list := make([]renderStatJSON, 0, len(rs.stats))
+	for _, stat := range rs.stats {
+		statCopy := *stat
+		list = append(list, renderStatJSON{
+			RenderStat: &statCopy,
+			AverageMS:  float64(stat.Total) / float64(stat.Count) / float64(time.Millisecond),
+			TotalMS:    float64(stat.Total) / float64(time.Millisecond),
+		})
+	}
+	rs.mut.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].AverageMS > list[j].AverageMS
+	})
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(list)
+}
@@ -96,11 +96,33 @@ kv:get(string) -> string
 kv:inc(string) -> string
 // Remove a key. Returns true if successful.
 kv:del(string) -> bool
+// Set a key to a new value, if the current value matches the given old value.
+// Returns true if the value was swapped.
+kv:cas(string, string, string) -> bool
 // Remove the KeyValue itself. Returns true if successful.
 kv:remove() -> bool
 // Clear the KeyValue. Returns true if successful.
 kv:clear() -> bool
 
+// Get or create a database-backed job queue
+// (takes a name, returns a queue object)
+Queue(string) -> userdata
+// Add a job to the end of the queue.
+queue:push(string)
+// Remove and return the first job. Takes an optional number of seconds to wait.
+queue:pop([number]) -> string
+// Return the number of jobs in the queue.
+queue:len() -> number
+
+// Return the in-memory cache that is shared between all Lua states
+MemCache() -> userdata
+// Store a string, number, boolean or table. Takes an optional TTL in seconds.
+mc:set(string, value[, number]) -> bool
+// Retrieve a value, or nil if it is missing or has expired.
+mc:get(string) -> value
+// Remove a value.
+mc:del(string)
+
 Live server configuration
 
 // Reset the URL prefixes and make everything *public*.
@@ -114,6 +136,12 @@ DenyHandler(function)
 // Direct the logging to the given filename. If the filename is an empty
 // string, direct logging to stderr. Returns true if successful.
 LogTo(string) -> bool
+// Set the signal for reopening the log file, like "USR1" (the default).
+SetLogReopenSignal(string) -> bool
+// Write an access log in "combined" (the default) or "common" format.
+SetAccessLog(string[, string]) -> bool
+// Only log the given share (0 to 1) of the 2xx responses in the access log.
+SetLogSampling(number)
 
 Output
 
@@ -123,6 +151,8 @@ log(...)
 warn(...)
 // Log the given strings as an error. Takes a variable number of strings.
 err(...)
+// Add key/value fields to the log lines from log, warn and err. No arguments clears them.
+logfields([table])
 // Output text. Takes a variable number of strings.
 print(...)
 // Output rendered HTML given Markdown. Takes a variable number of strings.
@@ -136,7 +166,10 @@ hprint(...)
 // Output rendered JavaScript given JSX for React. Takes a variable number of strings.
 jprint(...)
 // Output a Pongo2 template and key/value table as rendered HTML. Use "{{ key }}" to insert a key.
+// Values are HTML escaped, unless they are marked with raw().
 poprint(string[, table])
+// Mark a string as trusted HTML that should not be escaped in Pongo2 templates.
+raw(string) -> userdata
 // Output a simple HTML page with a message, title and theme.
 msgpage(string[, string][, string])
 
@@ -163,6 +196,22 @@ jfile:set(string, string) -> bool
 jfile:add([string, ]string) -> bool
 // Removes a key in a map in a JSON document. Returns true if successful.
 jfile:delkey(string) -> bool
+// Call a function with the index and a JNode, for each element in a JSON array.
+jfile:each(string, function)
+// Return the number of elements in a JSON array, or 0.
+jfile:count(string) -> number
+// Open a file with one JSON value per line, for reading it line by line.
+JLinesReader(filename) -> userdata
+// Decode the next line. Returns nil when done, or nil and an error message.
+reader:next() -> table
+// Close the file.
+reader:close()
+// Read a CSV file as a table of rows. Options: delimiter, comment, lazy_quotes
+// and header. Returns nil and an error message if the CSV data is malformed.
+csv_read(filename[, table]) -> table
+// Write a table of rows to a CSV file. Options: delimiter, quote_all, header
+// and columns. Returns false and an error message on failure.
+csv_write(filename, table[, table]) -> bool
 // Convert a Lua table with strings or ints to JSON.
 // Takes an optional number of spaces to indent the JSON data.
 json(table[, number]) -> string
@@ -176,6 +225,14 @@ jnode:add([string, ]string) ->
 jnode:get(string) -> userdata
 // Given a JSON path, retrieves a JSON string.
 jnode:getstring(string) -> string
+// Given a JSON path, retrieves a number, or nil and an error message.
+jnode:getnumber(string) -> number
+// Given a JSON path, retrieves a bool, or nil and an error message.
+jnode:getbool(string) -> bool
+// Given a JSON path, retrieves a JSON array as a table, or nil and an error message.
+jnode:getarray(string) -> table
+// Given a table of JSON paths, return a JSON document with only those values.
+jnode:pick(table) -> string
 // Given a JSON path and a JSON string, set the value.
 jnode:set(string, string)
 // Given a JSON path, remove a key from a map.
@@ -216,6 +273,14 @@ hc:SetTimeout(number)
 hc:SetCookie(string, string)
 // Set the user agent (ie. "curl")
 hc:SetUserAgent(string)
+// Retry on connection errors and 5xx responses, given the maximum number of
+// retries and the delay before the first retry (in milliseconds). POST and
+// PATCH requests are only retried on connection errors.
+hc:SetRetry(number[, number])
+// Fail fast while the circuit breaker with the given name is open.
+hc:SetCircuitBreaker(string)
+// Resolve host names with DNS over HTTPS ("doh" and an URL), or "system".
+hc:SetResolver(string[, string])
 // Perform a HTTP GET request. First comes the URL, then an optional table with
 // URL paramets, then an optional table with HTTP headers.
 hc:Get(string, [table], [table]) -> string
@@ -230,6 +295,36 @@ GET(string, [table], [table]) -> string
 POST(string, [table], [table], [string]) -> string
 // Shorthand for HTTPClient():Do()
 DO(string, string, [table], [table]) -> string
+// Return a GraphQL client for the given endpoint, with an optional HTTPClient.
+GraphQL(string[, userdata]) -> userdata
+// Send a GraphQL query, with optional variables and HTTP headers. Returns the
+// data, and an error string and the errors as a table if there were errors.
+gql:query(string[, table][, table]) -> table, string, table
+// Return a circuit breaker that is shared by all scripts. Takes a name and an
+// optional table with "threshold" and "cooldown" (in seconds).
+CircuitBreaker(string[, table]) -> userdata
+// Check if a request can be made.
+cb:allow() -> bool
+// Record a successful request.
+cb:success()
+// Record a failed request.
+cb:failure()
+// Return "closed", "open" or "half-open".
+cb:state() -> string
+
+S3 compatible object storage
+
+// Create a client for a bucket. Takes an endpoint, an access key, a secret key,
+// a bucket name and an optional table with "region" and "path_style".
+S3(string, string, string, string[, table]) -> userdata
+// Store an object, given a key and a string or an UploadedFile.
+s3:put(string, string|userdata[, string]) -> bool
+// Get an object. Returns nil and an error string on failure.
+s3:get(string) -> string
+// Delete an object.
+s3:delete(string) -> bool
+// Return an URL for getting the object that is valid for the given seconds.
+s3:presign(string, number) -> string
 
 Plugins
 
@@ -267,14 +362,29 @@ Various
 ServerInfo() -> string
 // Return the version string for the server
 version() -> string
+// Return the startup banner, or an empty string if it has been disabled
+ServerBanner() -> string
 // Tries to extract and print the contents of the given Lua values
 pprint(...)
 // Sleep the given number of seconds (can be a float)
 sleep(number)
+// Call a function with the given arguments. Returns true, or false and the error.
+try(function, ...) -> bool, string
+// Return the "in_use", "idle", "created" and "reused" counts for the Lua state pool
+LuaPoolStats() -> table
+// Return "max", "queue_depth", "in_flight", "queued" and "rejected" for the
+// limit on concurrent requests
+RequestStats() -> table
 // Return the number of nanoseconds from 1970 ("Unix time")
 unixnano() -> number
-// Convert Markdown to HTML
-markdown(string) -> string
+// Convert Markdown to HTML. The last argument may be a table with options:
+// tables, tasklists, autolinks, footnotes and hardbreaks (true or false).
+markdown(...) -> string
+// Separate the front matter from Markdown. Returns a table and the Markdown.
+markdown_meta(string) -> table, string
+// Return the value of an environment variable that is allowed with AllowEnv,
+// or the given default value.
+env(string[, string]) -> string
 // Query a PostgreSQL database with a query and a connection string
 // Default connection string: "host=localhost port=5432 user=postgres dbname=test sslmode=disable"
 PQ([string], [string]) -> table
@@ -282,14 +392,76 @@ PQ([string], [string]) -> table
 Extra
 
 // Takes a Python filename, executes the script with the "python" binary in the Path.
+// Takes an optional table of arguments and an optional interpreter, like "python3".
 // Returns the output as a Lua table, where each line is an entry.
-py(string) -> table
+py(string[, table[, string]]) -> table
 // Takes one or more system commands (possibly separated by ";") and runs them.
 // Returns the output lines as a table.
 run(string) -> table
+// Like run, but also returns the stderr lines and the exit code.
+run2(string) -> table, table, number
+// Takes a program, a table of arguments and an optional timeout in seconds.
+// Runs the program without a shell. Returns the output lines and the exit code.
+exec(string, table[, number]) -> table, number
 // Lists the keys and values of a Lua table. Returns a string.
 // Lists the contents of the global namespace "_G" if no arguments are given.
 dir([table]) -> string
+// Return a random UUID (version 4).
+uuid() -> string
+// Return a time-ordered UUID (version 7).
+uuidv7() -> string
+// Return a random URL-friendly ID. Takes an optional length (the default is 21).
+nanoid([number]) -> string
+// Takes a key and a message. Returns the HMAC-SHA256 as a hex encoded string.
+hmac_sha256(string, string) -> string
+// Takes an algorithm ("sha256", "sha512", "sha1" or "md5") and data.
+// Returns the hex encoded hash and an error string. Not for passwords.
+hash(string, string) -> string, string
+// Return the given number of random bytes, as a raw string.
+random_bytes(number) -> string
+// Return the given number of random bytes, as a hex encoded string.
+random_hex(number) -> string
+// Encode a string with base64.
+base64encode(string) -> string
+// Decode a base64 encoded string. Returns the decoded string and an error string.
+base64decode(string) -> string, string
+// Encode a string with URL-safe base64, without padding.
+base64urlencode(string) -> string
+// Decode a string with URL-safe base64. Returns the decoded string and an error string.
+base64urldecode(string) -> string, string
+// Return the current time as the number of seconds since 1970.
+now() -> number
+// Format a number of seconds since 1970 with an optional Go time layout and time zone.
+// Returns the formatted time and an error string.
+formattime(number[, string[, string]]) -> string, string
+// Parse a time with an optional Go time layout and time zone.
+// Returns the number of seconds since 1970 and an error string.
+parsetime(string[, string[, string]]) -> number, string
+// Check if a string matches a regular expression. Returns a bool and an error string.
+regex_match(string, string) -> bool, string
+// Return the first match and the captured groups, or nil. Also returns an error string.
+regex_find(string, string) -> table, string
+// Replace all matches with a replacement that may use $1, $2 etc.
+// Returns the result and an error string.
+regex_replace(string, string, string) -> string, string
+// Escape a string for use in a URL query.
+urlencode(string) -> string
+// Unescape a string from a URL query. Returns the decoded string and an error string.
+urldecode(string) -> string, string
+// Decode YAML to a table. Returns nil and an error message on failure.
+yaml_decode(string) -> table
+// Encode a table as YAML. Returns nil and an error message on failure.
+yaml_encode(table) -> string
+// Decode TOML to a table. Returns nil and an error message on failure.
+toml_decode(string) -> table
+// Encode a table as TOML. Returns nil and an error message on failure.
+toml_encode(table) -> string
+// Decode XML to a table with name, attr, children and text for the root element.
+// Returns nil and an error message on failure.
+xml_decode(string) -> table
+// Encode a table with name, attr, children and text as XML.
+// Returns nil and an error message on failure.
+xml_encode(table) -> string
 `
 	usageMessage = `
 Type "webhelp" for an overview of functions that are available when
@@ -402,31 +574,113 @@ SetMinimumConfirmationCodeLength(number)
 // Generates a unique confirmation code, or an empty string
 GenerateUniqueConfirmationCode() -> string
 
+Sessions
+
+// Return the session for the current visitor, which is stored in the database.
+Session() -> userdata
+// Return the value for the given key, or nil.
+sess:get(string) -> value
+// Set the value for the given key. Returns true, or false and an error string.
+sess:set(string, value) -> bool
+// Remove the session and all the values in it.
+sess:destroy()
+
+Feature flags
+
+// Check if the given feature flag is on for everyone.
+Flag(string) -> bool
+// Check if the given feature flag is on for the given username.
+FlagForUser(string, string) -> bool
+// Turn a feature flag on or off, or on for a percentage of the users.
+SetFlag(string, bool|number) -> bool
+
 File uploads
 
 // Creates a file upload object. Takes a form ID (from a POST request) as the
 // first parameter. Takes an optional maximum upload size (in MiB) as the
 // second parameter. Returns nil and an error string on failure, or userdata
-// and an empty string on success.
+// and an empty string on success. The error string starts with "too large"
+// or "no such form field" for those two cases.
 UploadedFile(string[, number]) -> userdata, string
+// List the uploaded files in the form, as tables with field, filename, size and
+// mimetype. Takes an optional maximum upload size (in MiB).
+formfiles([number]) -> table, string
+// Retrieve a completed resumable upload as a file upload object.
+// Takes an upload ID. Returns userdata and an empty string on success.
+// Only the user or session that created the upload can retrieve it.
+ResumableUpload(string) -> userdata, string
 // Return the uploaded filename, as specified by the client
 uploadedfile:filename() -> string
 // Return the size of the data that has been received
 uploadedfile:size() -> number
 // Return the mime type of the uploaded file, as specified by the client
 uploadedfile:mimetype() -> string
+// Return the mime type of the uploaded file, as detected from the data
+uploadedfile:detectedtype() -> string
+// Return a filename extension (like ".png") for the detected mime type
+uploadedfile:extension() -> string
+// Return the SHA-256 checksum of the uploaded data, as a hex string
+uploadedfile:sha256() -> string
+// Return the MD5 checksum of the uploaded data, as a hex string
+uploadedfile:md5() -> string
 // Save the uploaded data locally. Takes an optional filename.
 uploadedfile:save([string]) -> bool
 // Save the uploaded data as the client-provided filename, in the specified
 // directory. Takes a relative or absolute path. Returns true on success.
 uploadedfile:savein(string)  -> bool
 
+WebSockets
+
+// Upgrade the current request to a WebSocket connection.
+// Returns nil and an error message on failure.
+WebSocket() -> userdata
+// Send a text message. Returns true on success.
+websocket:send(string) -> bool
+// Wait for the next message. Returns nil when the connection is closed.
+websocket:receive() -> string
+// Close the connection.
+websocket:close()
+// Get or create a hub. Takes a name and an optional permission level
+// ("user" or "admin") that is needed for joining. Returns nil and an
+// error string if the hub exists with a different level.
+Hub(string[, string]) -> userdata
+// Join the hub with the WebSocket connection of the current request.
+// Returns true on success, or false and an error message.
+hub:join() -> bool
+// Leave the hub.
+hub:leave()
+// Send a message to all joined connections. Returns the number of receivers.
+hub:broadcast(string) -> number
+// Return the number of joined connections.
+hub:count() -> number
+
 Handling requests
 
 // Set the Content-Type for a page.
 content(string)
 // Return the requested HTTP method (GET, POST etc).
 method() -> string
+// Return the path parameter with the given name, as captured by a route.
+param(string) -> string
+// Return the IP address of the client.
+clientip() -> string
+// Return the URL path with a hash of the file contents, for cache busting.
+asset(string) -> string
+// Return the translated message for the given key. Takes an optional table of
+// arguments and an optional locale. The locale is selected from Accept-Language.
+t(string[, table][, string]) -> string
+// Return a table with information about the verified client certificate, or nil.
+clientcert() -> table
+// Return a table with the subject, issuer and expiry of the served TLS certificate.
+TLSCertInfo() -> table
+// Do not log the current request in the access log, unless the response is not 2xx.
+nolog()
+// Always log the current request in the access log, regardless of the sampling.
+forcelog()
+// Check if a proxy has marked the request as early data (0-RTT), which can be replayed.
+isearlydata() -> bool
+// Return the request context, with the methods done(), err() and remaining().
+reqcontext() -> userdata
 // Output text to the browser/client. Takes a variable number of strings.
 print(...)
 // Return the requested URL path.
@@ -435,11 +689,32 @@ urlpath() -> string
 header(string) -> string
 // Set an HTTP header given a key and a value.
 setheader(string, string)
+// Set several HTTP headers, given a table. A value can be a table of strings.
+setheaders(table)
+// Remove an HTTP header from the response.
+delheader(string)
+// Return the HTTP headers that are set in the response, as a table.
+responseheaders() -> table
 // Return the HTTP headers, as a table.
 headers() -> table
+// Return the value of a cookie, or an empty string.
+// If the second argument is true, the signature is checked.
+getcookie(string[, bool]) -> string
+// Set a cookie, given a name, a value and an optional table with options
+// (maxage, path, domain, secure, httponly, samesite and signed).
+setcookie(string, string[, table]) -> bool, string
+// Remove a cookie, given a name and an optional table with path and domain.
+delcookie(string[, table])
 // Return the HTTP body in the request
 // (will only read the body once, since it's streamed).
-body() -> string
+// Also returns an error string, like "too large".
+body() -> string, string
+// Return a reader for reading the HTTP body in chunks.
+bodyreader() -> userdata
+// Read up to the given number of bytes (default 32 KiB). Returns nil when done.
+reader:read([number]) -> string
+// Close the body.
+reader:close()
 // Set a HTTP status code (like 200 or 404).
 // Must be used before other functions that writes to the client!
 status(number)
@@ -453,6 +728,11 @@ scriptdir([string]) -> string
 // is given, then the path to where the server is running, joined with a path
 // separator and the given filename, is returned.
 serverdir([string]) -> string
+// Build an URL-encoded query string from a table.
+// A table of values gives a repeated key.
+buildquery(table) -> string
+// Parse a query string to a table. Repeated keys have a table of values.
+parsequery(string) -> table, string
 // Serve a file that exists in the same directory as the script.
 serve(string)
 // Serve a Pongo2 template file, with an optional table with key/values.
@@ -460,15 +740,33 @@ serve2(string[, table)
 // Return the rendered contents of a file that exists in the same directory
 // as the script. Takes a filename.
 render(string) -> string
+// Render a Pongo2 template and then a Pongo2 layout, where the rendered template
+// is available as {{ content }}. Takes an optional table with key/values.
+renderwith(string, string[, table]) -> string
+// Render a Pongo2 template with an optional table with key/values, and return the result.
+include(string[, table]) -> string
 // Return a table with keys and values as given in a posted form, or as given
 // in the URL ("/some/page?x=7" makes "x" with the value "7" available).
-formdata() -> table
+// Also returns an error string, like "too large".
+formdata() -> table, string
+// Decode a JSON request body to a table. Returns nil and an error string on failure.
+jsonbody() -> table, string
 // Redirect to an absolute or relative URL. Also takes a HTTP status code.
 redirect(string[, number])
 // Permanently redirect to an absolute or relative URL. Uses status code 302.
 permanent_redirect(string)
 // Transmit what has been outputted so far, to the client.
 flush()
+// Start collecting the output instead of sending it. Can be nested.
+buffer_start()
+// Stop the current buffer and send the collected output.
+buffer_flush()
+// Return the output that has been collected by the current buffer.
+buffer_get() -> string
+// Stop the current buffer and return the collected output, without sending it.
+buffer_end() -> string
+// Check if the status code, headers or output have been sent.
+responded() -> bool
 `
 	configHelpText = `Available functions:
 
@@ -487,17 +785,157 @@ DenyHandler(function)
 // Provide a lua function that will be run once,
 // when the server is ready to start serving.
 OnReady(function)
+// Provide a Lua function that is called with a table, when a Lua handler fails.
+OnError(function)
+// Enable or disable Lua stack tracebacks in error messages. Enabled by default.
+SetDebugTraceback(bool)
+// Allow the given environment variables to be read with env().
+AllowEnv(table)
+// Run a Lua function at the given interval, like "10m" or a number of seconds.
+Every(string or number, function) -> bool
+// Run a Lua function according to a cron expression, like "0 3 * * *".
+Cron(string, function) -> bool
+// Process jobs from a queue with a number of workers and a Lua function.
+// Takes an optional number of retries for failed jobs.
+Worker(string, number, function[, number]) -> bool
+// Call a Lua function with each message that is published on the given channel.
+Subscribe(string, function) -> bool
+// Publish a message on the given channel.
+Publish(string, string) -> bool
+// Acquire a named lock for a number of seconds. Returns false if already held,
+// or false and an error string if the database backend is not Redis or Bolt.
+Lock(string, number) -> bool, string
+// Release a named lock, if it is held by this server.
+Unlock(string)
 // Use a Lua file for setting up HTTP handlers instead of using the directory structure.
 ServerFile(string) -> bool
 // Get the cookie secret from the server configuration.
 CookieSecret() -> string
 // Set the cookie secret that will be used when setting and getting browser cookies.
 SetCookieSecret(string)
+// Configure response compression with a table of "encodings", "minsize"
+// and "types", or enable/disable compression with a bool.
+SetCompression(table or bool)
+// Set the IP addresses and CIDR ranges of trusted proxies.
+SetTrustedProxies(table) -> bool
+// Select how ETags are generated: "strong", "weak" or "off".
+SetETag(string) -> bool
+// Enable or disable directory listings. Takes an optional bool for hidden files.
+SetDirListing(bool[, bool])
+// Use a Pongo2 template for directory listings. Returns true if found.
+SetDirListingTemplate(string) -> bool
+// Set the read, write and idle (HTTP and QUIC) timeouts, in seconds.
+SetReadTimeout(number)
+SetWriteTimeout(number)
+SetIdleTimeout(number)
+// Set the maximum number of concurrent streams per QUIC connection.
+SetQUICMaxStreams(number)
+// Set the QUIC flow control window for a stream, in bytes.
+SetQUICReceiveWindow(number)
+// Select the QUIC congestion control algorithm. Only "cubic" is supported.
+SetQUICCongestion(string) -> bool
+// Add security headers to all responses. Takes a table with "hsts",
+// "nosniff", "frameoptions", "referrerpolicy" and "csp".
+SetSecurityHeaders(table)
+// Require client certificates signed by the CA in the given PEM file (not with QUIC).
+RequireClientCert(string) -> bool
+// Obtain HTTPS certificates for a table of domains from Let's Encrypt.
+// Takes an e-mail address and an optional certificate directory.
+AutoTLS(table, string[, string])
+// Advertise HTTP/3 with the Alt-Svc header. Takes an optional max age.
+SetAltSvc(bool[, number])
+// Allow requests that a proxy has marked as early data (0-RTT) with the
+// "Early-Data: 1" header. Disabled by default.
+SetProxyEarlyData(bool)
+// Minify CSS that is generated from GCSS and served .css files.
+SetCSSMinify(bool)
+// Set the maximum size of request bodies, in MiB. Takes an optional URL path prefix.
+SetMaxBodySize(number[, string])
+// Set the maximum number of simultaneous TCP and QUIC connections per client IP.
+// 0 is no limit.
+SetMaxConnsPerIP(number)
+// Set the maximum number of simultaneous requests per client IP. 0 is no limit.
+SetMaxRequestsPerIP(number)
+// Set the maximum number of requests that are handled at the same time,
+// and an optional queue depth. 0 is no limit.
+SetMaxConcurrentRequests(number[, number])
+// Set the maximum time for handling a request, in seconds.
+// Takes an optional URL path prefix. 0 is no timeout.
+SetHandlerTimeout(number[, string])
+// Make a value available in all Pongo2 templates.
+SetTemplateGlobal(string, value)
+// Make a Lua function available in all Pongo2 templates.
+RegisterTemplateFunction(string, function)
+// Load message files, like "en.json" or "nb.yaml", from the given directory.
+// Takes an optional default locale. Returns true on success.
+LoadTranslations(string[, string]) -> bool
+// Set the filenames that are served instead of a directory listing, in order.
+SetIndexFiles(table)
+// Serve requests that match a pattern like "/user/:id" with the given file.
+Route(string, string) -> bool
+// Handle trailing slashes with "ignore" (the default), "redirect" or "strict".
+SetTrailingSlash(string) -> bool
+// Ignore the case of URL paths, and redirect to the lowercase form.
+SetCaseInsensitivePaths(bool)
+// Like Route, but only for the given HTTP method.
+OnGet(string, string) -> bool
+OnPost(string, string) -> bool
+OnPut(string, string) -> bool
+OnPatch(string, string) -> bool
+OnDelete(string, string) -> bool
+// Serve files from a registered embedded filesystem at the given URL path prefix.
+ServeEmbedded(string[, string]) -> bool
+// Serve the given file for GET requests that do not match a file or a directory.
+// Takes an optional URL path prefix and an optional table of prefixes to exclude.
+SetSPAFallback(string[, string[, table]]) -> bool
+// Use a Pongo2 template as the error page for a status code or "default".
+SetErrorPage(number or string, string) -> bool
+// Forward requests with the given URL path prefix to the given backend URL.
+ReverseProxy(string, string) -> bool
+// Enable resumable uploads at the given URL path. Takes an optional directory.
+EnableResumableUploads(string[, string])
+// Serve the given directory over WebDAV at the given URL path prefix,
+// for logged in users, or only for administrators if "admin" is given.
+EnableWebDAV(string, string[, string]) -> bool
+// Require HTTP Basic Authentication for a prefix. Takes a username and a bcrypt
+// hash, or a table with usernames and hashes.
+BasicAuth(string, string or table[, string]) -> bool
+// Use ANSI graphics from a file as the startup banner. "" disables the banner.
+SetBanner(string) -> bool
+// Set the minimum and maximum number of idle Lua states in the pool.
+SetLuaPoolSize(number, number)
+// Register liveness and readiness endpoints, like "/healthz" and "/readyz".
+SetHealthCheck(string[, string])
+// Set how long a session lasts after it was last changed, in seconds.
+SetSessionTTL(number)
+// Serve a sitemap of the public pages at the given URL path or "/sitemap.xml",
+// with an optional base URL, like "https://example.com".
+EnableSitemap([string[, string]])
+// Set the sitemap priority for the URL paths that match a glob pattern or prefix.
+SetSitemapPriority(string, number)
+// Serve /robots.txt, given as a string or as a table with user_agent, allow,
+// disallow and sitemap fields.
+SetRobots(string|table)
+// Send a span for each request to an OpenTelemetry collector (OTLP/HTTP).
+// Takes an endpoint, like "http://localhost:4318", and an optional service name.
+EnableTracing(string[, string]) -> bool
+// Serve the pprof profiling data, for admins, at the given prefix or "/debug/pprof".
+EnablePprof([string])
 
 `
 	exitMessage = "goodbye"
 )
 
+// outputLuaError outputs the error message, and the Lua stack traceback
+// if tracebacks are enabled with SetDebugTraceback
+func (ac *Config) outputLuaError(o *textoutput.TextOutput, err error) {
+	message, traceback := luaErrorParts(err)
+	o.Err(message)
+	if ac.debugTraceback && traceback != "" {
+		o.Println(o.DarkGray(traceback))
+	}
+}
+
 // Export Lua functions specific to the REPL
 func exportREPLSpecific(L *lua.LState) {
 
@@ -590,7 +1028,7 @@ func outputHelp(o *textoutput.TextOutput, helpText string) {
 	for _, line := range strings.Split(helpText, "\n") {
 		o.Println(highlight(o, line))
 	}
-	o.Println(usageMessage)
+	o.Println(strings.TrimSuffix(usageMessage, "\n"))
 }
 
 // Output syntax highlighted help about a specific topic or function
@@ -660,7 +1098,8 @@ func (ac *Config) LoadLuaFunctionsForREPL(L *lua.LState, o *textoutput.TextOutpu
 		datastruct.LoadList(L, creator)
 		datastruct.LoadSet(L, creator)
 		datastruct.LoadHash(L, creator)
-		datastruct.LoadKeyValue(L, creator)
+		datastruct.LoadKeyValue(L, ac.atomicCreator(creator))
+		datastruct.LoadQueue(L, ac.atomicCreator(creator))
 
 		// For saving and loading Lua functions
 		codelib.Load(L, creator)
@@ -669,6 +1108,8 @@ func (ac *Config) LoadLuaFunctionsForREPL(L *lua.LState, o *textoutput.TextOutpu
 	// For handling JSON data
 	jnode.LoadJSONFunctions(L)
 	ac.LoadJFile(L, ac.serverDirOrFilename)
+	ac.LoadJLinesReader(L, ac.serverDirOrFilename, nil)
+	ac.LoadCSV(L, ac.serverDirOrFilename)
 	jnode.Load(L)
 
 	// Extras
@@ -842,7 +1283,7 @@ func (ac *Config) REPL(ready, done chan bool) error {
 		if strings.HasPrefix(line, "print(") {
 			if err = L.DoString(line); err != nil {
 				// Output the error message
-				o.Err(err.Error())
+				ac.outputLuaError(o, err)
 			}
 		} else {
 			// Wrap the line in "pprint"
@@ -851,12 +1292,12 @@ func (ac *Config) REPL(ready, done chan bool) error {
 				if strings.Contains(err.Error(), "syntax error") {
 					if err = L.DoString(line); err != nil {
 						// Output the error message
-						o.Err(err.Error())
+						ac.outputLuaError(o, err)
 					}
 					// For other kinds of errors, output the error
 				} else {
 					// Output the error message
-					o.Err(err.Error())
+					ac.outputLuaError(o, err)
 				}
 			}
 		}
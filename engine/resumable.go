@@ -0,0 +1,347 @@
+package engine
+
+// Resumable uploads, using a subset of the tus protocol (https://tus.io/).
+//
+// POST to the upload path with an Upload-Length header creates a new upload.
+// The Location header in the response points to the new upload.
+// HEAD on the upload URL returns the current Upload-Offset.
+// PATCH on the upload URL, with a matching Upload-Offset header, appends data.
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/upload"
+	"github.com/xyproto/algernon/utils"
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/pinterface"
+)
+
+const (
+	// The version of the tus protocol that is supported
+	tusVersion = "1.0.0"
+
+	// The name of the KeyValue that keeps track of resumable uploads
+	resumableKeyValueName = "resumable_uploads"
+
+	// Maximum size of a resumable upload, in bytes
+	resumableUploadLimit int64 = 1024 * utils.MiB
+
+	// The session key that is set for visitors that are not logged in, so
+	// that the uploads they create can be tied to their session
+	resumableSessionKey = "resumable_uploads"
+)
+
+// The uploads that are currently being appended to, so that only one PATCH
+// request at the time writes to each upload, and the offsets stay consistent
+var (
+	resumableBusy      = make(map[string]bool)
+	resumableBusyMutex sync.Mutex
+)
+
+// lockResumableUpload marks the given upload as being appended to.
+// Returns false if another request is already appending to it.
+func lockResumableUpload(id string) bool {
+	resumableBusyMutex.Lock()
+	defer resumableBusyMutex.Unlock()
+	if resumableBusy[id] {
+		return false
+	}
+	resumableBusy[id] = true
+	return true
+}
+
+// unlockResumableUpload lets other requests append to the given upload
+func unlockResumableUpload(id string) {
+	resumableBusyMutex.Lock()
+	delete(resumableBusy, id)
+	resumableBusyMutex.Unlock()
+}
+
+// newUploadID returns a random hex encoded string that can be used as an upload ID
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validUploadID checks that the given upload ID only consists of hex digits
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// parseUploadMetadata parses the Upload-Metadata header, which is a comma
+// separated list of keys and base64 encoded values
+func parseUploadMetadata(header string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		m[fields[0]] = value
+	}
+	return m
+}
+
+// resumableUploadFilename returns the filename that is used for storing the given upload
+func (ac *Config) resumableUploadFilename(id string) string {
+	return filepath.Join(ac.resumableUploadDir, id+".part")
+}
+
+// resumableUploadOwner returns who the given request is from, which is the
+// logged in user, or else the session of the visitor. If create is true, a
+// session is created for visitors that do not have one. Returns an empty
+// string if there is no user and no session.
+func (ac *Config) resumableUploadOwner(w http.ResponseWriter, req *http.Request, create bool) (string, error) {
+	userstate := ac.perm.UserState()
+	if userstate.UserRights(req) {
+		return "user:" + userstate.Username(req), nil
+	}
+	sess, err := NewSession(w, req, userstate.Creator(), userstate.CookieSecret(), ac.sessionTTL)
+	if err != nil {
+		return "", err
+	}
+	if sess.id == "" {
+		if !create {
+			return "", nil
+		}
+		if err := sess.Set(resumableSessionKey, "true"); err != nil {
+			return "", err
+		}
+	}
+	return "session:" + sess.id, nil
+}
+
+// ownsResumableUpload checks if the given upload was created by the user or
+// session that the request is from
+func (ac *Config) ownsResumableUpload(w http.ResponseWriter, req *http.Request, kv pinterface.IKeyValue, id string) bool {
+	owner, err := ac.resumableUploadOwner(w, req, false)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	uploadOwner, err := kv.Get(id + ":owner")
+	return err == nil && owner != "" && uploadOwner == owner
+}
+
+// RegisterResumableUploads registers a handler for resumable uploads at the
+// given URL path. The state of each upload is kept in a KeyValue.
+func (ac *Config) RegisterResumableUploads(mux *http.ServeMux, uploadPath string) error {
+	kv, err := ac.perm.UserState().Creator().NewKeyValue(resumableKeyValueName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ac.resumableUploadDir, 0700); err != nil {
+		return err
+	}
+
+	uploadPath = "/" + strings.Trim(uploadPath, "/")
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		// Respect the permission prefixes
+		if ac.perm.Rejected(w, req) {
+			ac.perm.DenyFunction()(w, req)
+			return
+		}
+
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		id := strings.Trim(strings.TrimPrefix(req.URL.Path, uploadPath), "/")
+
+		switch {
+		case req.Method == http.MethodOptions:
+			w.Header().Set("Tus-Version", tusVersion)
+			w.Header().Set("Tus-Extension", "creation")
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(resumableUploadLimit, 10))
+			w.WriteHeader(http.StatusNoContent)
+		case req.Method == http.MethodPost && id == "":
+			ac.createResumableUpload(w, req, kv, uploadPath)
+		case !validUploadID(id) || !ac.ownsResumableUpload(w, req, kv, id):
+			// Uploads that belong to other users look like they do not exist
+			http.NotFound(w, req)
+		case req.Method == http.MethodHead:
+			length, err := kv.Get(id + ":length")
+			if err != nil || length == "" {
+				http.NotFound(w, req)
+				return
+			}
+			offset, _ := kv.Get(id + ":offset")
+			w.Header().Set("Upload-Offset", offset)
+			w.Header().Set("Upload-Length", length)
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+		case req.Method == http.MethodPatch:
+			ac.patchResumableUpload(w, req, kv, id)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+
+	mux.HandleFunc(uploadPath, handler)
+	mux.HandleFunc(uploadPath+"/", handler)
+	return nil
+}
+
+// createResumableUpload handles a POST request for creating a new upload
+func (ac *Config) createResumableUpload(w http.ResponseWriter, req *http.Request, kv pinterface.IKeyValue, uploadPath string) {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length is missing or invalid", http.StatusBadRequest)
+		return
+	}
+	if length > resumableUploadLimit {
+		http.Error(w, "Upload-Length is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	owner, err := ac.resumableUploadOwner(w, req, true)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	id, err := newUploadID()
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f, err := os.OpenFile(ac.resumableUploadFilename(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	metadata := parseUploadMetadata(req.Header.Get("Upload-Metadata"))
+	kv.Set(id+":owner", owner)
+	kv.Set(id+":length", strconv.FormatInt(length, 10))
+	kv.Set(id+":offset", "0")
+	clientFilename := id
+	if metadata["filename"] != "" {
+		clientFilename = filepath.Base(metadata["filename"])
+	}
+	kv.Set(id+":filename", clientFilename)
+	kv.Set(id+":mimetype", metadata["filetype"])
+	if length == 0 {
+		kv.Set(id+":complete", "true")
+	}
+
+	w.Header().Set("Location", path.Join(uploadPath, id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// patchResumableUpload handles a PATCH request for appending data to an upload
+func (ac *Config) patchResumableUpload(w http.ResponseWriter, req *http.Request, kv pinterface.IKeyValue, id string) {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Other uploads are not held up while the data is being received
+	if !lockResumableUpload(id) {
+		w.WriteHeader(http.StatusLocked)
+		return
+	}
+	defer unlockResumableUpload(id)
+
+	lengthString, err := kv.Get(id + ":length")
+	if err != nil || lengthString == "" {
+		http.NotFound(w, req)
+		return
+	}
+	length, _ := strconv.ParseInt(lengthString, 10, 64)
+	offsetString, _ := kv.Get(id + ":offset")
+	offset, _ := strconv.ParseInt(offsetString, 10, 64)
+
+	// The client must continue from where the previous request stopped
+	if req.Header.Get("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(ac.resumableUploadFilename(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+
+	// Never write more than what is left of the declared length.
+	// The data that has been written is kept, even if the connection breaks.
+	written, err := io.Copy(f, io.LimitReader(req.Body, length-offset))
+	offset += written
+	kv.Set(id+":offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		log.Warn("Resumable upload ", id, " was interrupted at offset ", offset)
+	}
+	if offset == length {
+		kv.Set(id+":complete", "true")
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LoadResumableUploadFunctions makes it possible to retrieve completed
+// resumable uploads as UploadedFile objects, from Lua
+func (ac *Config) LoadResumableUploadFunctions(L *lua.LState, w http.ResponseWriter, req *http.Request, scriptdir string, creator pinterface.ICreator) {
+
+	// Takes an upload ID. Returns an UploadedFile and an empty string on
+	// success, or nil and an error message on failure. Only the user or
+	// session that created the upload can retrieve it.
+	L.SetGlobal("ResumableUpload", L.NewFunction(func(L *lua.LState) int {
+		id := L.CheckString(1)
+		kv, err := creator.NewKeyValue(resumableKeyValueName)
+		if err != nil || !validUploadID(id) || !ac.ownsResumableUpload(w, req, kv, id) {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("no such upload: " + id))
+			return 2 // number of results
+		}
+		if complete, _ := kv.Get(id + ":complete"); complete != "true" {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("upload is not complete: " + id))
+			return 2 // number of results
+		}
+		clientFilename, _ := kv.Get(id + ":filename")
+		mimeType, _ := kv.Get(id + ":mimetype")
+		ulf, err := upload.FromFile(req, scriptdir, ac.resumableUploadFilename(id), clientFilename, mimeType)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		ud := L.NewUserData()
+		ud.Value = ulf
+		L.SetMetatable(ud, L.GetTypeMetatable(upload.Class))
+		L.Push(ud)
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+}
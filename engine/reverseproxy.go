@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/sheepcounter"
+)
+
+// ReverseProxyConfig is an URL path prefix and the backend that requests
+// with that prefix should be forwarded to
+type ReverseProxyConfig struct {
+	Prefix string
+	Target *url.URL
+}
+
+// NewReverseProxy creates a reverse proxy for the given backend URL.
+// The method, body and headers of the request are preserved, and the
+// X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers are set.
+// WebSocket connections are passed through.
+func (ac *Config) NewReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalHost := req.Host
+		director(req)
+		// X-Forwarded-For is set by httputil.ReverseProxy itself
+		req.Header.Set("X-Forwarded-Host", originalHost)
+		if req.TLS != nil {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			req.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		log.Errorf("Reverse proxy error for %s: %s", target, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
+
+// RegisterReverseProxy forwards requests where the URL path starts with the
+// given prefix to the given backend. The permission prefixes are respected.
+func (ac *Config) RegisterReverseProxy(mux *http.ServeMux, prefix string, target *url.URL) {
+	proxy := ac.NewReverseProxy(target)
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		// Rejecting requests is handled by the permission system
+		if ac.perm != nil && ac.perm.Rejected(w, req) {
+			sc := sheepcounter.New(w)
+			ac.perm.DenyFunction()(sc, req)
+			ac.LogAccess(req, http.StatusForbidden, sc.Counter())
+			return
+		}
+		// Prepare to record the status code and count bytes written
+		sw := NewStatusWriter(w)
+		sc := sheepcounter.New(sw)
+		proxy.ServeHTTP(sc, req)
+		ac.LogAccess(req, sw.Status(), sc.Counter())
+	}
+
+	mux.HandleFunc(prefix, handler)
+	if !strings.HasSuffix(prefix, "/") {
+		// Also handle everything below the given prefix
+		mux.HandleFunc(prefix+"/", handler)
+	}
+}
@@ -0,0 +1,125 @@
+package engine
+
+// Generating robots.txt, as configured with SetRobots
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+)
+
+// The URL path that robots.txt is served at
+const robotsPath = "/robots.txt"
+
+// RobotsGroup is a group of rules for the given user agents
+type RobotsGroup struct {
+	UserAgents []string
+	Allow      []string
+	Disallow   []string
+}
+
+// Robots is the configuration for robots.txt, as set with SetRobots
+type Robots struct {
+	// Raw is served as it is, if not empty
+	Raw      string
+	Groups   []RobotsGroup
+	Sitemaps []string
+}
+
+// tableStrings returns the string, or the strings in the table, for the given
+// field in the given table
+func tableStrings(table *lua.LTable, field string) []string {
+	switch v := table.RawGetString(field).(type) {
+	case lua.LString:
+		return []string{string(v)}
+	case *lua.LTable:
+		return convert.Table2strings(v)
+	}
+	return nil
+}
+
+// newRobotsGroup reads the "user_agent", "allow" and "disallow" fields from the
+// given table. The user agent is "*" if it is not given.
+func newRobotsGroup(table *lua.LTable) RobotsGroup {
+	group := RobotsGroup{
+		UserAgents: tableStrings(table, "user_agent"),
+		Allow:      tableStrings(table, "allow"),
+		Disallow:   tableStrings(table, "disallow"),
+	}
+	if len(group.UserAgents) == 0 {
+		group.UserAgents = []string{"*"}
+	}
+	return group
+}
+
+// Table2Robots converts a Lua table to a robots.txt configuration. The table
+// can have the fields of one group of rules, or a list of groups, and a
+// "sitemap" field with one or more URLs.
+func Table2Robots(table *lua.LTable) *Robots {
+	robots := &Robots{Sitemaps: tableStrings(table, "sitemap")}
+	table.ForEach(func(key, value lua.LValue) {
+		if _, ok := key.(lua.LNumber); !ok {
+			return
+		}
+		if groupTable, ok := value.(*lua.LTable); ok {
+			robots.Groups = append(robots.Groups, newRobotsGroup(groupTable))
+		}
+	})
+	if len(robots.Groups) == 0 {
+		robots.Groups = []RobotsGroup{newRobotsGroup(table)}
+	}
+	return robots
+}
+
+// String returns the contents of robots.txt. The given sitemap URL is
+// referenced if no sitemaps are configured and it is not empty.
+func (robots *Robots) String(sitemapURL string) string {
+	if robots.Raw != "" {
+		return robots.Raw
+	}
+	var sb strings.Builder
+	for i, group := range robots.Groups {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		for _, userAgent := range group.UserAgents {
+			sb.WriteString("User-agent: " + userAgent + "\n")
+		}
+		for _, prefix := range group.Allow {
+			sb.WriteString("Allow: " + prefix + "\n")
+		}
+		for _, prefix := range group.Disallow {
+			sb.WriteString("Disallow: " + prefix + "\n")
+		}
+		if len(group.Allow) == 0 && len(group.Disallow) == 0 {
+			// An empty Disallow allows everything
+			sb.WriteString("Disallow:\n")
+		}
+	}
+	sitemaps := robots.Sitemaps
+	if len(sitemaps) == 0 && sitemapURL != "" {
+		sitemaps = []string{sitemapURL}
+	}
+	if len(sitemaps) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, sitemap := range sitemaps {
+		sb.WriteString("Sitemap: " + sitemap + "\n")
+	}
+	return sb.String()
+}
+
+// RobotsHandler serves robots.txt, as configured with SetRobots. The sitemap
+// is referenced if it is enabled with EnableSitemap.
+func (ac *Config) RobotsHandler(w http.ResponseWriter, req *http.Request) {
+	var sitemapURL string
+	if ac.sitemapPath != "" {
+		if baseURL, ok := ac.requestBaseURL(req); ok {
+			sitemapURL = baseURL + ac.sitemapPath
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	w.Write([]byte(ac.robots.String(sitemapURL)))
+}
@@ -0,0 +1,186 @@
+package engine
+
+// Routes with named path parameters, like "/user/:id" or "/files/*path"
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/sheepcounter"
+)
+
+// Route is an URL path pattern and the file that should handle matching
+// requests. If Method is set, only requests with that HTTP method are handled.
+type Route struct {
+	Method   string
+	Pattern  string
+	Filename string
+	segments []string
+}
+
+// routeParamsKey is the context key for the captured path parameters
+type routeParamsKey struct{}
+
+// NewRoute creates a new route. The pattern may contain ":name" segments,
+// that match a single path segment, and a final "*name" segment, that
+// matches the rest of the path. An empty method matches all methods.
+func NewRoute(method, pattern, filename string) *Route {
+	return &Route{
+		Method:   method,
+		Pattern:  pattern,
+		Filename: filename,
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+	}
+}
+
+// Match checks if the given URL path matches the route, and returns the
+// captured path parameters
+func (r *Route) Match(urlPath string) (map[string]string, bool) {
+	return r.match(urlPath, false)
+}
+
+// match checks if the given URL path matches the route, optionally ignoring
+// the case of the segments in the pattern, and returns the captured path
+// parameters
+func (r *Route) match(urlPath string, ignoreCase bool) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	params := make(map[string]string)
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "*") {
+			// The wildcard matches the rest of the path, which may be empty
+			params[segment[1:]] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, ":") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[segment[1:]] = parts[i]
+			continue
+		}
+		if segment != parts[i] && !(ignoreCase && strings.EqualFold(segment, parts[i])) {
+			return nil, false
+		}
+	}
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// lowercase returns the given URL path, which must match the route when
+// ignoring case, with the segments that are not captured as path parameters
+// in lowercase
+func (r *Route) lowercase(urlPath string) string {
+	trimmed := strings.Trim(urlPath, "/")
+	start := len(urlPath) - len(strings.TrimLeft(urlPath, "/"))
+	parts := strings.Split(trimmed, "/")
+	for i, segment := range r.segments {
+		if i >= len(parts) || strings.HasPrefix(segment, "*") {
+			break
+		}
+		if !strings.HasPrefix(segment, ":") {
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return urlPath[:start] + strings.Join(parts, "/") + urlPath[start+len(trimmed):]
+}
+
+// wantsSlash checks if the canonical URL path for the route ends with a slash,
+// which it does if the pattern does. The second value is false if the pattern
+// ends with a wildcard, since the captured path may end with a slash or not.
+func (r *Route) wantsSlash() (bool, bool) {
+	if strings.HasPrefix(r.segments[len(r.segments)-1], "*") {
+		return false, false
+	}
+	return strings.HasSuffix(r.Pattern, "/") && r.Pattern != "/", true
+}
+
+// AllowsMethod checks if the route handles the given HTTP method.
+// Routes for GET also handle HEAD.
+func (r *Route) AllowsMethod(method string) bool {
+	return r.Method == "" || r.Method == method || (r.Method == http.MethodGet && method == http.MethodHead)
+}
+
+// RouteParams returns the path parameters that were captured for the given
+// request, or nil
+func RouteParams(req *http.Request) map[string]string {
+	params, _ := req.Context().Value(routeParamsKey{}).(map[string]string)
+	return params
+}
+
+// allowHeader returns the value of the Allow header for the given methods,
+// without duplicates and in sorted order
+func allowHeader(methods []string) string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, method := range methods {
+		if !seen[method] {
+			seen[method] = true
+			unique = append(unique, method)
+		}
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ", ")
+}
+
+// routeHandler serves requests that match one of the configured routes with
+// the file for that route, and passes all other requests on. If the URL path
+// matches, but not the method, "405 Method Not Allowed" is returned.
+func (ac *Config) routeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var allowed []string
+		for _, route := range ac.routes {
+			params, ok := route.match(req.URL.Path, ac.caseInsensitivePaths)
+			if !ok {
+				continue
+			}
+			wantSlash, checkSlash := route.wantsSlash()
+			if checkSlash && ac.trailingSlash == trailingSlashStrict && trailingSlashMismatch(req.URL.Path, wantSlash) {
+				continue
+			}
+			if !route.AllowsMethod(req.Method) {
+				allowed = append(allowed, route.Method)
+				if route.Method == http.MethodGet {
+					allowed = append(allowed, http.MethodHead)
+				}
+				continue
+			}
+			if checkSlash && ac.redirectTrailingSlash(w, req, wantSlash) {
+				return
+			}
+			// Rejecting requests is handled by the permission system
+			if ac.perm != nil && ac.perm.Rejected(w, req) {
+				sc := sheepcounter.New(w)
+				ac.perm.DenyFunction()(sc, req)
+				ac.LogAccess(req, http.StatusForbidden, sc.Counter())
+				return
+			}
+			req = req.WithContext(context.WithValue(req.Context(), routeParamsKey{}, params))
+			if !ac.noHeaders {
+				ac.ServerHeaders(w)
+			}
+			// Prepare to record the status code and count bytes written
+			sw := NewStatusWriter(w)
+			sc := sheepcounter.New(sw)
+			// Compress the response, if enabled and suitable
+			cw, closeCompression := ac.NewCompressWriter(sc, req)
+			ac.FilePage(cw, req, route.Filename, ac.defaultLuaDataFilename)
+			closeCompression()
+			ac.LogAccess(req, sw.Status(), sc.Counter())
+			return
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", allowHeader(allowed))
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			ac.LogAccess(req, http.StatusMethodNotAllowed, 0)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,12 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestAllowHeader(t *testing.T) {
+	assert.Equal(t, allowHeader([]string{"POST", "GET", "HEAD", "GET", "HEAD"}), "GET, HEAD, POST")
+	assert.Equal(t, allowHeader([]string{"PUT"}), "PUT")
+}
@@ -0,0 +1,216 @@
+package engine
+
+// Scheduled tasks, as configured with Every and Cron in the server configuration script
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How far into the future to look for the next time a cron expression matches
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+var errCronSpec = errors.New("invalid cron expression")
+
+// cronField is the set of allowed values for one of the fields in a cron expression
+type cronField struct {
+	values map[int]bool
+	any    bool // true if the field is "*"
+}
+
+// CronSchedule is a parsed cron expression, with the fields
+// minute, hour, day of month, month and day of week
+type CronSchedule struct {
+	minute, hour, dom, month, dow cronField
+}
+
+// parseCronField parses one field of a cron expression, like "*", "*/15",
+// "1-5", "0,30" or "10-50/10", within the given range of values
+func parseCronField(field string, min, max int) (cronField, error) {
+	cf := cronField{values: make(map[int]bool), any: field == "*"}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if pos := strings.Index(part, "/"); pos != -1 {
+			var err error
+			step, err = strconv.Atoi(part[pos+1:])
+			if err != nil || step < 1 {
+				return cf, errCronSpec
+			}
+			part = part[:pos]
+		}
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			fields := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(fields[0])
+			end, err2 = strconv.Atoi(fields[1])
+			if err1 != nil || err2 != nil {
+				return cf, errCronSpec
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return cf, errCronSpec
+			}
+			start, end = n, n
+		}
+		if start < min || end > max || start > end {
+			return cf, errCronSpec
+		}
+		for i := start; i <= end; i += step {
+			cf.values[i] = true
+		}
+	}
+	return cf, nil
+}
+
+// ParseCron parses a cron expression with five fields, like "0 3 * * *"
+// for every night at 03:00. The aliases @hourly, @daily, @weekly and
+// @monthly are also supported.
+func ParseCron(spec string) (*CronSchedule, error) {
+	switch strings.TrimSpace(spec) {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errCronSpec
+	}
+	var (
+		cs     CronSchedule
+		err    error
+		ranges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+		dests  = [5]*cronField{&cs.minute, &cs.hour, &cs.dom, &cs.month, &cs.dow}
+	)
+	for i, field := range fields {
+		if *dests[i], err = parseCronField(field, ranges[i][0], ranges[i][1]); err != nil {
+			return nil, err
+		}
+	}
+	// Both 0 and 7 are Sunday
+	if cs.dow.values[7] {
+		cs.dow.values[0] = true
+	}
+	return &cs, nil
+}
+
+// Matches checks if the given time matches the cron schedule, to the minute
+func (cs *CronSchedule) Matches(t time.Time) bool {
+	if !cs.minute.values[t.Minute()] || !cs.hour.values[t.Hour()] || !cs.month.values[int(t.Month())] {
+		return false
+	}
+	domMatch := cs.dom.values[t.Day()]
+	dowMatch := cs.dow.values[int(t.Weekday())]
+	// As for cron, if both day fields are restricted, either of them may match
+	if !cs.dom.any && !cs.dow.any {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the next time after the given time that matches the cron
+// schedule, or the zero time if there is none within the next few years
+func (cs *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if cs.Matches(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// scheduleStop returns a channel that is closed when the server shuts down,
+// or when the configuration is replaced by a reload
+func (ac *Config) scheduleStop() chan struct{} {
+	ac.scheduleMutex.Lock()
+	defer ac.scheduleMutex.Unlock()
+	if ac.scheduleStopChan == nil {
+		ac.scheduleStopChan = make(chan struct{})
+		AtShutdown(ac.stopScheduled)
+	}
+	return ac.scheduleStopChan
+}
+
+// stopScheduled stops the scheduled tasks, workers and subscriptions that
+// were started by the configuration scripts for this configuration
+func (ac *Config) stopScheduled() {
+	ac.scheduleMutex.Lock()
+	defer ac.scheduleMutex.Unlock()
+	if ac.scheduleStopChan == nil || ac.scheduleStopped {
+		return
+	}
+	close(ac.scheduleStopChan)
+	ac.scheduleStopped = true
+}
+
+// runScheduled runs the given Lua function from the configuration script.
+// The given flag makes sure that a task never runs twice at the same time.
+func (ac *Config) runScheduled(name string, luaFunc *ConfigFunction, running *int32) {
+	if !atomic.CompareAndSwapInt32(running, 0, 1) {
+		log.Warn("Skipping scheduled task ", name, ", since it is still running")
+		return
+	}
+	defer atomic.StoreInt32(running, 0)
+	if _, err := ac.callConfigFunction(luaFunc); err != nil {
+		log.Error("Scheduled task ", name, " failed: ", err)
+	}
+}
+
+// ScheduleEvery runs the given Lua function at the given interval, until shutdown
+func (ac *Config) ScheduleEvery(interval time.Duration, luaFunc *ConfigFunction) {
+	stop := ac.scheduleStop()
+	name := "every " + interval.String()
+	go func() {
+		var running int32
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				go ac.runScheduled(name, luaFunc, &running)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// ScheduleCron runs the given Lua function according to the given cron schedule, until shutdown
+func (ac *Config) ScheduleCron(spec string, cs *CronSchedule, luaFunc *ConfigFunction) {
+	stop := ac.scheduleStop()
+	name := "\"" + spec + "\""
+	go func() {
+		var running int32
+		for {
+			next := cs.Next(time.Now())
+			if next.IsZero() {
+				log.Warn("The cron expression ", name, " never matches")
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				go ac.runScheduled(name, luaFunc, &running)
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// defaultHSTSMaxAge is the default max age for HSTS, in seconds (one year)
+const defaultHSTSMaxAge = 31536000
+
+// hstsHeaderValue builds a Strict-Transport-Security header value from a Lua
+// value that is either true or a table with "maxage", "includesubdomains"
+// and "preload". Returns an empty string if HSTS should not be used.
+func hstsHeaderValue(lv lua.LValue) string {
+	maxAge := defaultHSTSMaxAge
+	includeSubDomains, preload := false, false
+	switch v := lv.(type) {
+	case lua.LBool:
+		if !bool(v) {
+			return ""
+		}
+	case *lua.LTable:
+		if n, ok := v.RawGetString("maxage").(lua.LNumber); ok {
+			maxAge = int(n)
+		}
+		includeSubDomains = lua.LVAsBool(v.RawGetString("includesubdomains"))
+		preload = lua.LVAsBool(v.RawGetString("preload"))
+	default:
+		return ""
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// SetSecurityHeaders configures the security headers that are added to all
+// responses, from a Lua table with the keys "hsts", "nosniff", "frameoptions",
+// "referrerpolicy" and "csp"
+func (ac *Config) SetSecurityHeaders(settings *lua.LTable) {
+	header := make(http.Header)
+	if lua.LVAsBool(settings.RawGetString("nosniff")) {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+	if s := lua.LVAsString(settings.RawGetString("frameoptions")); s != "" {
+		header.Set("X-Frame-Options", s)
+	}
+	if s := lua.LVAsString(settings.RawGetString("referrerpolicy")); s != "" {
+		header.Set("Referrer-Policy", s)
+	}
+	if s := lua.LVAsString(settings.RawGetString("csp")); s != "" {
+		header.Set("Content-Security-Policy", s)
+	}
+	ac.securityHeaders = header
+	ac.hstsHeader = hstsHeaderValue(settings.RawGetString("hsts"))
+}
+
+// securityHeadersHandler adds the configured security headers to all responses.
+// HSTS is only sent over HTTPS and HTTP/3, as required by RFC 6797.
+func (ac *Config) securityHeadersHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for key, values := range ac.securityHeaders {
+			w.Header()[key] = values
+		}
+		if ac.hstsHeader != "" && (req.TLS != nil || req.ProtoMajor == 3) {
+			w.Header().Set("Strict-Transport-Security", ac.hstsHeader)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
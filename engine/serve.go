@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"crypto/tls"
 	"errors"
 	"net/http"
 	"os"
@@ -73,27 +74,39 @@ func (ac *Config) GenerateShutdownFunction(gracefulServer *graceful.Server, quic
 	}
 }
 
+// wrapHandler adds the handlers that should apply to all requests, for all
+// protocols, around the given handler
+func (ac *Config) wrapHandler(handler http.Handler) http.Handler {
+	return ac.tracingHandler(ac.accessLogModeHandler(ac.perIPLimitHandler(ac.concurrencyHandler(ac.maxBodyHandler(ac.clientCertHandler(ac.securityHeadersHandler(ac.altSvcHandler(ac.proxyEarlyDataHandler(ac.webSocketHandler(ac.timeoutHandler(ac.caseInsensitiveHandler(ac.basicAuthHandler(ac.routeHandler(handler))))))))))))))
+}
+
 // NewGracefulServer creates a new graceful server configuration
 func (ac *Config) NewGracefulServer(mux *http.ServeMux, http2support bool, addr string) *graceful.Server {
 	// Server configuration
 	s := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: ac.reloadHandler(ac.wrapHandler(mux)),
 
 		// The timeout values is also the maximum time it can take
 		// for a complete page of Server-Sent Events (SSE).
-		ReadTimeout:  10 * time.Second,
+		ReadTimeout:  time.Duration(ac.readTimeout) * time.Second,
 		WriteTimeout: time.Duration(ac.writeTimeout) * time.Second,
+		IdleTimeout:  time.Duration(ac.idleTimeout) * time.Second,
 
 		MaxHeaderBytes: 1 << 20,
 	}
 	if http2support {
+		// Require client certificates, if configured
+		s.TLSConfig = &tls.Config{}
+		ac.applyClientAuth(s.TLSConfig)
 		// Enable HTTP/2 support
 		http2.ConfigureServer(s, nil)
 	}
 	gracefulServer := &graceful.Server{
 		Server:  s,
 		Timeout: ac.shutdownTimeout,
+		// Limit the number of connections per client IP, if configured
+		ConnState: ac.connLimiter.connState,
 	}
 	// Handle ctrl-c
 	gracefulServer.ShutdownInitiated = ac.GenerateShutdownFunction(gracefulServer, nil) // for investigating gracefulServer.Interrupted
@@ -151,6 +164,11 @@ func (ac *Config) Serve(mux *http.ServeMux, done, ready chan bool) error {
 	// Decide which protocol to listen to
 	switch {
 	case ac.serveJustQUIC: // Just serve QUIC, but fallback to HTTP
+		if ac.clientCAs != nil {
+			// Falling back to HTTP, or advertising HTTP/3, would only
+			// result in "403 Forbidden" for every request
+			ac.fatalExit(errQUICClientCert)
+		}
 		if strings.HasPrefix(ac.serverAddr, ":") {
 			log.Info("Serving QUIC on https://localhost" + ac.serverAddr + "/")
 		} else {
@@ -167,7 +185,7 @@ func (ac *Config) Serve(mux *http.ServeMux, done, ready chan bool) error {
 			// TODO: As far as I can tell, this was never implemented. Look into implementing this for github.com/xyproto/quic
 			//
 			// gracefulServer.ShutdownInitiated = ac.GenerateShutdownFunction(nil, quicServer)
-			if err := http3.ListenAndServe(ac.serverAddr, ac.serverCert, ac.serverKey, mux); err != nil {
+			if err := ac.ListenAndServeQUIC(ac.serverAddr, ac.serverCert, ac.serverKey, mux); err != nil {
 				log.Error("Not serving QUIC after all. Error: ", err)
 				log.Info("Use the -t flag for serving regular HTTP instead")
 				// If QUIC failed (perhaps the key + cert are missing),
@@ -178,6 +196,24 @@ func (ac *Config) Serve(mux *http.ServeMux, done, ready chan bool) error {
 				mut.Unlock()
 			}
 		}()
+	case len(ac.autoTLSDomains) > 0: // HTTPS with certificates from Let's Encrypt
+		httpsAddr := ac.serverAddr
+		if ac.productionMode {
+			httpsAddr = ac.serverHost + ":443"
+		}
+		log.Info("Serving HTTP/2 with automatic certificates for " + strings.Join(ac.autoTLSDomains, ", ") + " on " + httpsAddr)
+		mut.Lock()
+		servingHTTPS = true
+		mut.Unlock()
+		go func() {
+			if err := ac.ServeAutoTLS(mux, httpsAddr, ac.serverHost+":80"); err != nil {
+				log.Errorf("%s. Not serving HTTPS with automatic certificates.", err)
+				mut.Lock()
+				servingHTTPS = false
+				mut.Unlock()
+				justServeRegularHTTP <- true
+			}
+		}()
 	case ac.productionMode:
 		// Listen for both HTTPS+HTTP/2 and HTTP requests, on different ports
 		if len(ac.serverHost) == 0 {
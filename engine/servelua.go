@@ -83,8 +83,10 @@ func (ac *Config) LoadServeFile(w http.ResponseWriter, req *http.Request, L *lua
 			}
 			return 0 // number of results
 		}
-		// nil is the template context (variables etc in a map)
-		if err := tpl.ExecuteWriter(pongoMap, w); err != nil {
+		// Add the values and functions that are available in all templates
+		ctx := ac.templateContext(req)
+		ctx.Update(pongoMap)
+		if err := tpl.ExecuteWriter(ctx, w); err != nil {
 			if ac.debugMode {
 				fmt.Fprint(w, "Could not compile Pongo2:\n\t"+err.Error()+"\n\n"+buf.String())
 			} else {
@@ -94,6 +96,58 @@ func (ac *Config) LoadServeFile(w http.ResponseWriter, req *http.Request, L *lua
 		return 0 // number of results
 	}))
 
+	// Render a Pongo2 template and then render a Pongo2 layout with the result
+	// as the "content" variable. Takes an optional table with template key/values.
+	L.SetGlobal("renderwith", L.NewFunction(func(L *lua.LState) int {
+		scriptdir := filepath.Dir(filename)
+		templateFilename := filepath.Join(scriptdir, L.CheckString(1))
+		layoutFilename := filepath.Join(scriptdir, L.CheckString(2))
+
+		pongoMap := make(pongo2.Context)
+		if L.GetTop() >= 3 {
+			pongoMap = pongo2.Context(convert.Table2interfaceMap(L.CheckTable(3)))
+		}
+
+		content, err := ac.renderPongoFile(req, templateFilename, pongoMap)
+		if err != nil {
+			log.Errorf("Could not render %s: %s", templateFilename, err)
+			L.Push(lua.LString(""))
+			return 1 // number of results
+		}
+
+		// Let the layout place the rendered template with {{ content }}
+		layoutMap := make(pongo2.Context)
+		layoutMap.Update(pongoMap)
+		layoutMap["content"] = pongo2.AsSafeValue(content)
+
+		result, err := ac.renderPongoFile(req, layoutFilename, layoutMap)
+		if err != nil {
+			log.Errorf("Could not render %s: %s", layoutFilename, err)
+			L.Push(lua.LString(""))
+			return 1 // number of results
+		}
+		L.Push(lua.LString(result))
+		return 1 // number of results
+	}))
+
+	// Render a Pongo2 template in the scriptdir with the given table as the
+	// template data, and return the result
+	L.SetGlobal("include", L.NewFunction(func(L *lua.LState) int {
+		templateFilename := filepath.Join(filepath.Dir(filename), L.CheckString(1))
+		pongoMap := make(pongo2.Context)
+		if L.GetTop() >= 2 {
+			pongoMap = pongo2.Context(convert.Table2interfaceMap(L.CheckTable(2)))
+		}
+		result, err := ac.renderPongoFile(req, templateFilename, pongoMap)
+		if err != nil {
+			log.Errorf("Could not include %s: %s", templateFilename, err)
+			L.Push(lua.LString(""))
+			return 1 // number of results
+		}
+		L.Push(lua.LString(result))
+		return 1 // number of results
+	}))
+
 	// Get the rendered contents of a file in the scriptdir. Discards HTTP headers.
 	L.SetGlobal("render", L.NewFunction(func(L *lua.LState) int {
 		scriptdir := filepath.Dir(filename)
@@ -122,3 +176,20 @@ func (ac *Config) LoadServeFile(w http.ResponseWriter, req *http.Request, L *lua
 	}))
 
 }
+
+// renderPongoFile reads a Pongo2 template, using the file cache,
+// and renders it with the given context
+func (ac *Config) renderPongoFile(req *http.Request, templateFilename string, pongoMap pongo2.Context) (string, error) {
+	ext := filepath.Ext(strings.ToLower(templateFilename))
+	templateData, err := ac.cache.Read(templateFilename, ac.shouldCache(ext))
+	if err != nil {
+		return "", err
+	}
+	tpl, err := pongo2.FromString(templateData.String())
+	if err != nil {
+		return "", err
+	}
+	ctx := ac.templateContext(req)
+	ctx.Update(pongoMap)
+	return tpl.Execute(ctx)
+}
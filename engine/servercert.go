@@ -0,0 +1,85 @@
+package engine
+
+// Information about the TLS certificate that is served, for monitoring when
+// it expires
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// ServerCertificateInfo is information about a TLS certificate
+type ServerCertificateInfo struct {
+	Subject   string
+	Issuer    string
+	Serial    string
+	DNSNames  []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// newServerCertificateInfo returns information about the given certificate
+func newServerCertificateInfo(cert *x509.Certificate) *ServerCertificateInfo {
+	return &ServerCertificateInfo{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		Serial:    cert.SerialNumber.String(),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+}
+
+// recordServedCertificate keeps track of the certificate that was most
+// recently served, which may change when AutoTLS renews it
+func (ac *Config) recordServedCertificate(cert *tls.Certificate) {
+	if cert != nil {
+		ac.servedCert.Store(cert)
+	}
+}
+
+// loadCertificateFile parses the first certificate in the given PEM file
+func loadCertificateFile(filename string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found in " + filename)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// TLSCertificateInfo returns information about the TLS certificate that is
+// served. With AutoTLS, this is the certificate that was most recently served.
+// Otherwise, the certificate is read from the file given with --cert.
+func (ac *Config) TLSCertificateInfo() (*ServerCertificateInfo, error) {
+	if cert, ok := ac.servedCert.Load().(*tls.Certificate); ok && len(cert.Certificate) > 0 {
+		leaf := cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, err
+			}
+		}
+		return newServerCertificateInfo(leaf), nil
+	}
+	if ac.serveJustHTTP {
+		return nil, errors.New("TLS is not enabled")
+	}
+	leaf, err := loadCertificateFile(ac.serverCert)
+	if err != nil {
+		return nil, err
+	}
+	return newServerCertificateInfo(leaf), nil
+}
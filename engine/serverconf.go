@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/algernon/lua/datastruct"
+	"github.com/xyproto/algernon/platformdep"
 	"github.com/xyproto/algernon/utils"
 	"github.com/xyproto/gopher-lua"
 	bolt "github.com/xyproto/permissionbolt"
@@ -49,6 +54,7 @@ func (ac *Config) Info() string {
 		"Dev":          ac.devMode,
 		"Server":       ac.serverMode,
 		"StatCache":    ac.cacheFileStat,
+		"DirListing":   !ac.noDirListing,
 	})
 
 	sb.WriteString("Cache mode:\t\t" + ac.cacheMode.String() + "\n")
@@ -59,6 +65,10 @@ func (ac *Config) Info() string {
 	if ac.serverLogFile != "" {
 		sb.WriteString("Log file:\t\t" + ac.serverLogFile + "\n")
 	}
+	if len(ac.autoTLSDomains) > 0 {
+		sb.WriteString("Automatic TLS:\t\t" + strings.Join(ac.autoTLSDomains, ", ") + "\n")
+		sb.WriteString("Certificate cache:\t" + ac.autoTLSCacheDir + "\n")
+	}
 	if !(ac.serveJustHTTP2 || ac.serveJustHTTP) {
 		sb.WriteString("TLS certificate:\t" + ac.serverCert + "\n")
 		sb.WriteString("TLS key:\t\t" + ac.serverKey + "\n")
@@ -89,6 +99,32 @@ func (ac *Config) Info() string {
 	if len(ac.serverConfigurationFilenames) > 0 {
 		sb.WriteString(fmt.Sprintf("Server configuration:\t%v\n", ac.serverConfigurationFilenames))
 	}
+	if len(ac.compressionEncodings) > 0 {
+		sb.WriteString("Compression:\t\t" + strings.Join(ac.compressionEncodings, ", ") + "\n")
+	}
+	sb.WriteString("ETags:\t\t\t" + ac.etagMode + "\n")
+	for _, rp := range ac.reverseProxies {
+		sb.WriteString("Reverse proxy:\t\t" + rp.Prefix + " -> " + rp.Target.String() + "\n")
+	}
+	for _, wd := range ac.webDAVs {
+		sb.WriteString("WebDAV:\t\t\t" + wd.Prefix + " -> " + wd.Dir + "\n")
+	}
+	if ac.combinedAccessLogFilename != "" {
+		sb.WriteString("Access log:\t\t" + ac.combinedAccessLogFilename + " (combined)\n")
+	}
+	if ac.commonAccessLogFilename != "" {
+		sb.WriteString("Access log:\t\t" + ac.commonAccessLogFilename + " (common)\n")
+	}
+	if ac.dirListingTemplate != "" {
+		sb.WriteString("Listing template:\t" + ac.dirListingTemplate + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("Timeouts:\t\tread %ds, write %ds, idle %ds\n", ac.readTimeout, ac.writeTimeout, ac.idleTimeout))
+	if ac.resumableUploadPath != "" {
+		sb.WriteString("Resumable uploads:\t" + ac.resumableUploadPath + "\n")
+	}
+	if ac.pprofPrefix != "" {
+		sb.WriteString("Profiling:\t\t" + ac.pprofPrefix + "\n")
+	}
 	if ac.internalLogFilename != os.DevNull {
 		sb.WriteString("Internal log file:\t" + ac.internalLogFilename + "\n")
 	}
@@ -126,6 +162,7 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 	// Clear the default path prefixes. This makes everything public.
 	L.SetGlobal("ClearPermissions", L.NewFunction(func(L *lua.LState) int {
 		ac.perm.Clear()
+		ac.mixedCasePrefixes = nil
 		return 0 // number of results
 	}))
 
@@ -133,7 +170,7 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 	// as having *user* rights.
 	L.SetGlobal("AddUserPrefix", L.NewFunction(func(L *lua.LState) int {
 		path := L.ToString(1)
-		ac.perm.AddUserPath(path)
+		ac.addPermissionPrefix(path, false)
 		return 0 // number of results
 	}))
 
@@ -141,7 +178,7 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 	// as having *admin* rights.
 	L.SetGlobal("AddAdminPrefix", L.NewFunction(func(L *lua.LState) int {
 		path := L.ToString(1)
-		ac.perm.AddAdminPath(path)
+		ac.addPermissionPrefix(path, true)
 		return 0 // number of results
 	}))
 
@@ -152,11 +189,13 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 		// Custom handler for when permissions are denied
 		ac.perm.SetDenyFunction(func(w http.ResponseWriter, req *http.Request) {
 			// Set up a new Lua state with the current http.ResponseWriter and *http.Request, without caching
-			ac.LoadCommonFunctions(w, req, filename, L, nil, nil)
+			ob := ac.LoadCommonFunctions(w, req, filename, L, nil, nil)
 
 			// Then run the given Lua function
 			L.Push(luaDenyFunc)
-			if err := L.PCall(0, lua.MultRet, nil); err != nil {
+			err := L.PCall(0, lua.MultRet, nil)
+			ob.StopAll()
+			if err != nil {
 				// Non-fatal error
 				log.Error("Permission denied handler failed:", err)
 				// Use the default permission handler from now on if the lua function fails
@@ -184,6 +223,152 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 		return 0 // number of results
 	}))
 
+	// Call the given Lua function when a Lua handler fails. The function
+	// receives a table with the error message, the traceback and information
+	// about the request, and can output a custom error page.
+	L.SetGlobal("OnError", L.NewFunction(func(L *lua.LState) int {
+		ac.onErrorFunc = NewConfigFunction(L, L.CheckFunction(1))
+		return 0 // number of results
+	}))
+
+	// Enable or disable the Lua stack traceback in error messages, for the
+	// debug mode error page, the log and the REPL. Enabled by default.
+	L.SetGlobal("SetDebugTraceback", L.NewFunction(func(L *lua.LState) int {
+		ac.debugTraceback = L.ToBool(1)
+		return 0 // number of results
+	}))
+
+	// Allow the given environment variables to be read with env()
+	L.SetGlobal("AllowEnv", L.NewFunction(func(L *lua.LState) int {
+		if ac.envAllowed == nil {
+			ac.envAllowed = make(map[string]bool)
+		}
+		for _, name := range convert.Table2strings(L.CheckTable(1)) {
+			ac.envAllowed[name] = true
+		}
+		return 0 // number of results
+	}))
+
+	// Run a Lua function at the given interval, like "10m" or a number of seconds.
+	// Returns true if the interval is valid.
+	L.SetGlobal("Every", L.NewFunction(func(L *lua.LState) int {
+		var interval time.Duration
+		if n, ok := L.Get(1).(lua.LNumber); ok {
+			interval = time.Duration(float64(n) * float64(time.Second))
+		} else {
+			var err error
+			if interval, err = time.ParseDuration(L.CheckString(1)); err != nil {
+				log.Error("Invalid interval for Every: ", err)
+			}
+		}
+		luaFunc := L.CheckFunction(2)
+		if interval <= 0 {
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.ScheduleEvery(interval, NewConfigFunction(L, luaFunc))
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Run a Lua function according to a cron expression, like "0 3 * * *".
+	// Returns true if the cron expression is valid.
+	L.SetGlobal("Cron", L.NewFunction(func(L *lua.LState) int {
+		spec := L.CheckString(1)
+		luaFunc := L.CheckFunction(2)
+		cs, err := ParseCron(spec)
+		if err != nil {
+			log.Error("Invalid cron expression: ", spec)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.ScheduleCron(spec, cs, NewConfigFunction(L, luaFunc))
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Process jobs from the queue with the given name, with the given number
+	// of workers, by calling the given Lua function with each job. Takes an
+	// optional number of retries for failed jobs (the default is 0).
+	L.SetGlobal("Worker", L.NewFunction(func(L *lua.LState) int {
+		queueName := L.CheckString(1)
+		concurrency := L.CheckInt(2)
+		luaFunc := L.CheckFunction(3)
+		retries := L.OptInt(4, 0)
+		creator := ac.atomicCreator(ac.perm.UserState().Creator())
+		queue, err := datastruct.NewQueue(creator, queueName)
+		if err != nil {
+			log.Error("Could not create the queue ", queueName, ": ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		failed, err := datastruct.NewQueue(creator, queueName+":failed")
+		if err != nil {
+			log.Error("Could not create the queue ", queueName+":failed", ": ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		ac.StartWorkers(queue, failed, concurrency, retries, NewConfigFunction(L, luaFunc))
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Call a Lua function with each message that is published on the given
+	// channel. Returns true if successful.
+	L.SetGlobal("Subscribe", L.NewFunction(func(L *lua.LState) int {
+		channel := L.CheckString(1)
+		luaFunc := L.CheckFunction(2)
+		if err := ac.Subscribe(channel, NewConfigFunction(L, luaFunc)); err != nil {
+			log.Error("Could not subscribe to ", channel, ": ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Publish a message on the given channel. Returns true if successful.
+	L.SetGlobal("Publish", L.NewFunction(func(L *lua.LState) int {
+		channel := L.CheckString(1)
+		message := L.CheckString(2)
+		if err := ac.Publish(channel, message); err != nil {
+			log.Error("Could not publish to ", channel, ": ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Acquire a lock that is shared between servers, for the given number of
+	// seconds. Returns false if the lock is already held, or false and an
+	// error string if locks are not supported by the database backend.
+	L.SetGlobal("Lock", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		ttl := time.Duration(float64(L.CheckNumber(2)) * float64(time.Second))
+		locked, err := ac.Lock(name, ttl)
+		if err != nil {
+			log.Error("Could not lock ", name, ": ", err)
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LBool(locked))
+		return 1 // number of results
+	}))
+
+	// Release a lock that is held by this server
+	L.SetGlobal("Unlock", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		if err := ac.Unlock(name); err != nil {
+			log.Warn("Could not unlock ", name, ": ", err)
+		}
+		return 0 // number of results
+	}))
+
 	// Set a access log filename. If blank, the log will go to the console (or browser, if debug mode is set).
 	L.SetGlobal("LogTo", L.NewFunction(func(L *lua.LState) int {
 		filename := L.ToString(1)
@@ -193,18 +378,140 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 		// Log to stderr if an empty filename is given
 		if filename == "" {
 			log.SetOutput(os.Stderr)
+			ac.setLogFile(nil)
 			L.Push(lua.LBool(true))
 			return 1 // number of results
 		}
-		// Try opening/creating the given filename, for appending
-		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, ac.defaultPermissions)
-		if err != nil {
+		// Try opening/creating the given filename, for appending.
+		// The file can be reopened with a signal, for log rotation.
+		if err := ac.logToFile(filename); err != nil {
 			log.Error(err)
 			L.Push(lua.LBool(false))
 			return 1 // number of results
 		}
-		// Set the file to log to and return
-		log.SetOutput(f)
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Set the signal that makes the log file be reopened, like "USR1" (the
+	// default) or "USR2". An empty string disables reopening the log file.
+	L.SetGlobal("SetLogReopenSignal", L.NewFunction(func(L *lua.LState) int {
+		name := strings.TrimPrefix(strings.ToUpper(L.CheckString(1)), "SIG")
+		if name == "HUP" {
+			log.Error("SIGHUP is used for reloading the configuration")
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		if _, ok := platformdep.Signal(name); name != "" && !ok {
+			log.Error("Unknown signal: ", name)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.logReopenSignal = name
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Write an access log to the given filename, in "combined" (the default)
+	// or "common" format. This is separate from the log configured by LogTo.
+	L.SetGlobal("SetAccessLog", L.NewFunction(func(L *lua.LState) int {
+		filename := L.CheckString(1)
+		format := "combined"
+		if L.GetTop() >= 2 {
+			format = strings.ToLower(L.ToString(2))
+		}
+		switch format {
+		case "combined":
+			ac.combinedAccessLogFilename = filename
+		case "common", "ncsa":
+			ac.commonAccessLogFilename = filename
+		default:
+			log.Error("Unknown access log format: ", format)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Only log the given share of the successful requests in the access log,
+	// from 0 to 1. Responses that are not 2xx are always logged.
+	L.SetGlobal("SetLogSampling", L.NewFunction(func(L *lua.LState) int {
+		rate := float64(L.CheckNumber(1))
+		if rate < 0 || rate > 1 {
+			L.ArgError(1, "the sampling rate must be from 0 to 1")
+			return 0 // number of results
+		}
+		ac.logSamplingRate = rate
+		return 0 // number of results
+	}))
+
+	// Select how URL paths with and without a trailing slash are handled, for
+	// both files, directories and routes. "ignore" (the default) only redirects
+	// directories, "redirect" redirects to the canonical form and "strict"
+	// only serves the canonical form. Directories and routes with patterns
+	// that end with a slash have a trailing slash in the canonical form.
+	// Returns true if the mode is valid.
+	L.SetGlobal("SetTrailingSlash", L.NewFunction(func(L *lua.LState) int {
+		mode := strings.ToLower(L.CheckString(1))
+		if !trailingSlashModes[mode] {
+			log.Error("Unknown trailing slash mode: ", mode)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.trailingSlash = mode
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Ignore the case of URL paths, for routes, files and directories.
+	// Requests for URL paths with uppercase letters are redirected to the
+	// lowercase form, which is served by the file that matches when ignoring
+	// case, even if the name of the file has uppercase letters.
+	L.SetGlobal("SetCaseInsensitivePaths", L.NewFunction(func(L *lua.LState) int {
+		ac.setCaseInsensitivePaths(L.CheckBool(1))
+		return 0 // number of results
+	}))
+
+	// Require HTTP Basic Authentication for the given URL path prefix. Takes
+	// a username and a bcrypt password hash, or a table with usernames and
+	// hashes. This is separate from the user database. Returns true if all
+	// the hashes are valid bcrypt hashes.
+	L.SetGlobal("BasicAuth", L.NewFunction(func(L *lua.LState) int {
+		prefix := L.CheckString(1)
+		users := make(map[string]string)
+		switch v := L.CheckAny(2).(type) {
+		case lua.LString:
+			users[string(v)] = L.CheckString(3)
+		case *lua.LTable:
+			v.ForEach(func(username, hash lua.LValue) {
+				users[username.String()] = hash.String()
+			})
+		default:
+			L.ArgError(2, "username or table expected")
+			return 0 // number of results
+		}
+		for username, hash := range users {
+			if !ValidBcryptHash(hash) {
+				log.Errorf("Not a bcrypt hash, for the user %s for BasicAuth: %s", username, hash)
+				L.Push(lua.LBool(false))
+				return 1 // number of results
+			}
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		// Add the users to the existing users for this prefix, if any
+		for _, ba := range ac.basicAuths {
+			if ba.Prefix == prefix {
+				for username, hash := range users {
+					ba.Users[username] = hash
+				}
+				L.Push(lua.LBool(true))
+				return 1 // number of results
+			}
+		}
+		ac.basicAuths = append(ac.basicAuths, BasicAuthConfig{Prefix: prefix, Users: users})
 		L.Push(lua.LBool(true))
 		return 1 // number of results
 	}))
@@ -223,6 +530,588 @@ func (ac *Config) LoadServerConfigFunctions(L *lua.LState, filename string) erro
 		return 1 // number of results
 	}))
 
+	// Set the timeout for reading a request, in seconds
+	L.SetGlobal("SetReadTimeout", L.NewFunction(func(L *lua.LState) int {
+		ac.readTimeout = uint64(L.CheckNumber(1))
+		return 0 // number of results
+	}))
+
+	// Set the timeout for writing a response, in seconds
+	L.SetGlobal("SetWriteTimeout", L.NewFunction(func(L *lua.LState) int {
+		ac.writeTimeout = uint64(L.CheckNumber(1))
+		return 0 // number of results
+	}))
+
+	// Set the timeout for idle connections, both for HTTP and QUIC, in seconds
+	L.SetGlobal("SetIdleTimeout", L.NewFunction(func(L *lua.LState) int {
+		ac.idleTimeout = uint64(L.CheckNumber(1))
+		return 0 // number of results
+	}))
+
+	// Set the maximum number of concurrent streams per QUIC connection
+	L.SetGlobal("SetQUICMaxStreams", L.NewFunction(func(L *lua.LState) int {
+		maxStreams := L.CheckInt(1)
+		if maxStreams < 1 {
+			L.ArgError(1, "the number of streams must be at least 1")
+			return 0 // number of results
+		}
+		ac.quicMaxStreams = maxStreams
+		return 0 // number of results
+	}))
+
+	// Set the QUIC flow control window for receiving data on a stream, in bytes.
+	// The window for a connection is 1.5 times as large.
+	L.SetGlobal("SetQUICReceiveWindow", L.NewFunction(func(L *lua.LState) int {
+		window := L.CheckInt64(1)
+		if window < 1 {
+			L.ArgError(1, "the window size must be a positive number of bytes")
+			return 0 // number of results
+		}
+		ac.quicReceiveWindow = uint64(window)
+		return 0 // number of results
+	}))
+
+	// Select the congestion control algorithm for QUIC. Only "cubic" is
+	// supported by the QUIC package, and it is the default. Returns true if
+	// the given algorithm is supported.
+	L.SetGlobal("SetQUICCongestion", L.NewFunction(func(L *lua.LState) int {
+		algorithm := strings.ToLower(L.CheckString(1))
+		if !quicCongestionAlgorithms[algorithm] {
+			log.Errorf("The %q congestion control algorithm is not supported for QUIC, using \"cubic\"", algorithm)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Add security headers to all responses. Takes a table with "hsts" (true or
+	// a table with "maxage", "includesubdomains" and "preload"), "nosniff" (bool),
+	// "frameoptions", "referrerpolicy" and "csp" (strings).
+	L.SetGlobal("SetSecurityHeaders", L.NewFunction(func(L *lua.LState) int {
+		ac.SetSecurityHeaders(L.CheckTable(1))
+		return 0 // number of results
+	}))
+
+	// Require client certificates that are signed by the CA in the given PEM
+	// file, relative to the configuration script. Returns true on success.
+	L.SetGlobal("RequireClientCert", L.NewFunction(func(L *lua.LState) int {
+		caCertFilename := L.CheckString(1)
+		if !filepath.IsAbs(caCertFilename) {
+			caCertFilename = filepath.Join(filepath.Dir(filename), caCertFilename)
+		}
+		pool, err := LoadClientCAs(caCertFilename)
+		if err != nil {
+			log.Error("Could not load the CA certificate for client certificates: ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.clientCAs = pool
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Obtain and renew HTTPS certificates for the given domains automatically,
+	// with ACME (Let's Encrypt). Takes a table of domains, an e-mail address and
+	// an optional directory for storing the certificates.
+	L.SetGlobal("AutoTLS", L.NewFunction(func(L *lua.LState) int {
+		ac.autoTLSDomains = convert.Table2strings(L.CheckTable(1))
+		ac.autoTLSEmail = L.ToString(2)
+		if L.GetTop() >= 3 {
+			ac.autoTLSCacheDir = filepath.Join(filepath.Dir(filename), L.ToString(3))
+		} else {
+			ac.autoTLSCacheDir = defaultAutoTLSCacheDir()
+		}
+		return 0 // number of results
+	}))
+
+	// Enable or disable the Alt-Svc header that advertises HTTP/3 over
+	// HTTP/1.1 and HTTP/2. Takes an optional max age, in seconds.
+	L.SetGlobal("SetAltSvc", L.NewFunction(func(L *lua.LState) int {
+		ac.altSvc = L.ToBool(1)
+		ac.altSvcConfigured = true
+		if L.GetTop() >= 2 {
+			ac.altSvcMaxAge = int(L.CheckNumber(2))
+		}
+		return 0 // number of results
+	}))
+
+	// Allow or refuse requests that a proxy has marked as early data (0-RTT),
+	// with the "Early-Data: 1" header. Refused requests receive
+	// "425 Too Early". The default is to refuse them.
+	L.SetGlobal("SetProxyEarlyData", L.NewFunction(func(L *lua.LState) int {
+		ac.proxyEarlyData = L.ToBool(1)
+		return 0 // number of results
+	}))
+
+	// Enable or disable minification of CSS, both for GCSS output and .css files
+	L.SetGlobal("SetCSSMinify", L.NewFunction(func(L *lua.LState) int {
+		ac.cssMinify = L.ToBool(1)
+		return 0 // number of results
+	}))
+
+	// Set the maximum size of request bodies, in MiB. Takes an optional URL
+	// path prefix, for setting a different limit for that prefix.
+	L.SetGlobal("SetMaxBodySize", L.NewFunction(func(L *lua.LState) int {
+		limit := megabytesToBytes(float64(L.CheckNumber(1)))
+		if L.GetTop() >= 2 {
+			if ac.maxBodyBytesPrefixes == nil {
+				ac.maxBodyBytesPrefixes = make(map[string]int64)
+			}
+			ac.maxBodyBytesPrefixes[L.CheckString(2)] = limit
+			return 0 // number of results
+		}
+		ac.maxBodyBytes = limit
+		return 0 // number of results
+	}))
+
+	// Set the maximum number of simultaneous connections from a single client
+	// IP, for both TCP and QUIC. 0 disables the limit.
+	L.SetGlobal("SetMaxConnsPerIP", L.NewFunction(func(L *lua.LState) int {
+		ac.connLimiter.setMax(L.CheckInt(1))
+		return 0 // number of results
+	}))
+
+	// Set the maximum number of simultaneous requests from a single client IP.
+	// 0 disables the limit.
+	L.SetGlobal("SetMaxRequestsPerIP", L.NewFunction(func(L *lua.LState) int {
+		ac.maxRequestsPerIP = L.CheckInt(1)
+		return 0 // number of results
+	}))
+
+	// Set the maximum number of requests that are handled at the same time, and
+	// optionally how many requests may wait in a queue. 0 disables the limit.
+	L.SetGlobal("SetMaxConcurrentRequests", L.NewFunction(func(L *lua.LState) int {
+		max := L.CheckInt(1)
+		queueDepth := L.OptInt(2, 0)
+		if max <= 0 {
+			ac.setRequestLimiter(nil)
+			return 0 // number of results
+		}
+		ac.setRequestLimiter(NewRequestLimiter(max, queueDepth))
+		return 0 // number of results
+	}))
+
+	// Set the maximum time for handling a request, in seconds. Takes an optional
+	// URL path prefix, for setting a different timeout for that prefix.
+	L.SetGlobal("SetHandlerTimeout", L.NewFunction(func(L *lua.LState) int {
+		timeout := time.Duration(float64(L.CheckNumber(1)) * float64(time.Second))
+		if L.GetTop() >= 2 {
+			if ac.handlerTimeoutPrefixes == nil {
+				ac.handlerTimeoutPrefixes = make(map[string]time.Duration)
+			}
+			ac.handlerTimeoutPrefixes[L.CheckString(2)] = timeout
+			return 0 // number of results
+		}
+		ac.handlerTimeoutDuration = timeout
+		return 0 // number of results
+	}))
+
+	// Make a value available in all Pongo2 templates, like {{ name }}
+	L.SetGlobal("SetTemplateGlobal", L.NewFunction(func(L *lua.LState) int {
+		value, err := convert.LValue2Interface(L.CheckAny(2))
+		if err != nil {
+			L.ArgError(2, err.Error())
+			return 0 // number of results
+		}
+		ac.SetTemplateGlobal(L.CheckString(1), value)
+		return 0 // number of results
+	}))
+
+	// Make a Lua function available in all Pongo2 templates, like {{ name(arg) }}
+	L.SetGlobal("RegisterTemplateFunction", L.NewFunction(func(L *lua.LState) int {
+		ac.RegisterTemplateFunction(L.CheckString(1), NewConfigFunction(L, L.CheckFunction(2)))
+		return 0 // number of results
+	}))
+
+	// Load message files for translations with t(), from the given directory,
+	// relative to the configuration script. Takes an optional default locale.
+	// Returns true if successful.
+	L.SetGlobal("LoadTranslations", L.NewFunction(func(L *lua.LState) int {
+		dir := filepath.Join(filepath.Dir(filename), L.CheckString(1))
+		t, err := LoadTranslations(dir, L.OptString(2, defaultLocale))
+		if err != nil {
+			log.Error("Could not load translations: ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.translations = t
+		log.Info("Loaded translations for ", strings.Join(t.Locales(), ", "))
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Set the filenames that are served instead of a directory listing, in
+	// order of priority, like {"index.lua", "index.md", "index.html"}
+	L.SetGlobal("SetIndexFiles", L.NewFunction(func(L *lua.LState) int {
+		ac.indexFiles = convert.Table2strings(L.CheckTable(1))
+		return 0 // number of results
+	}))
+
+	// Serve requests that match the given URL path pattern with the given file,
+	// relative to the configuration script. The pattern may contain ":name"
+	// segments and a final "*name" segment. Returns true if the file exists.
+	L.SetGlobal("Route", L.NewFunction(func(L *lua.LState) int {
+		pattern := L.CheckString(1)
+		handlerFilename := filepath.Join(filepath.Dir(filename), L.CheckString(2))
+		if !ac.fs.Exists(handlerFilename) {
+			log.Error("Could not find ", handlerFilename)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.routes = append(ac.routes, NewRoute("", pattern, handlerFilename))
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Method-specific routes, like OnGet and OnPost. Requests for the same URL
+	// path, but with a different method, receive "405 Method Not Allowed".
+	for luaName, method := range map[string]string{
+		"OnGet":    http.MethodGet,
+		"OnPost":   http.MethodPost,
+		"OnPut":    http.MethodPut,
+		"OnPatch":  http.MethodPatch,
+		"OnDelete": http.MethodDelete,
+	} {
+		method := method
+		L.SetGlobal(luaName, L.NewFunction(func(L *lua.LState) int {
+			pattern := L.CheckString(1)
+			handlerFilename := filepath.Join(filepath.Dir(filename), L.CheckString(2))
+			if !ac.fs.Exists(handlerFilename) {
+				log.Error("Could not find ", handlerFilename)
+				L.Push(lua.LBool(false))
+				return 1 // number of results
+			}
+			ac.routes = append(ac.routes, NewRoute(method, pattern, handlerFilename))
+			L.Push(lua.LBool(true))
+			return 1 // number of results
+		}))
+	}
+
+	// Serve files from an embedded filesystem at the given URL path prefix,
+	// for files that are not found on disk. Returns true if the embedded
+	// filesystem has been registered with RegisterEmbeddedFS.
+	L.SetGlobal("ServeEmbedded", L.NewFunction(func(L *lua.LState) int {
+		fsName := L.CheckString(1)
+		prefix := "/"
+		if L.GetTop() >= 2 {
+			prefix = "/" + strings.TrimLeft(L.CheckString(2), "/")
+		}
+		if _, ok := embeddedFS(fsName); !ok {
+			log.Error("No embedded filesystem named ", fsName)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.embeddedMounts = append(ac.embeddedMounts, EmbeddedMount{Name: fsName, Prefix: prefix})
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Serve the given file for GET requests that do not match a file or a
+	// directory. Takes an optional URL path prefix (the default is "/") and an
+	// optional table of URL path prefixes to exclude, like "/api".
+	L.SetGlobal("SetSPAFallback", L.NewFunction(func(L *lua.LState) int {
+		indexFilename := filepath.Join(filepath.Dir(filename), L.CheckString(1))
+		if !ac.fs.Exists(indexFilename) {
+			log.Error("Could not find ", indexFilename)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		fallback := SPAFallback{Prefix: "/", Filename: indexFilename}
+		if L.GetTop() >= 2 {
+			fallback.Prefix = "/" + strings.TrimLeft(L.CheckString(2), "/")
+		}
+		if L.GetTop() >= 3 {
+			fallback.Excludes = convert.Table2strings(L.CheckTable(3))
+		}
+		ac.spaFallbacks = append(ac.spaFallbacks, fallback)
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Use a Pongo2 template for the error page for the given HTTP status code,
+	// or for all error codes that are not configured if "default" is given.
+	// Returns true if the template file was found.
+	L.SetGlobal("SetErrorPage", L.NewFunction(func(L *lua.LState) int {
+		code := L.ToString(1)
+		templateFilename := filepath.Join(filepath.Dir(filename), L.CheckString(2))
+		if !ac.fs.Exists(templateFilename) {
+			log.Error("Could not find ", templateFilename)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		if ac.errorPages == nil {
+			ac.errorPages = make(map[string]string)
+		}
+		ac.errorPages[code] = templateFilename
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Forward requests where the URL path starts with the given prefix
+	// to the given backend URL. Returns true if the URL is valid.
+	L.SetGlobal("ReverseProxy", L.NewFunction(func(L *lua.LState) int {
+		prefix := L.CheckString(1)
+		target, err := url.Parse(L.CheckString(2))
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			log.Error("Invalid URL for the reverse proxy: ", L.ToString(2))
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		ac.reverseProxies = append(ac.reverseProxies, ReverseProxyConfig{Prefix: prefix, Target: target})
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Serve the given directory over WebDAV at the given URL path prefix.
+	// The directory is relative to the configuration script. Only logged in
+	// users have access, or only administrators if the third argument is
+	// "admin". Returns true if the directory exists.
+	L.SetGlobal("EnableWebDAV", L.NewFunction(func(L *lua.LState) int {
+		prefix := L.CheckString(1)
+		dir := L.CheckString(2)
+		access := L.OptString(3, "user")
+		if access != "user" && access != "admin" {
+			log.Error("The access for WebDAV must be \"user\" or \"admin\": ", access)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(filename), dir)
+		}
+		if !ac.fs.IsDir(dir) {
+			log.Error("Not a directory, for WebDAV: ", dir)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		ac.webDAVs = append(ac.webDAVs, WebDAVConfig{Prefix: prefix, Dir: dir, Admin: access == "admin"})
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Enable resumable uploads at the given URL path. Takes an optional
+	// directory for storing the uploads, relative to the configuration script.
+	L.SetGlobal("EnableResumableUploads", L.NewFunction(func(L *lua.LState) int {
+		ac.resumableUploadPath = L.CheckString(1)
+		if L.GetTop() >= 2 {
+			ac.resumableUploadDir = filepath.Join(filepath.Dir(filename), L.ToString(2))
+		} else {
+			ac.resumableUploadDir = filepath.Join(ac.serverTempDir, "uploads")
+		}
+		return 0 // number of results
+	}))
+
+	// Use ANSI graphics from the given file as the startup banner. The file can
+	// contain text, or gzipped or gzipped and base64 encoded text. An empty
+	// string disables the banner. Returns true if successful.
+	L.SetGlobal("SetBanner", L.NewFunction(func(L *lua.LState) int {
+		bannerFilename := L.CheckString(1)
+		if bannerFilename == "" {
+			ac.noBanner = true
+			ac.customBanner = ""
+			L.Push(lua.LBool(true))
+			return 1 // number of results
+		}
+		banner, err := platformdep.LoadBanner(filepath.Join(filepath.Dir(filename), bannerFilename))
+		if err != nil {
+			log.Error("Could not load banner: ", err)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.customBanner = banner
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Create Lua states until there are at least the given minimum number of
+	// idle states in the pool, and set the maximum number of idle states to keep.
+	// A maximum of 0 means no limit.
+	L.SetGlobal("SetLuaPoolSize", L.NewFunction(func(L *lua.LState) int {
+		ac.luapool.SetSize(L.CheckInt(1), L.CheckInt(2))
+		return 0 // number of results
+	}))
+
+	// Register a liveness endpoint that responds with 200 OK while the server
+	// is up, and a readiness endpoint that also checks the database backend.
+	// The permission prefixes do not apply. An empty string skips an endpoint.
+	L.SetGlobal("SetHealthCheck", L.NewFunction(func(L *lua.LState) int {
+		ac.healthLivePath = L.CheckString(1)
+		ac.healthReadyPath = L.OptString(2, "")
+		return 0 // number of results
+	}))
+
+	// Serve a sitemap of the pages in the server directory at the given URL
+	// path. The default path is "/sitemap.xml". Pages that require a login
+	// are left out. Takes an optional base URL, like "https://example.com".
+	L.SetGlobal("EnableSitemap", L.NewFunction(func(L *lua.LState) int {
+		sitemapPath := L.OptString(1, defaultSitemapPath)
+		baseURL := strings.TrimSuffix(L.OptString(2, ""), "/")
+		if baseURL != "" && !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+			L.ArgError(2, "the base URL must start with http:// or https://")
+			return 0 // number of results
+		}
+		ac.sitemapPath = sitemapPath
+		ac.sitemapBaseURL = baseURL
+		ac.sitemapCache = &sitemapCache{}
+		return 0 // number of results
+	}))
+
+	// Set the sitemap priority, from 0.0 to 1.0, for the URL paths that match
+	// the given glob pattern or URL path prefix. The first match is used.
+	L.SetGlobal("SetSitemapPriority", L.NewFunction(func(L *lua.LState) int {
+		priority := float64(L.CheckNumber(2))
+		if priority < 0 || priority > 1 {
+			L.ArgError(2, "the priority must be from 0.0 to 1.0")
+			return 0 // number of results
+		}
+		ac.sitemapPriorities = append(ac.sitemapPriorities, SitemapPriority{Pattern: L.CheckString(1), Priority: priority})
+		return 0 // number of results
+	}))
+
+	// Serve /robots.txt, either as the given string, or generated from a table
+	// with "user_agent", "allow", "disallow" and "sitemap" fields, or a list of
+	// tables with "user_agent", "allow" and "disallow" fields
+	L.SetGlobal("SetRobots", L.NewFunction(func(L *lua.LState) int {
+		switch v := L.CheckAny(1).(type) {
+		case lua.LString:
+			ac.robots = &Robots{Raw: string(v)}
+		case *lua.LTable:
+			ac.robots = Table2Robots(v)
+		default:
+			L.ArgError(1, "string or table expected")
+		}
+		return 0 // number of results
+	}))
+
+	// Send a span for each request to the given OpenTelemetry collector, with
+	// OTLP over HTTP, like "http://localhost:4318". The W3C traceparent header
+	// is read from requests and added to the requests made with HTTPClient.
+	// Returns true if tracing could be enabled.
+	L.SetGlobal("EnableTracing", L.NewFunction(func(L *lua.LState) int {
+		tracer, err := NewTracer(L.CheckString(1), L.OptString(2, "flunix"), ac.scheduleStop())
+		if err != nil {
+			log.Error("Could not enable tracing: ", err)
+			L.Push(lua.LFalse)
+			return 1 // number of results
+		}
+		ac.tracer = tracer
+		L.Push(lua.LTrue)
+		return 1 // number of results
+	}))
+
+	// Set how long a session lasts after it was last changed, in seconds.
+	// The default is 24 hours.
+	L.SetGlobal("SetSessionTTL", L.NewFunction(func(L *lua.LState) int {
+		seconds := L.CheckNumber(1)
+		if seconds <= 0 {
+			L.ArgError(1, "the number of seconds must be positive")
+			return 0 // number of results
+		}
+		ac.sessionTTL = time.Duration(float64(seconds) * float64(time.Second))
+		return 0 // number of results
+	}))
+
+	// Serve the net/http/pprof handlers at the given URL path prefix, which is
+	// registered as an admin prefix. The default prefix is "/debug/pprof".
+	L.SetGlobal("EnablePprof", L.NewFunction(func(L *lua.LState) int {
+		ac.pprofPrefix = defaultPprofPrefix
+		if L.GetTop() >= 1 {
+			ac.pprofPrefix = L.CheckString(1)
+		}
+		return 0 // number of results
+	}))
+
+	// Enable or disable directory listings for directories without an index file.
+	// Takes an optional bool for including hidden files in the listings.
+	L.SetGlobal("SetDirListing", L.NewFunction(func(L *lua.LState) int {
+		ac.noDirListing = !L.ToBool(1)
+		if L.GetTop() >= 2 {
+			ac.dirListingHidden = L.ToBool(2)
+		}
+		return 0 // number of results
+	}))
+
+	// Use a Pongo2 template for directory listings, relative to the configuration script
+	L.SetGlobal("SetDirListingTemplate", L.NewFunction(func(L *lua.LState) int {
+		templateFilename := filepath.Join(filepath.Dir(filename), L.ToString(1))
+		if !ac.fs.Exists(templateFilename) {
+			log.Error("Could not find ", templateFilename)
+			L.Push(lua.LBool(false))
+			return 1 // number of results
+		}
+		ac.dirListingTemplate = templateFilename
+		L.Push(lua.LBool(true))
+		return 1 // number of results
+	}))
+
+	// Configure response compression. Takes a table with an optional list of
+	// encodings ("br" and/or "gzip", in order of preference), an optional
+	// minimum size in bytes and an optional list of content type prefixes.
+	// Can also take a bool, for enabling or disabling compression with the
+	// default settings.
+	L.SetGlobal("SetCompression", L.NewFunction(func(L *lua.LState) int {
+		if L.Get(1).Type() != lua.LTTable {
+			if L.ToBool(1) {
+				ac.compressionEncodings = []string{"br", "gzip"}
+			} else {
+				ac.compressionEncodings = nil
+			}
+			return 0 // number of results
+		}
+		settings := L.ToTable(1)
+		ac.compressionEncodings = []string{"br", "gzip"}
+		if encodings, ok := settings.RawGetString("encodings").(*lua.LTable); ok {
+			ac.compressionEncodings = []string{}
+			for _, encoding := range convert.Table2strings(encodings) {
+				switch encoding = strings.ToLower(encoding); encoding {
+				case "br", "gzip":
+					ac.compressionEncodings = append(ac.compressionEncodings, encoding)
+				default:
+					log.Error("Unsupported compression encoding: ", encoding)
+				}
+			}
+		}
+		if minsize, ok := settings.RawGetString("minsize").(lua.LNumber); ok {
+			ac.compressionMinSize = int(minsize)
+		}
+		if types, ok := settings.RawGetString("types").(*lua.LTable); ok {
+			ac.compressionTypes = convert.Table2strings(types)
+		}
+		return 0 // number of results
+	}))
+
+	// Set the IP addresses and CIDR ranges of proxies that are trusted to set
+	// the X-Forwarded-For header. Returns false if any of them are invalid.
+	L.SetGlobal("SetTrustedProxies", L.NewFunction(func(L *lua.LState) int {
+		networks, invalid := parseTrustedProxies(convert.Table2strings(L.CheckTable(1)))
+		for _, entry := range invalid {
+			log.Error("Invalid IP address or CIDR range: ", entry)
+		}
+		ac.trustedProxies = networks
+		L.Push(lua.LBool(len(invalid) == 0))
+		return 1 // number of results
+	}))
+
+	// Select how ETags are generated for static files: "strong", "weak" or "off"
+	L.SetGlobal("SetETag", L.NewFunction(func(L *lua.LState) int {
+		mode := strings.ToLower(L.ToString(1))
+		switch mode {
+		case etagStrong, etagWeak, etagOff:
+			ac.etagMode = mode
+			L.Push(lua.LBool(true))
+		default:
+			log.Error("Unknown ETag mode: ", mode)
+			L.Push(lua.LBool(false))
+		}
+		return 1 // number of results
+	}))
+
 	L.SetGlobal("ServerInfo", L.NewFunction(func(L *lua.LState) int {
 		// Return the string, but drop the final newline
 		L.Push(lua.LString(ac.Info()))
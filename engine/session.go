@@ -0,0 +1,287 @@
+package engine
+
+// Server-side sessions, for both anonymous and logged in users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/pinterface"
+)
+
+const (
+	// Identifier for the Session class in Lua
+	lSessionClass = "Session"
+
+	// The name of the cookie with the signed session ID
+	sessionCookieName = "flunix_session"
+
+	// The name of the hash map where the sessions are stored
+	sessionHashMapName = "flunix_sessions"
+
+	// The field that holds when a session expires, as an Unix timestamp
+	sessionExpiresField = "expires"
+
+	// The prefix for the fields that hold the session values
+	sessionValuePrefix = "value:"
+
+	// How long a session lasts after it was last changed, if not set with SetSessionTTL
+	defaultSessionTTL = 24 * time.Hour
+
+	// How often sessions that have expired are removed from the database
+	sessionSweepInterval = 10 * time.Minute
+)
+
+// For starting the removal of expired sessions once, when sessions are first used
+var sessionSweepOnce sync.Once
+
+// Session is a set of values that is stored on the server, for one visitor.
+// The session ID is stored in a signed cookie.
+type Session struct {
+	w      http.ResponseWriter
+	req    *http.Request
+	hm     pinterface.IHashMap
+	secret string
+	ttl    time.Duration
+	id     string // empty until the session has been loaded or created
+
+	// true when the cookie has been sent in the response to this request
+	cookieSent bool
+}
+
+// sessionKey derives the key that session IDs are signed with from the
+// cookie secret, so that values signed with setcookie can not be used as
+// session IDs
+func sessionKey(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(sessionCookieName))
+	return string(mac.Sum(nil))
+}
+
+// signSessionID returns the cookie value for the given session ID
+func signSessionID(id, secret string) string {
+	return signCookieValue(sessionCookieName, id, sessionKey(secret))
+}
+
+// verifySessionID returns the session ID from the given cookie value,
+// if the signature is valid
+func verifySessionID(value, secret string) (string, bool) {
+	id, ok := verifyCookieValue(sessionCookieName, value, sessionKey(secret))
+	return id, ok && id != ""
+}
+
+// NewSession returns the session for the given request, which is only
+// created when a value is set
+func NewSession(w http.ResponseWriter, req *http.Request, creator pinterface.ICreator, secret string, ttl time.Duration) (*Session, error) {
+	hm, err := creator.NewHashMap(sessionHashMapName)
+	if err != nil {
+		return nil, err
+	}
+	sessionSweepOnce.Do(func() {
+		go func() {
+			for range time.Tick(sessionSweepInterval) {
+				sweepSessions(hm)
+			}
+		}()
+	})
+	sess := &Session{w: w, req: req, hm: hm, secret: secret, ttl: ttl}
+	if cookie, err := req.Cookie(sessionCookieName); err == nil {
+		if id, ok := verifySessionID(cookie.Value, secret); ok && !sessionExpired(hm, id) {
+			sess.id = id
+		}
+	}
+	return sess, nil
+}
+
+// sessionExpired checks if the session with the given ID has expired, or
+// does not exist, and removes it if it has expired
+func sessionExpired(hm pinterface.IHashMap, id string) bool {
+	expires, err := hm.Get(id, sessionExpiresField)
+	if err != nil || expires == "" {
+		return true
+	}
+	unixtime, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unixtime {
+		hm.Del(id)
+		return true
+	}
+	return false
+}
+
+// sweepSessions removes the sessions that have expired, so that sessions
+// from visitors that never return do not fill up the database
+func sweepSessions(hm pinterface.IHashMap) {
+	ids, err := hm.All()
+	if err != nil {
+		log.Error("Could not list the sessions: ", err)
+		return
+	}
+	for _, id := range ids {
+		sessionExpired(hm, id)
+	}
+}
+
+// setCookie sets the cookie with the signed session ID, or removes the
+// cookie if maxAge is negative
+func (sess *Session) setCookie(value string, maxAge int) {
+	http.SetCookie(sess.w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   sess.req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Get returns the JSON encoded value for the given key, or an empty string
+func (sess *Session) Get(key string) (string, error) {
+	if sess.id == "" {
+		return "", nil
+	}
+	return sess.hm.Get(sess.id, sessionValuePrefix+key)
+}
+
+// Set sets the JSON encoded value for the given key. The session is created,
+// if needed, and lasts for the TTL from now. The cookie is only sent once
+// per request, when the session is first created or changed.
+func (sess *Session) Set(key, value string) error {
+	if sess.id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		sess.id = hex.EncodeToString(b)
+	}
+	expires := time.Now().Add(sess.ttl)
+	if err := sess.hm.Set(sess.id, sessionExpiresField, strconv.FormatInt(expires.Unix(), 10)); err != nil {
+		return err
+	}
+	if !sess.cookieSent {
+		sess.setCookie(signSessionID(sess.id, sess.secret), int(sess.ttl.Seconds()))
+		sess.cookieSent = true
+	}
+	return sess.hm.Set(sess.id, sessionValuePrefix+key, value)
+}
+
+// Destroy removes all the values in the session, and the cookie
+func (sess *Session) Destroy() error {
+	if sess.id == "" {
+		return nil
+	}
+	err := sess.hm.Del(sess.id)
+	sess.id = ""
+	sess.setCookie("", -1)
+	// A new session that is created after this needs a new cookie
+	sess.cookieSent = false
+	return err
+}
+
+// Get the first argument, "self", and cast it from userdata to a Session
+func checkSession(L *lua.LState) *Session {
+	ud := L.CheckUserData(1)
+	if sess, ok := ud.Value.(*Session); ok {
+		return sess
+	}
+	L.ArgError(1, "session expected")
+	return nil
+}
+
+// Takes a Session and a key. Returns the value, or nil.
+func sessionGet(L *lua.LState) int {
+	sess := checkSession(L) // arg 1
+	data, err := sess.Get(L.CheckString(2))
+	if err != nil || data == "" {
+		L.Push(lua.LNil)
+		return 1 // number of results
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		log.Error(err)
+		L.Push(lua.LNil)
+		return 1 // number of results
+	}
+	L.Push(convert.Interface2LValue(L, value))
+	return 1 // number of results
+}
+
+// Takes a Session, a key and a value. Returns true, or false and an error string.
+func sessionSet(L *lua.LState) int {
+	sess := checkSession(L) // arg 1
+	key := L.CheckString(2)
+	value, err := convert.LValue2Interface(L.CheckAny(3))
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(value); err == nil {
+			err = sess.Set(key, string(data))
+		}
+	}
+	if err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(lua.LTrue)
+	return 1 // number of results
+}
+
+// Takes a Session and removes all values in it, and the cookie
+func sessionDestroy(L *lua.LState) int {
+	sess := checkSession(L) // arg 1
+	if err := sess.Destroy(); err != nil {
+		log.Error(err)
+	}
+	return 0 // number of results
+}
+
+// The Session methods that are to be registered
+var sessionMethods = map[string]lua.LGFunction{
+	"get":     sessionGet,
+	"set":     sessionSet,
+	"destroy": sessionDestroy,
+}
+
+// LoadSessionFunctions makes the Session function available
+func (ac *Config) LoadSessionFunctions(w http.ResponseWriter, req *http.Request, L *lua.LState, userstate pinterface.IUserState) {
+
+	// Register the Session class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lSessionClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, sessionMethods)
+
+	// The session is shared by all calls to Session in the same request, so
+	// that the cookie is only sent once
+	var sess *Session
+
+	// Return the session for the current visitor. The session is created when
+	// a value is set. Returns nil and an error string on failure.
+	L.SetGlobal("Session", L.NewFunction(func(L *lua.LState) int {
+		if sess == nil {
+			var err error
+			sess, err = NewSession(w, req, userstate.Creator(), userstate.CookieSecret(), ac.sessionTTL)
+			if err != nil {
+				log.Error(err)
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2 // number of results
+			}
+		}
+		ud := L.NewUserData()
+		ud.Value = sess
+		L.SetMetatable(ud, L.GetTypeMetatable(lSessionClass))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSessionIDSignature(t *testing.T) {
+	id, ok := verifySessionID(signSessionID("abc123", "secret"), "secret")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, id, "abc123")
+
+	// A value signed with setcookie can not be used as a session ID
+	_, ok = verifySessionID(signCookieValue(sessionCookieName, "abc123", "secret"), "secret")
+	assert.Equal(t, ok, false)
+
+	// An empty session ID is never valid
+	_, ok = verifySessionID(signSessionID("", "secret"), "secret")
+	assert.Equal(t, ok, false)
+}
+
+// memHashMap is a hash map in memory, for testing sessions
+type memHashMap map[string]map[string]string
+
+func (hm memHashMap) Set(owner, key, value string) error {
+	if hm[owner] == nil {
+		hm[owner] = make(map[string]string)
+	}
+	hm[owner][key] = value
+	return nil
+}
+func (hm memHashMap) Get(owner, key string) (string, error) { return hm[owner][key], nil }
+func (hm memHashMap) Has(owner, key string) (bool, error) {
+	_, ok := hm[owner][key]
+	return ok, nil
+}
+func (hm memHashMap) Exists(owner string) (bool, error)   { return hm[owner] != nil, nil }
+func (hm memHashMap) All() ([]string, error)              { return nil, nil }
+func (hm memHashMap) Keys(owner string) ([]string, error) { return nil, nil }
+func (hm memHashMap) DelKey(owner, key string) error {
+	delete(hm[owner], key)
+	return nil
+}
+func (hm memHashMap) Del(key string) error {
+	delete(hm, key)
+	return nil
+}
+func (hm memHashMap) Remove() error { return nil }
+func (hm memHashMap) Clear() error  { return nil }
+
+func TestSessionCookieOnce(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	sess := &Session{w: w, req: req, hm: make(memHashMap), secret: "secret", ttl: time.Hour}
+
+	// The cookie is only sent when the session is created
+	assert.Equal(t, sess.Set("a", "1"), nil)
+	assert.Equal(t, sess.Set("b", "2"), nil)
+	assert.Equal(t, len(w.Header()["Set-Cookie"]), 1)
+
+	// A session that is created after being destroyed gets a new cookie
+	assert.Equal(t, sess.Destroy(), nil)
+	assert.Equal(t, sess.Set("a", "1"), nil)
+	assert.Equal(t, len(w.Header()["Set-Cookie"]), 3)
+}
@@ -0,0 +1,240 @@
+package engine
+
+// Generating sitemap.xml from the served files
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The default URL path for the sitemap, for EnableSitemap
+	defaultSitemapPath = "/sitemap.xml"
+
+	// How long the list of pages in the sitemap is kept before the server
+	// directory is walked again
+	sitemapCacheDuration = time.Minute
+)
+
+// sitemapExtensions are the extensions of the files that are listed in the
+// sitemap, when they are not index files
+var sitemapExtensions = map[string]bool{
+	".html":     true,
+	".htm":      true,
+	".md":       true,
+	".markdown": true,
+	".lua":      true,
+	".po2":      true,
+	".pongo2":   true,
+	".tmpl":     true,
+	".amber":    true,
+}
+
+// SitemapPriority is a priority for the URL paths that match a pattern,
+// as set with SetSitemapPriority
+type SitemapPriority struct {
+	Pattern  string
+	Priority float64
+}
+
+// SitemapURL is an URL in a sitemap
+type SitemapURL struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod"`
+	Priority string `xml:"priority,omitempty"`
+}
+
+// Sitemap is the root element of a sitemap
+type Sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+// sitemapCache keeps the URL paths and modification times for the sitemap
+// for a short while, so that the server directory is not walked for every
+// request to the sitemap
+type sitemapCache struct {
+	mut       sync.Mutex
+	modtimes  map[string]time.Time
+	generated time.Time
+}
+
+// validHost checks if the given host from a request, with an optional port,
+// only contains characters that are valid in a host name or IP address
+func validHost(host string) bool {
+	if host == "" || strings.HasPrefix(host, ".") || strings.HasPrefix(host, "-") {
+		return false
+	}
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '-', r == ':', r == '[', r == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestBaseURL returns the scheme and host that the URLs in the sitemap
+// start with. The base URL given to EnableSitemap is used, if set. Otherwise
+// the host from the request is used, if it is valid.
+func (ac *Config) requestBaseURL(req *http.Request) (string, bool) {
+	if ac.sitemapBaseURL != "" {
+		return ac.sitemapBaseURL, true
+	}
+	if !validHost(req.Host) {
+		return "", false
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host, true
+}
+
+// sitemapURLPaths returns the URL paths for the sitemap, from the cache if
+// they were found recently
+func (ac *Config) sitemapURLPaths() (map[string]time.Time, error) {
+	cache := ac.sitemapCache
+	if cache == nil {
+		return ac.SitemapURLPaths(ac.serverDirOrFilename)
+	}
+	cache.mut.Lock()
+	defer cache.mut.Unlock()
+	if cache.modtimes != nil && time.Since(cache.generated) < sitemapCacheDuration {
+		return cache.modtimes, nil
+	}
+	modtimes, err := ac.SitemapURLPaths(ac.serverDirOrFilename)
+	if err != nil {
+		return nil, err
+	}
+	cache.modtimes = modtimes
+	cache.generated = time.Now()
+	return modtimes, nil
+}
+
+// sitemapPriority returns the priority for the first pattern that matches
+// the given URL path, formatted for the sitemap, or an empty string
+func (ac *Config) sitemapPriority(urlpath string) string {
+	for _, sp := range ac.sitemapPriorities {
+		if matched, _ := path.Match(sp.Pattern, urlpath); matched || strings.HasPrefix(urlpath, sp.Pattern) {
+			return strconv.FormatFloat(sp.Priority, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// sitemapPublic checks if the given URL path can be visited without logging in
+func (ac *Config) sitemapPublic(urlpath string) bool {
+	if ac.perm == nil {
+		return true
+	}
+	req, err := http.NewRequest(http.MethodGet, urlpath, nil)
+	if err != nil {
+		return false
+	}
+	return !ac.perm.Rejected(httptest.NewRecorder(), req)
+}
+
+// SitemapURLPaths walks the given directory and returns the URL paths of the
+// served pages, together with when they were last modified. Hidden files,
+// data.lua, serverconf.lua and the URL paths that require a login are skipped.
+func (ac *Config) SitemapURLPaths(rootdir string) (map[string]time.Time, error) {
+	indexFiles := make(map[string]bool)
+	for _, indexfile := range ac.IndexFilenames() {
+		indexFiles[indexfile] = true
+	}
+	modtimes := make(map[string]time.Time)
+	err := filepath.Walk(rootdir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filename == rootdir && !fi.IsDir() {
+			// A single file is served at "/"
+			modtimes["/"] = fi.ModTime()
+			return nil
+		}
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") && filename != rootdir {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || name == ac.defaultLuaDataFilename || name == "serverconf.lua" {
+			return nil
+		}
+		rel, err := filepath.Rel(rootdir, filename)
+		if err != nil {
+			return err
+		}
+		urlpath := "/" + filepath.ToSlash(rel)
+		switch {
+		case indexFiles[name]:
+			urlpath = strings.TrimSuffix(urlpath, name)
+		case !sitemapExtensions[strings.ToLower(filepath.Ext(name))]:
+			return nil
+		}
+		if !ac.sitemapPublic(urlpath) {
+			return nil
+		}
+		// If there are several index files, use the most recent modification time
+		if modtime, ok := modtimes[urlpath]; !ok || fi.ModTime().After(modtime) {
+			modtimes[urlpath] = fi.ModTime()
+		}
+		return nil
+	})
+	return modtimes, err
+}
+
+// SitemapHandler serves a sitemap of the pages in the server directory.
+// The pages are found again at most once a minute.
+func (ac *Config) SitemapHandler(w http.ResponseWriter, req *http.Request) {
+	baseURL, ok := ac.requestBaseURL(req)
+	if !ok {
+		http.Error(w, "Invalid host", http.StatusBadRequest)
+		return
+	}
+	modtimes, err := ac.sitemapURLPaths()
+	if err != nil {
+		log.Errorf("Could not generate the sitemap: %s", err)
+		http.Error(w, "Could not generate the sitemap", http.StatusInternalServerError)
+		return
+	}
+	urlpaths := make([]string, 0, len(modtimes))
+	for urlpath := range modtimes {
+		urlpaths = append(urlpaths, urlpath)
+	}
+	sort.Strings(urlpaths)
+
+	sitemap := Sitemap{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, urlpath := range urlpaths {
+		sitemap.URLs = append(sitemap.URLs, SitemapURL{
+			Loc:      baseURL + urlpath,
+			LastMod:  modtimes[urlpath].UTC().Format(time.RFC3339),
+			Priority: ac.sitemapPriority(urlpath),
+		})
+	}
+	data, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		log.Errorf("Could not generate the sitemap: %s", err)
+		http.Error(w, "Could not generate the sitemap", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml;charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SPAFallback is a file that is served for all GET requests below the URL
+// path prefix that do not match a file or a directory, so that client side
+// routing in single-page applications works
+type SPAFallback struct {
+	Prefix   string   // URL path prefix, like "/"
+	Filename string   // The file that is served, like "index.html"
+	Excludes []string // URL path prefixes that are not handled, like "/api"
+}
+
+// spaFallback finds the SPA fallback with the longest matching prefix for the
+// given request, if any
+func (ac *Config) spaFallback(req *http.Request) *SPAFallback {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil
+	}
+	var found *SPAFallback
+	for i, fallback := range ac.spaFallbacks {
+		if !strings.HasPrefix(req.URL.Path, fallback.Prefix) {
+			continue
+		}
+		if found != nil && len(found.Prefix) >= len(fallback.Prefix) {
+			continue
+		}
+		if hasPrefixIn(req.URL.Path, fallback.Excludes) {
+			continue
+		}
+		found = &ac.spaFallbacks[i]
+	}
+	return found
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/pongo2"
+)
+
+// SetTemplateGlobal makes a value available in all Pongo2 templates
+func (ac *Config) SetTemplateGlobal(name string, value interface{}) {
+	ac.templateMutex.Lock()
+	defer ac.templateMutex.Unlock()
+	if ac.templateGlobals == nil {
+		ac.templateGlobals = make(map[string]interface{})
+	}
+	ac.templateGlobals[name] = value
+}
+
+// RegisterTemplateFunction makes a Lua function available in all Pongo2 templates
+func (ac *Config) RegisterTemplateFunction(name string, luaFunc *ConfigFunction) {
+	ac.templateMutex.Lock()
+	defer ac.templateMutex.Unlock()
+	if ac.templateFuncs == nil {
+		ac.templateFuncs = make(map[string]*ConfigFunction)
+	}
+	ac.templateFuncs[name] = luaFunc
+}
+
+// callTemplateFunction calls a Lua function from the configuration script
+// with the given Pongo2 arguments, and returns the first returned value
+func (ac *Config) callTemplateFunction(req *http.Request, name string, luaFunc *ConfigFunction, args []*pongo2.Value) *pongo2.Value {
+	luaArgs := make([]lua.LValue, len(args))
+	for i, arg := range args {
+		luaArgs[i] = convert.Interface2LValue(luaFunc.L, arg.Interface())
+	}
+	result, err := ac.callConfigFunctionFor(req, luaFunc, luaArgs...)
+	if err != nil {
+		log.Error("Template function " + name + " failed: " + err.Error())
+		return pongo2.AsValue("")
+	}
+	value, err := convert.LValue2Interface(result)
+	if err != nil {
+		log.Error("Template function " + name + " returned an invalid value: " + err.Error())
+		return pongo2.AsValue("")
+	}
+	return pongo2.AsValue(value)
+}
+
+// templateContext returns the values and functions that are available in all
+// Pongo2 templates, as set with SetTemplateGlobal and RegisterTemplateFunction.
+// If translations have been loaded, t() is also available, for the locale of
+// the given request.
+func (ac *Config) templateContext(req *http.Request) pongo2.Context {
+	ac.templateMutex.Lock()
+	defer ac.templateMutex.Unlock()
+	ctx := make(pongo2.Context, len(ac.templateGlobals)+len(ac.templateFuncs))
+	for name, value := range ac.templateGlobals {
+		ctx[name] = value
+	}
+	for name, luaFunc := range ac.templateFuncs {
+		name, luaFunc := name, luaFunc
+		ctx[name] = func(args ...*pongo2.Value) *pongo2.Value {
+			return ac.callTemplateFunction(req, name, luaFunc, args)
+		}
+	}
+	if ac.translations != nil && req != nil {
+		ctx["t"] = func(key string, args ...*pongo2.Value) string {
+			return ac.translate(req, key, "", templateArgs(args))
+		}
+	}
+	return ctx
+}
+
+// templateArgs converts arguments to t() in a template to a map. A map argument
+// is used as it is, other arguments are available as "{1}", "{2}" and so on.
+func templateArgs(args []*pongo2.Value) map[string]string {
+	m := make(map[string]string)
+	for i, arg := range args {
+		if arg.IsNil() {
+			continue
+		}
+		if values, ok := arg.Interface().(map[string]interface{}); ok {
+			for key, value := range values {
+				m[key] = pongo2.AsValue(value).String()
+			}
+			continue
+		}
+		m[strconv.Itoa(i+1)] = arg.String()
+	}
+	return m
+}
@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/themes"
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/recwatch"
+)
+
+const (
+	// Identifier for the RequestContext class in Lua
+	lRequestContextClass = "RequestContext"
+)
+
+// handlerTimeout returns the handler timeout for the given URL path.
+// The longest matching prefix override is used, if any.
+// Returns 0 if there is no timeout.
+func (ac *Config) handlerTimeout(urlPath string) time.Duration {
+	timeout := ac.handlerTimeoutDuration
+	longest := -1
+	for prefix, prefixTimeout := range ac.handlerTimeoutPrefixes {
+		if ac.hasPathPrefix(urlPath, prefix) && len(prefix) > longest {
+			timeout = prefixTimeout
+			longest = len(prefix)
+		}
+	}
+	return timeout
+}
+
+// timeoutWriter is a http.ResponseWriter that stops writing to the wrapped
+// http.ResponseWriter when the handler has timed out
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	mut         sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+// Header returns the headers of the handler, which are sent when the status
+// code is written
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// writeHeader must be called with the mutex locked
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.w.Header()
+	for key, values := range tw.header {
+		dst[key] = values
+	}
+	tw.w.WriteHeader(code)
+}
+
+// WriteHeader writes the status code, unless the handler has timed out
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mut.Lock()
+	defer tw.mut.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(code)
+}
+
+// Write writes data, unless the handler has timed out
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mut.Lock()
+	defer tw.mut.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(data)
+}
+
+// Flush sends the data that has been written so far to the client
+func (tw *timeoutWriter) Flush() {
+	tw.mut.Lock()
+	defer tw.mut.Unlock()
+	if tw.timedOut {
+		return
+	}
+	recwatch.Flush(tw.w)
+}
+
+// finish sends the headers and the status code, if the handler returned
+// without writing anything
+func (tw *timeoutWriter) finish() {
+	tw.mut.Lock()
+	defer tw.mut.Unlock()
+	tw.writeHeader(http.StatusOK)
+}
+
+// timeout marks the handler as timed out. Returns true if nothing has been
+// written yet, so that an error page can be written instead.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mut.Lock()
+	defer tw.mut.Unlock()
+	tw.timedOut = true
+	return !tw.wroteHeader
+}
+
+// timeoutHandler cancels the request context of handlers that take longer
+// than the timeout set with SetHandlerTimeout. If nothing has been written
+// yet, "503 Service Unavailable" is returned. Unlike http.TimeoutHandler,
+// the output is not buffered, so that flushing still works.
+// WebSocket requests are not affected.
+func (ac *Config) timeoutHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		timeout := ac.handlerTimeout(req.URL.Path)
+		if timeout <= 0 || isWebSocketRequest(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+
+		tw := &timeoutWriter{w: w, header: make(http.Header)}
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panicChan <- r
+				}
+			}()
+			next.ServeHTTP(tw, req)
+			close(done)
+		}()
+		select {
+		case r := <-panicChan:
+			panic(r)
+		case <-done:
+			tw.finish()
+		case <-ctx.Done():
+			if tw.timeout() {
+				page := themes.MessagePage("Timeout", "<div style='color:red'>The request took too long to handle.</div>", ac.defaultTheme)
+				w.Header().Set("Content-Type", "text/html;charset=utf-8")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(page))
+				ac.LogAccess(req, http.StatusServiceUnavailable, int64(len(page)))
+			}
+			log.Warn("Handler for " + req.URL.Path + " timed out")
+		}
+	})
+}
+
+// Get the first argument, "self", and cast it from userdata to a context
+func checkRequestContext(L *lua.LState) context.Context {
+	ud := L.CheckUserData(1)
+	if ctx, ok := ud.Value.(context.Context); ok {
+		return ctx
+	}
+	L.ArgError(1, "request context expected")
+	return nil
+}
+
+// The RequestContext methods that are to be registered
+var requestContextMethods = map[string]lua.LGFunction{
+	// Return true if the request has been cancelled, or has timed out
+	"done": func(L *lua.LState) int {
+		ctx := checkRequestContext(L) // arg 1
+		L.Push(lua.LBool(ctx.Err() != nil))
+		return 1 // number of results
+	},
+	// Return "canceled" or "timeout", or an empty string if the request is still active
+	"err": func(L *lua.LState) int {
+		ctx := checkRequestContext(L) // arg 1
+		switch ctx.Err() {
+		case context.Canceled:
+			L.Push(lua.LString("canceled"))
+		case context.DeadlineExceeded:
+			L.Push(lua.LString("timeout"))
+		default:
+			L.Push(lua.LString(""))
+		}
+		return 1 // number of results
+	},
+	// Return the number of seconds until the request times out, or -1 if there is no timeout
+	"remaining": func(L *lua.LState) int {
+		ctx := checkRequestContext(L) // arg 1
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			L.Push(lua.LNumber(-1))
+			return 1 // number of results
+		}
+		remaining := time.Until(deadline).Seconds()
+		if remaining < 0 {
+			remaining = 0
+		}
+		L.Push(lua.LNumber(remaining))
+		return 1 // number of results
+	},
+}
+
+// LoadRequestContextFunctions makes the reqcontext function available
+func (ac *Config) LoadRequestContextFunctions(L *lua.LState, req *http.Request) {
+
+	// Register the RequestContext class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lRequestContextClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, requestContextMethods)
+
+	// Return the context of the current request
+	L.SetGlobal("reqcontext", L.NewFunction(func(L *lua.LState) int {
+		ud := L.NewUserData()
+		ud.Value = req.Context()
+		L.SetMetatable(ud, L.GetTypeMetatable(lRequestContextClass))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
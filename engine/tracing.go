@@ -0,0 +1,325 @@
+package engine
+
+// Tracing requests with W3C Trace Context and exporting the spans to an
+// OpenTelemetry collector, with OTLP over HTTP, encoded as JSON
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The URL path that spans are sent to, if the endpoint has no path
+	otlpTracesPath = "/v1/traces"
+
+	// How many spans that may be waiting to be exported. Spans are dropped
+	// if the collector can not keep up.
+	traceQueueSize = 2048
+
+	// The maximum number of spans in one export
+	traceBatchSize = 256
+
+	// How often the spans are exported
+	traceExportInterval = 5 * time.Second
+)
+
+// traceSpanKey is the context key for the span of the current request
+type traceSpanKey struct{}
+
+// Span is the time spent on one request
+type Span struct {
+	TraceID      string // 32 hex digits
+	SpanID       string // 16 hex digits
+	ParentSpanID string // 16 hex digits, or empty
+	Flags        string // 2 hex digits
+	TraceState   string // the incoming tracestate header, if any
+	Name         string
+	Method       string
+	Path         string
+	ClientIP     string
+	Status       int
+	Start        time.Time
+	End          time.Time
+}
+
+// Traceparent returns the traceparent header for requests that are made
+// while handling the request that this span is for
+func (span *Span) Traceparent() string {
+	return "00-" + span.TraceID + "-" + span.SpanID + "-" + span.Flags
+}
+
+// Tracer records spans and exports them to an OpenTelemetry collector
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	spans       chan *Span
+	client      *http.Client
+}
+
+// NewTracer creates a new Tracer that sends spans to the given OTLP/HTTP
+// endpoint, like "http://localhost:4318", until the given channel is closed
+func NewTracer(endpoint, serviceName string, stop chan struct{}) (*Tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("the tracing endpoint must be a http or https URL: %s", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	tracer := &Tracer{
+		endpoint:    u.String(),
+		serviceName: serviceName,
+		spans:       make(chan *Span, traceQueueSize),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	go tracer.run(stop)
+	return tracer, nil
+}
+
+// randomHex returns n random bytes as hex digits
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validHex checks if the given string is the given number of lowercase hex
+// digits, and not only zeros
+func validHex(s string, length int) bool {
+	if len(s) != length || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent returns the trace ID, the parent span ID and the flags
+// from the given traceparent header
+func parseTraceparent(header string) (traceID, parentID, flags string, ok bool) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || len(fields[3]) != 2 {
+		return "", "", "", false
+	}
+	if !validHex(fields[1], 32) || !validHex(fields[2], 16) {
+		return "", "", "", false
+	}
+	return fields[1], fields[2], fields[3], true
+}
+
+// StartSpan creates a span for the given request. If the request has a valid
+// traceparent header, the span is a part of that trace.
+func (tracer *Tracer) StartSpan(req *http.Request) *Span {
+	span := &Span{
+		SpanID: randomHex(8),
+		Name:   req.Method + " " + req.URL.Path,
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Start:  time.Now(),
+	}
+	if traceID, parentID, flags, ok := parseTraceparent(req.Header.Get("traceparent")); ok {
+		span.TraceID = traceID
+		span.ParentSpanID = parentID
+		span.Flags = flags
+		span.TraceState = req.Header.Get("tracestate")
+	} else {
+		span.TraceID = randomHex(16)
+		span.Flags = "01" // sampled
+	}
+	return span
+}
+
+// EndSpan records the given span, with the given HTTP status code.
+// The span is dropped if there are too many spans waiting to be exported.
+func (tracer *Tracer) EndSpan(span *Span, status int) {
+	span.End = time.Now()
+	span.Status = status
+	select {
+	case tracer.spans <- span:
+	default:
+	}
+}
+
+// run exports the recorded spans in batches, until the given channel is closed
+func (tracer *Tracer) run(stop chan struct{}) {
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := tracer.export(batch); err != nil {
+			log.Warnf("Could not export %d spans to %s: %s", len(batch), tracer.endpoint, err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case span := <-tracer.spans:
+			batch = append(batch, span)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			// Export the spans that are waiting, then stop
+			for {
+				select {
+				case span := <-tracer.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// otlpAttribute is a key/value in the OTLP JSON encoding
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+// stringAttribute returns an OTLP attribute with a string value
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+// intAttribute returns an OTLP attribute with an integer value
+func intAttribute(key string, value int) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"intValue": strconv.Itoa(value)}}
+}
+
+// otlpSpan is a span in the OTLP JSON encoding
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            map[string]int  `json:"status"`
+}
+
+// export sends the given spans to the collector
+func (tracer *Tracer) export(spans []*Span) error {
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		// Only server errors are errors, according to the semantic conventions
+		statusCode := 0 // unset
+		if span.Status >= 500 {
+			statusCode = 2 // error
+		}
+		encoded[i] = otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			TraceState:        span.TraceState,
+			Name:              span.Name,
+			Kind:              2, // server
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes: []otlpAttribute{
+				stringAttribute("http.request.method", span.Method),
+				stringAttribute("url.path", span.Path),
+				stringAttribute("client.address", span.ClientIP),
+				intAttribute("http.response.status_code", span.Status),
+			},
+			Status: map[string]int{"code": statusCode},
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{stringAttribute("service.name", tracer.serviceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "flunix"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := tracer.client.Post(tracer.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// requestSpan returns the span for the given request, or nil
+func requestSpan(req *http.Request) *Span {
+	if req == nil {
+		return nil
+	}
+	span, _ := req.Context().Value(traceSpanKey{}).(*Span)
+	return span
+}
+
+// traceHeaders returns the headers that propagate the trace of the given
+// request to outgoing requests, or nil if the request is not traced
+func traceHeaders(req *http.Request) map[string]string {
+	span := requestSpan(req)
+	if span == nil {
+		return nil
+	}
+	headers := map[string]string{"traceparent": span.Traceparent()}
+	if span.TraceState != "" {
+		headers["tracestate"] = span.TraceState
+	}
+	return headers
+}
+
+// tracingHandler creates a span for each request, if tracing has been enabled
+// with EnableTracing
+func (ac *Config) tracingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tracer := ac.tracer
+		if tracer == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		span := tracer.StartSpan(req)
+		span.ClientIP = ac.ClientIP(req)
+		req = req.WithContext(context.WithValue(req.Context(), traceSpanKey{}, span))
+		sw := NewStatusWriter(w)
+		defer func() {
+			tracer.EndSpan(span, sw.Status())
+		}()
+		next.ServeHTTP(sw, req)
+	})
+}
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		header                   string
+		traceID, parentID, flags string
+		ok                       bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "01", true},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "01", true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", "", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", "", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", "", "", false},
+		{"00-4bf92f3577b34da6-00f067aa0ba902b7-01", "", "", "", false},
+		{"", "", "", "", false},
+	} {
+		traceID, parentID, flags, ok := parseTraceparent(tc.header)
+		assert.Equal(t, ok, tc.ok)
+		assert.Equal(t, traceID, tc.traceID)
+		assert.Equal(t, parentID, tc.parentID)
+		assert.Equal(t, flags, tc.flags)
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+// How URL paths with and without a trailing slash are handled
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The modes for SetTrailingSlash
+const (
+	// Serve files and routes for both "/foo" and "/foo/", and redirect
+	// directories to "/foo/", which is the default
+	trailingSlashIgnore = "ignore"
+
+	// Redirect to the canonical URL path, which ends with a slash for
+	// directories and routes with patterns that end with a slash
+	trailingSlashRedirect = "redirect"
+
+	// Only serve the canonical URL path, "404 Not Found" for the other one
+	trailingSlashStrict = "strict"
+)
+
+// trailingSlashModes are the valid modes for SetTrailingSlash
+var trailingSlashModes = map[string]bool{
+	trailingSlashIgnore:   true,
+	trailingSlashRedirect: true,
+	trailingSlashStrict:   true,
+}
+
+// trailingSlashMismatch checks if the given URL path has a trailing slash
+// when it should not have one, or the other way around. "/" always matches.
+func trailingSlashMismatch(urlpath string, wantSlash bool) bool {
+	if urlpath == "/" {
+		return false
+	}
+	return strings.HasSuffix(urlpath, "/") != wantSlash
+}
+
+// redirectTrailingSlash redirects to the URL path with or without a trailing
+// slash, if SetTrailingSlash("redirect") is used and the URL path is not
+// already in that form. Returns true if the request was redirected.
+func (ac *Config) redirectTrailingSlash(w http.ResponseWriter, req *http.Request, wantSlash bool) bool {
+	urlpath := req.URL.Path
+	if ac.trailingSlash != trailingSlashRedirect || !trailingSlashMismatch(urlpath, wantSlash) {
+		return false
+	}
+	if wantSlash {
+		urlpath += "/"
+	} else {
+		urlpath = strings.TrimRight(urlpath, "/")
+	}
+	// Redirect to a relative URL path, to avoid open redirects for "//host"
+	if strings.HasPrefix(urlpath, "//") {
+		urlpath = "/" + strings.TrimLeft(urlpath, "/")
+	}
+	location := (&url.URL{Path: urlpath, RawQuery: req.URL.RawQuery}).String()
+	// Keep the method and body for other requests than GET and HEAD
+	status := http.StatusMovedPermanently
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, req, location, status)
+	ac.LogAccess(req, status, 0)
+	return true
+}
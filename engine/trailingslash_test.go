@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestTrailingSlashMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		urlpath   string
+		wantSlash bool
+		mismatch  bool
+	}{
+		{"/", true, false},
+		{"/", false, false},
+		{"/docs", true, true},
+		{"/docs/", true, false},
+		{"/docs", false, false},
+		{"/docs/", false, true},
+	} {
+		assert.Equal(t, trailingSlashMismatch(tc.urlpath, tc.wantSlash), tc.mismatch)
+	}
+}
+
+func TestRedirectTrailingSlash(t *testing.T) {
+	for _, tc := range []struct {
+		mode, method, target string
+		wantSlash            bool
+		status               int
+		location             string
+	}{
+		{trailingSlashRedirect, "GET", "/docs", true, http.StatusMovedPermanently, "/docs/"},
+		{trailingSlashRedirect, "GET", "/docs/?q=1", false, http.StatusMovedPermanently, "/docs?q=1"},
+		{trailingSlashRedirect, "POST", "/docs", true, http.StatusPermanentRedirect, "/docs/"},
+		{trailingSlashRedirect, "GET", "//example.com/", false, http.StatusMovedPermanently, "/example.com"},
+		{trailingSlashRedirect, "GET", "/docs/", true, http.StatusOK, ""},
+		{trailingSlashIgnore, "GET", "/docs", true, http.StatusOK, ""},
+		{trailingSlashStrict, "GET", "/docs", true, http.StatusOK, ""},
+	} {
+		ac := &Config{trailingSlash: tc.mode}
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		w := httptest.NewRecorder()
+		redirected := ac.redirectTrailingSlash(w, req, tc.wantSlash)
+		assert.Equal(t, redirected, tc.location != "")
+		assert.Equal(t, w.Code, tc.status)
+		assert.Equal(t, w.Header().Get("Location"), tc.location)
+	}
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/sheepcounter"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVConfig is an URL path prefix and the directory that should be served
+// over WebDAV at that prefix. If Admin is true, only administrators have
+// access, otherwise all logged in users have access.
+type WebDAVConfig struct {
+	Prefix string
+	Dir    string
+	Admin  bool
+}
+
+// webDAVReadMethods are the WebDAV methods that do not change any files
+var webDAVReadMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// RegisterWebDAV serves the given directory over WebDAV, at the given URL path
+// prefix. Files can be listed, read, written, moved and removed. Locks are
+// kept in memory. The prefix is registered as a user prefix, or as an admin
+// prefix if admin is true, so that only logged in users can reach it. Without
+// a permission system, files can only be listed and read.
+func (ac *Config) RegisterWebDAV(mux *http.ServeMux, prefix, dir string, admin bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if ac.perm != nil {
+		ac.addPermissionPrefix(prefix, admin)
+	}
+	dav := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(req *http.Request, err error) {
+			if err != nil {
+				log.Errorf("WebDAV %s %s: %s", req.Method, req.URL.Path, err)
+			}
+		},
+	}
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		// Rejecting requests is handled by the permission system
+		if ac.perm != nil && ac.perm.Rejected(w, req) {
+			sc := sheepcounter.New(w)
+			ac.perm.DenyFunction()(sc, req)
+			ac.LogAccess(req, http.StatusForbidden, sc.Counter())
+			return
+		}
+		// Nobody can be logged in, so nobody may change the files
+		if ac.perm == nil && !webDAVReadMethods[req.Method] {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			ac.LogAccess(req, http.StatusForbidden, int64(len("Forbidden\n")))
+			return
+		}
+		// Prepare to record the status code and count bytes written
+		sw := NewStatusWriter(w)
+		sc := sheepcounter.New(sw)
+		dav.ServeHTTP(sc, req)
+		ac.LogAccess(req, sw.Status(), sc.Counter())
+	}
+
+	mux.HandleFunc(prefix+"/", handler)
+	if prefix != "" {
+		// Also handle the prefix itself, without redirecting
+		mux.HandleFunc(prefix, handler)
+	}
+}
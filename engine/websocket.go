@@ -0,0 +1,205 @@
+package engine
+
+// WebSocket connections for Lua handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// Identifier for the WebSocket class in Lua
+	lWebSocketClass = "WebSocket"
+
+	// How long a write to a WebSocket may take
+	webSocketWriteTimeout = 10 * time.Second
+)
+
+var errNotWebSocket = errors.New("not a WebSocket request")
+
+// webSocketKey is the context key for the WebSocket state of a request
+type webSocketKey struct{}
+
+// webSocketState keeps the original http.ResponseWriter, which is needed for
+// taking over the connection, and the WebSocket connection, once upgraded
+type webSocketState struct {
+	w    http.ResponseWriter
+	conn *WebSocketConn
+}
+
+// WebSocketConn is a WebSocket connection that can be written to from
+// several goroutines
+type WebSocketConn struct {
+	conn     *websocket.Conn
+	mut      sync.Mutex
+	username string
+	hubs     map[*Hub]bool
+	closed   bool
+}
+
+// Send writes a text message to the WebSocket connection
+func (wc *WebSocketConn) Send(message string) error {
+	wc.mut.Lock()
+	defer wc.mut.Unlock()
+	if wc.closed {
+		return websocket.ErrCloseSent
+	}
+	wc.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	return wc.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
+
+// Receive waits for the next message. Returns an error when the connection is closed.
+func (wc *WebSocketConn) Receive() (string, error) {
+	_, data, err := wc.conn.ReadMessage()
+	return string(data), err
+}
+
+// Close leaves all hubs and closes the connection. Calling Close more than once is fine.
+func (wc *WebSocketConn) Close() error {
+	wc.mut.Lock()
+	if wc.closed {
+		wc.mut.Unlock()
+		return nil
+	}
+	wc.closed = true
+	hubs := wc.hubs
+	wc.hubs = nil
+	wc.mut.Unlock()
+	for hub := range hubs {
+		hub.Leave(wc)
+	}
+	return wc.conn.Close()
+}
+
+// isWebSocketRequest checks if the client asks to upgrade to a WebSocket connection
+func isWebSocketRequest(req *http.Request) bool {
+	return strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade") &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// webSocketHandler keeps the http.ResponseWriter for WebSocket requests, so
+// that Lua handlers can upgrade the connection even if the output is
+// buffered, and closes the WebSocket connection when the handler is done
+func (ac *Config) webSocketHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isWebSocketRequest(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		state := &webSocketState{w: w}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), webSocketKey{}, state)))
+		if state.conn != nil {
+			state.conn.Close()
+		}
+	})
+}
+
+// webSocketUpgraded checks if the connection for the given request has
+// been taken over by a WebSocket connection
+func webSocketUpgraded(req *http.Request) bool {
+	state, ok := req.Context().Value(webSocketKey{}).(*webSocketState)
+	return ok && state.conn != nil
+}
+
+// upgradeWebSocket upgrades the connection for the given request, once
+func (ac *Config) upgradeWebSocket(req *http.Request) (*WebSocketConn, error) {
+	state, ok := req.Context().Value(webSocketKey{}).(*webSocketState)
+	if !ok {
+		return nil, errNotWebSocket
+	}
+	if state.conn != nil {
+		return state.conn, nil
+	}
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(state.w, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	state.conn = &WebSocketConn{conn: conn, hubs: make(map[*Hub]bool)}
+	if ac.perm != nil {
+		state.conn.username = ac.perm.UserState().Username(req)
+	}
+	return state.conn, nil
+}
+
+// Get the first argument, "self", and cast it from userdata to a WebSocket connection
+func checkWebSocket(L *lua.LState) *WebSocketConn {
+	ud := L.CheckUserData(1)
+	if wc, ok := ud.Value.(*WebSocketConn); ok {
+		return wc
+	}
+	L.ArgError(1, "WebSocket expected")
+	return nil
+}
+
+// Takes a WebSocket and a message. Returns true if the message was sent.
+func webSocketSend(L *lua.LState) int {
+	wc := checkWebSocket(L) // arg 1
+	err := wc.Send(L.CheckString(2))
+	L.Push(lua.LBool(err == nil))
+	return 1 // number of results
+}
+
+// Takes a WebSocket. Waits for and returns the next message, or nil if the
+// connection has been closed.
+func webSocketReceive(L *lua.LState) int {
+	wc := checkWebSocket(L) // arg 1
+	message, err := wc.Receive()
+	if err != nil {
+		L.Push(lua.LNil)
+		return 1 // number of results
+	}
+	L.Push(lua.LString(message))
+	return 1 // number of results
+}
+
+// Takes a WebSocket and closes the connection
+func webSocketClose(L *lua.LState) int {
+	wc := checkWebSocket(L) // arg 1
+	if err := wc.Close(); err != nil {
+		log.Error(err)
+	}
+	return 0 // number of results
+}
+
+// The WebSocket methods that are to be registered
+var webSocketMethods = map[string]lua.LGFunction{
+	"send":    webSocketSend,
+	"receive": webSocketReceive,
+	"close":   webSocketClose,
+}
+
+// LoadWebSocketFunctions makes functions for WebSocket connections and hubs available
+func (ac *Config) LoadWebSocketFunctions(L *lua.LState, req *http.Request) {
+
+	// Register the WebSocket class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lWebSocketClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, webSocketMethods)
+
+	// Upgrade the current request to a WebSocket connection.
+	// Returns nil and an error message if that is not possible.
+	L.SetGlobal("WebSocket", L.NewFunction(func(L *lua.LState) int {
+		wc, err := ac.upgradeWebSocket(req)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		ud := L.NewUserData()
+		ud.Value = wc
+		L.SetMetatable(ud, L.GetTypeMetatable(lWebSocketClass))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+	ac.LoadHubFunctions(L, req)
+}
@@ -0,0 +1,83 @@
+package engine
+
+// Background workers for job queues, as configured with Worker in the server configuration script
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/datastruct"
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// How long a worker waits for a job before checking if the server is shutting down
+	workerPopTimeout = time.Second
+
+	// The delay before the first retry of a failed job. Doubled for each retry.
+	workerRetryDelay = time.Second
+)
+
+var errJobFailed = errors.New("the job function returned false")
+
+// runJob runs the given Lua function from the configuration script with a
+// job as the argument. A job fails if the function raises an error or returns false.
+func (ac *Config) runJob(luaFunc *ConfigFunction, job string) error {
+	result, err := ac.callConfigFunction(luaFunc, lua.LString(job))
+	if err != nil {
+		return err
+	}
+	if result == lua.LFalse {
+		return errJobFailed
+	}
+	return nil
+}
+
+// StartWorkers starts the given number of workers that take jobs from the
+// queue and run the given Lua function with them, until shutdown. Failed jobs
+// are retried up to the given number of times, then added to the queue that
+// has the same name, followed by ":failed".
+func (ac *Config) StartWorkers(queue *datastruct.Queue, failed *datastruct.Queue, concurrency, retries int, luaFunc *ConfigFunction) {
+	stop := ac.scheduleStop()
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				job, err := queue.Pop(workerPopTimeout)
+				if err == datastruct.ErrQueueTimeout {
+					continue
+				} else if err != nil {
+					log.Error("Could not take a job from the queue ", queue.Name(), ": ", err)
+					time.Sleep(workerPopTimeout)
+					continue
+				}
+				delay := workerRetryDelay
+				for attempt := 0; ; attempt++ {
+					err = ac.runJob(luaFunc, job)
+					if err == nil {
+						break
+					}
+					if attempt >= retries {
+						log.Error("Job in the queue ", queue.Name(), " failed: ", err)
+						failed.Push(job)
+						break
+					}
+					log.Warn("Job in the queue ", queue.Name(), " failed, retrying: ", err)
+					select {
+					case <-time.After(delay):
+					case <-stop:
+						// Keep the job for the next time the server starts
+						queue.Push(job)
+						return
+					}
+					delay *= 2
+				}
+			}
+		}()
+	}
+}
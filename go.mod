@@ -3,7 +3,9 @@ module github.com/xyproto/algernon
 go 1.11
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/alecthomas/colour v0.1.0 // indirect
+	github.com/andybalholm/brotli v1.0.0
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/ddliu/go-httpclient v0.6.3
@@ -13,6 +15,8 @@ require (
 	github.com/go-check/check v0.0.0-20190902080502-41f04d3bba15 // indirect
 	github.com/go-gcfg/gcfg v1.2.3
 	github.com/go-sourcemap/sourcemap v2.1.2+incompatible // indirect
+	github.com/gomodule/redigo v2.0.0+incompatible
+	github.com/gorilla/websocket v1.4.2
 	github.com/jvatic/goja-babel v0.0.0-20190524192434-5d6f64e2caa4
 	github.com/lib/pq v1.2.0
 	github.com/mattn/go-isatty v0.0.10 // indirect
@@ -50,11 +54,12 @@ require (
 	github.com/xyproto/tinysvg v0.0.0-20191101100520-ef4e4a2e5b89
 	github.com/xyproto/unzip v0.0.0-20150601123358-823950573952
 	github.com/yosssi/gcss v0.1.0
-	golang.org/x/crypto v0.0.0-20191206172530-e9b2fee46413 // indirect
+	golang.org/x/crypto v0.0.0-20191206172530-e9b2fee46413
 	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
 	golang.org/x/sys v0.0.0-20191210023423-ac6580df4449 // indirect
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
 	gopkg.in/gcfg.v1 v1.2.3 // indirect
 	gopkg.in/russross/blackfriday.v2 v2.0.0
 	gopkg.in/warnings.v0 v0.1.2 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
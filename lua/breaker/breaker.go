@@ -0,0 +1,216 @@
+// Package breaker provides circuit breakers for outgoing requests, that are
+// shared by all Lua states in the process
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// Class is an identifier for the CircuitBreaker class in Lua
+	Class = "CircuitBreaker"
+
+	// The number of failures in a row that opens a circuit breaker, by default
+	defaultThreshold = 5
+
+	// How long a circuit breaker stays open before a request is let through, by default
+	defaultCooldown = 30 * time.Second
+)
+
+// The states of a circuit breaker
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half-open"
+)
+
+// ErrOpen is returned when a request is not made because the circuit breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker stops requests to a failing dependency for a while, after a number
+// of failures in a row. After the cooldown, one request is let through. If it
+// succeeds, the breaker is closed again.
+type Breaker struct {
+	mut       sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	trial     bool // true while a request is let through in the half-open state
+	trialAt   time.Time
+}
+
+var (
+	breakers   = make(map[string]*Breaker)
+	breakersMu sync.Mutex
+)
+
+// Get returns the circuit breaker with the given name, which is created
+// with the default settings if it does not exist
+func Get(name string) *Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = &Breaker{name: name, threshold: defaultThreshold, cooldown: defaultCooldown}
+		breakers[name] = b
+	}
+	return b
+}
+
+// Configure sets the number of failures in a row that opens the breaker,
+// and how long it stays open
+func (b *Breaker) Configure(threshold int, cooldown time.Duration) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.threshold = threshold
+	b.cooldown = cooldown
+}
+
+// state returns the current state. The mutex must be locked.
+func (b *Breaker) state() string {
+	switch {
+	case b.failures < b.threshold:
+		return Closed
+	case time.Since(b.openedAt) < b.cooldown:
+		return Open
+	}
+	return HalfOpen
+}
+
+// State returns "closed", "open" or "half-open"
+func (b *Breaker) State() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.state()
+}
+
+// Allow checks if a request can be made. When half-open, only one request at
+// the time is allowed, until Success or Failure is called.
+func (b *Breaker) Allow() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	switch b.state() {
+	case Closed:
+		return true
+	case HalfOpen:
+		// If the result of the trial request is never recorded, let another
+		// request through after the cooldown
+		if b.trial && time.Since(b.trialAt) < b.cooldown {
+			return false
+		}
+		b.trial = true
+		b.trialAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// Success records a successful request, which closes the breaker
+func (b *Breaker) Success() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failed request, which opens the breaker if there have
+// been too many failures in a row, or if it is half-open
+func (b *Breaker) Failure() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+	b.trial = false
+}
+
+// Get the first argument, "self", and cast it from userdata to a Breaker
+func checkBreaker(L *lua.LState) *Breaker {
+	ud := L.CheckUserData(1)
+	if b, ok := ud.Value.(*Breaker); ok {
+		return b
+	}
+	L.ArgError(1, "circuit breaker expected")
+	return nil
+}
+
+// Takes a CircuitBreaker. Returns true if a request can be made.
+func breakerAllow(L *lua.LState) int {
+	b := checkBreaker(L) // arg 1
+	L.Push(lua.LBool(b.Allow()))
+	return 1 // number of results
+}
+
+// Takes a CircuitBreaker and records a successful request
+func breakerSuccess(L *lua.LState) int {
+	checkBreaker(L).Success() // arg 1
+	return 0                  // number of results
+}
+
+// Takes a CircuitBreaker and records a failed request
+func breakerFailure(L *lua.LState) int {
+	checkBreaker(L).Failure() // arg 1
+	return 0                  // number of results
+}
+
+// Takes a CircuitBreaker. Returns "closed", "open" or "half-open".
+func breakerState(L *lua.LState) int {
+	b := checkBreaker(L) // arg 1
+	L.Push(lua.LString(b.State()))
+	return 1 // number of results
+}
+
+// String representation
+func breakerString(L *lua.LState) int {
+	b := checkBreaker(L) // arg 1
+	L.Push(lua.LString("Circuit breaker " + b.name + " (" + b.State() + ")"))
+	return 1 // number of results
+}
+
+// The CircuitBreaker methods that are to be registered
+var breakerMethods = map[string]lua.LGFunction{
+	"__tostring": breakerString,
+	"allow":      breakerAllow,
+	"success":    breakerSuccess,
+	"failure":    breakerFailure,
+	"state":      breakerState,
+}
+
+// Load makes functions related to circuit breakers available to the given Lua state
+func Load(L *lua.LState) {
+
+	// Register the CircuitBreaker class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(Class)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, breakerMethods)
+
+	// Return the circuit breaker with the given name. Takes an optional table
+	// with "threshold" (failures in a row) and "cooldown" (in seconds).
+	L.SetGlobal("CircuitBreaker", L.NewFunction(func(L *lua.LState) int {
+		b := Get(L.CheckString(1))
+		if options := L.OptTable(2, nil); options != nil {
+			threshold := defaultThreshold
+			if n, ok := options.RawGetString("threshold").(lua.LNumber); ok && n >= 1 {
+				threshold = int(n)
+			}
+			cooldown := defaultCooldown
+			if n, ok := options.RawGetString("cooldown").(lua.LNumber); ok && n > 0 {
+				cooldown = time.Duration(float64(n) * float64(time.Second))
+			}
+			b.Configure(threshold, cooldown)
+		}
+		ud := L.NewUserData()
+		ud.Value = b
+		L.SetMetatable(ud, L.GetTypeMetatable(Class))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/xyproto/gluamapper"
@@ -16,6 +17,10 @@ import (
 
 var (
 	errToMap = errors.New("Could not represent Lua structure table as a map")
+
+	// ErrTableCycle is returned when a table contains itself, directly or
+	// through other tables
+	ErrTableCycle = errors.New("the table contains itself")
 )
 
 // PprintToWriter outputs more informative information than the memory location.
@@ -109,6 +114,17 @@ func Strings2table(L *lua.LState, sl []string) *lua.LTable {
 	return table
 }
 
+// Table2strings converts the array part of a Lua table to a string slice
+func Table2strings(luaTable *lua.LTable) []string {
+	sl := make([]string, 0, luaTable.Len())
+	luaTable.ForEach(func(key, value lua.LValue) {
+		if key.Type() == lua.LTNumber {
+			sl = append(sl, value.String())
+		}
+	})
+	return sl
+}
+
 // Map2table converts a map[string]string to a Lua table
 func Map2table(L *lua.LState, m map[string]string) *lua.LTable {
 	table := L.NewTable()
@@ -118,6 +134,124 @@ func Map2table(L *lua.LState, m map[string]string) *lua.LTable {
 	return table
 }
 
+// Interface2LValue converts a value, as decoded by encoding/json, to a Lua value.
+// JSON objects become tables with string keys and JSON arrays become tables
+// with numeric keys, starting at 1. JSON null becomes nil.
+func Interface2LValue(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		table := L.NewTable()
+		for _, element := range v {
+			table.Append(Interface2LValue(L, element))
+		}
+		return table
+	case map[string]interface{}:
+		table := L.NewTable()
+		for key, element := range v {
+			L.RawSet(table, lua.LString(key), Interface2LValue(L, element))
+		}
+		return table
+	case map[interface{}]interface{}:
+		table := L.NewTable()
+		for key, element := range v {
+			L.RawSet(table, lua.LString(fmt.Sprintf("%v", key)), Interface2LValue(L, element))
+		}
+		return table
+	case []map[string]interface{}:
+		table := L.NewTable()
+		for _, element := range v {
+			table.Append(Interface2LValue(L, element))
+		}
+		return table
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case time.Time:
+		return lua.LString(v.Format(time.RFC3339))
+	default:
+		return lua.LString(fmt.Sprintf("%v", v))
+	}
+}
+
+// LValue2Interface converts a Lua value to a value that can be encoded as
+// JSON, YAML or TOML. Tables where all keys are the indices 1 to n become
+// slices, other tables become maps with string keys. Integral numbers
+// become ints. Returns ErrTableCycle if a table contains itself.
+func LValue2Interface(value lua.LValue) (interface{}, error) {
+	return lvalue2interface(value, make(map[*lua.LTable]bool))
+}
+
+// lvalue2interface converts a Lua value, like LValue2Interface. The given map
+// has the tables that are being converted, which contain the current value.
+func lvalue2interface(value lua.LValue, parents map[*lua.LTable]bool) (interface{}, error) {
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		if f := float64(v); f == float64(int64(f)) {
+			return int64(f), nil
+		}
+		return float64(v), nil
+	case *lua.LTable:
+		if parents[v] {
+			return nil, ErrTableCycle
+		}
+		parents[v] = true
+		defer delete(parents, v)
+		if n := v.Len(); n > 0 {
+			count := 0
+			v.ForEach(func(_, _ lua.LValue) {
+				count++
+			})
+			if count == n {
+				list := make([]interface{}, n)
+				for i := range list {
+					element, err := lvalue2interface(v.RawGetInt(i+1), parents)
+					if err != nil {
+						return nil, err
+					}
+					list[i] = element
+				}
+				return list, nil
+			}
+		}
+		m := make(map[string]interface{})
+		var err error
+		v.ForEach(func(key, element lua.LValue) {
+			if err != nil {
+				return
+			}
+			m[key.String()], err = lvalue2interface(element, parents)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case *lua.LUserData:
+		// Use the value that is wrapped by the userdata, like values from raw()
+		return v.Value, nil
+	default:
+		return v.String(), nil
+	}
+}
+
 // Table2map converts a Lua table to **one** of the following types, depending
 // on the content:
 //   map[string]string
@@ -234,9 +368,13 @@ func Table2interfaceMap(luaTable *lua.LTable) map[string]interface{} {
 		svalue, hasSvalue = tvalue.(lua.LString)
 		nvalue, hasNvalue = tvalue.(lua.LNumber)
 		secondTableValue, hasTvalue := tvalue.(*lua.LTable)
+		userData, hasUvalue := tvalue.(*lua.LUserData)
 
 		// Store the right keys and values in the right maps
-		if hasSkey && hasTvalue {
+		if hasSkey && hasUvalue {
+			// Use the value that is wrapped by the userdata, like values from raw()
+			everything[skey.String()] = userData.Value
+		} else if hasSkey && hasTvalue {
 			// Recursive call if the value is another table that can be converted to a string->interface{} map
 			everything[skey.String()] = Table2interfaceMap(secondTableValue)
 		} else if hasSkey && hasSvalue {
@@ -0,0 +1,138 @@
+package datastruct
+
+import (
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xyproto/pinterface"
+	"github.com/xyproto/simpleredis"
+)
+
+// Sets KEYS[1] to ARGV[2] if the current value is ARGV[1]. A missing key
+// counts as an empty string, just like for kv:get.
+const redisCASScript = `
+local current = redis.call("GET", KEYS[1])
+if current == false then
+  current = ""
+end
+if current == ARGV[1] then
+  redis.call("SET", KEYS[1], ARGV[2])
+  return 1
+end
+return 0
+`
+
+var (
+	casScript = redis.NewScript(1, redisCASScript)
+
+	// For making compare-and-swap atomic within this server, for backends
+	// that can not do it by themselves
+	casMutex sync.Mutex
+)
+
+// CompareAndSwapper is a KeyValue that can do an atomic compare-and-swap
+type CompareAndSwapper interface {
+	CompareAndSwap(key, oldValue, newValue string) (bool, error)
+}
+
+// RedisCASCreator wraps a creator for Redis data structures, so that the
+// KeyValues it creates can do an atomic compare-and-swap in Redis, and the
+// Lists it creates can atomically remove their first element
+type RedisCASCreator struct {
+	pinterface.ICreator
+	pool    *simpleredis.ConnectionPool
+	dbindex int
+}
+
+// redisCASKeyValue is a Redis KeyValue that can do an atomic compare-and-swap
+type redisCASKeyValue struct {
+	pinterface.IKeyValue
+	pool    *simpleredis.ConnectionPool
+	dbindex int
+	id      string
+}
+
+// redisPopList is a Redis List that can atomically remove its first element
+type redisPopList struct {
+	pinterface.IList
+	pool    *simpleredis.ConnectionPool
+	dbindex int
+	id      string
+}
+
+// NewRedisCASCreator wraps the given Redis creator, which uses the given
+// connection pool and database index
+func NewRedisCASCreator(creator pinterface.ICreator, pool *simpleredis.ConnectionPool, dbindex int) *RedisCASCreator {
+	return &RedisCASCreator{creator, pool, dbindex}
+}
+
+// SelectDatabase selects the Redis database index for new data structures
+func (c *RedisCASCreator) SelectDatabase(dbindex int) {
+	if rh, ok := c.ICreator.(pinterface.IRedisCreator); ok {
+		rh.SelectDatabase(dbindex)
+	}
+	c.dbindex = dbindex
+}
+
+// NewKeyValue creates a new Redis KeyValue that supports compare-and-swap
+func (c *RedisCASCreator) NewKeyValue(id string) (pinterface.IKeyValue, error) {
+	kv, err := c.ICreator.NewKeyValue(id)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCASKeyValue{kv, c.pool, c.dbindex, id}, nil
+}
+
+// NewList creates a new Redis List that can atomically remove its first element
+func (c *RedisCASCreator) NewList(id string) (pinterface.IList, error) {
+	list, err := c.ICreator.NewList(id)
+	if err != nil {
+		return nil, err
+	}
+	return &redisPopList{list, c.pool, c.dbindex, id}, nil
+}
+
+// PopFirst removes and returns the first element of the list, with LPOP.
+// Returns false if the list is empty.
+func (rl *redisPopList) PopFirst() (string, bool, error) {
+	conn := rl.pool.Get(rl.dbindex)
+	defer conn.Close()
+	value, err := redis.String(conn.Do("LPOP", rl.id))
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+// CompareAndSwap sets the key to newValue if the current value is oldValue,
+// using a Lua script in Redis. Returns true if the value was set.
+func (rkv *redisCASKeyValue) CompareAndSwap(key, oldValue, newValue string) (bool, error) {
+	conn := rkv.pool.Get(rkv.dbindex)
+	defer conn.Close()
+	// simpleredis stores the keys of a KeyValue as "id:key"
+	swapped, err := redis.Int(casScript.Do(conn, rkv.id+":"+key, oldValue, newValue))
+	return swapped == 1, err
+}
+
+// CompareAndSwap sets the key to newValue if the current value is oldValue.
+// Uses the backend if it supports compare-and-swap, and a mutex otherwise.
+// A missing key counts as an empty string. Returns true if the value was set.
+func CompareAndSwap(kv pinterface.IKeyValue, key, oldValue, newValue string) (bool, error) {
+	if cas, ok := kv.(CompareAndSwapper); ok {
+		return cas.CompareAndSwap(key, oldValue, newValue)
+	}
+	casMutex.Lock()
+	defer casMutex.Unlock()
+	current, err := kv.Get(key)
+	if err != nil {
+		// A missing key counts as an empty string
+		current = ""
+	}
+	if current != oldValue {
+		return false, nil
+	}
+	if err := kv.Set(key, newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
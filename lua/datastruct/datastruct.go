@@ -1,2 +1,2 @@
-// Package datastruct provides Lua functions for dealing with hash maps, key/values, lists and sets
+// Package datastruct provides Lua functions for dealing with hash maps, key/values, lists, sets and queues
 package datastruct
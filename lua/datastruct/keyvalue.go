@@ -93,6 +93,23 @@ func kvDel(L *lua.LState) int {
 	return 1 // Number of returned values
 }
 
+// Set a key to a new value, but only if the current value matches the given
+// old value. A missing key counts as an empty string.
+// Returns true if the value was swapped.
+// kv:cas(string, string, string) -> bool
+func kvCas(L *lua.LState) int {
+	kv := checkKeyValue(L) // arg 1
+	key := L.CheckString(2)
+	oldValue := L.CheckString(3)
+	newValue := L.CheckString(4)
+	swapped, err := CompareAndSwap(kv, key, oldValue, newValue)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	L.Push(lua.LBool(swapped))
+	return 1 // Number of returned values
+}
+
 // Remove the keyvalue itself. Returns true if successful.
 // kv:remove() -> bool
 func kvRemove(L *lua.LState) int {
@@ -116,6 +133,7 @@ var kvMethods = map[string]lua.LGFunction{
 	"get":        kvGet,
 	"inc":        kvInc,
 	"del":        kvDel,
+	"cas":        kvCas,
 	"remove":     kvRemove,
 	"clear":      kvClear,
 }
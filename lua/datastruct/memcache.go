@@ -0,0 +1,236 @@
+package datastruct
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// Identifier for the MemCache class in Lua
+const lMemCacheClass = "MEMCACHE"
+
+var (
+	errMemCacheType  = errors.New("only strings, numbers, booleans and tables can be stored")
+	errMemCacheCycle = errors.New("the table contains itself")
+)
+
+// How often expired entries are removed from the in-memory cache
+const memCacheSweepInterval = time.Minute
+
+// memCacheEntry is a cached value and when it expires (zero for never)
+type memCacheEntry struct {
+	value   lua.LValue
+	expires time.Time
+}
+
+// expired checks if the entry has expired at the given time
+func (e *memCacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// MemCache is a process-local key/value cache that is shared between all
+// Lua states. Values are copied when they are stored and retrieved.
+type MemCache struct {
+	mut     sync.RWMutex
+	entries map[string]*memCacheEntry
+}
+
+var (
+	// The in-memory cache that is shared by all Lua states
+	sharedMemCache     = &MemCache{entries: make(map[string]*memCacheEntry)}
+	sharedMemCacheOnce sync.Once
+)
+
+// Set stores a value, that expires after the given duration (0 for never)
+func (mc *MemCache) Set(key string, value lua.LValue, ttl time.Duration) {
+	entry := &memCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	mc.mut.Lock()
+	mc.entries[key] = entry
+	mc.mut.Unlock()
+}
+
+// Get retrieves a value. Expired values are removed.
+func (mc *MemCache) Get(key string) (lua.LValue, bool) {
+	mc.mut.RLock()
+	entry, ok := mc.entries[key]
+	mc.mut.RUnlock()
+	if !ok {
+		return lua.LNil, false
+	}
+	if entry.expired(time.Now()) {
+		mc.mut.Lock()
+		// Only delete the entry if it has not been replaced in the meantime
+		if mc.entries[key] == entry {
+			delete(mc.entries, key)
+		}
+		mc.mut.Unlock()
+		return lua.LNil, false
+	}
+	return entry.value, true
+}
+
+// Del removes a value
+func (mc *MemCache) Del(key string) {
+	mc.mut.Lock()
+	delete(mc.entries, key)
+	mc.mut.Unlock()
+}
+
+// Sweep removes all expired values
+func (mc *MemCache) Sweep() {
+	now := time.Now()
+	mc.mut.Lock()
+	for key, entry := range mc.entries {
+		if entry.expired(now) {
+			delete(mc.entries, key)
+		}
+	}
+	mc.mut.Unlock()
+}
+
+// copyValue copies a Lua value so that it can be shared between Lua states.
+// Tables are copied recursively. Functions, userdata and other values that
+// belong to a Lua state can not be copied, and neither can tables that
+// contain themselves.
+func copyValue(L *lua.LState, value lua.LValue) (lua.LValue, error) {
+	return copyValueWithin(L, value, make(map[*lua.LTable]bool))
+}
+
+// copyValueWithin copies a Lua value, like copyValue. The given map has the
+// tables that are being copied, which contain the current value.
+func copyValueWithin(L *lua.LState, value lua.LValue, parents map[*lua.LTable]bool) (lua.LValue, error) {
+	switch v := value.(type) {
+	case lua.LString, lua.LNumber, lua.LBool:
+		return v, nil
+	case *lua.LTable:
+		if parents[v] {
+			return lua.LNil, errMemCacheCycle
+		}
+		parents[v] = true
+		defer delete(parents, v)
+		table := L.NewTable()
+		var err error
+		v.ForEach(func(key, value lua.LValue) {
+			if err != nil {
+				return
+			}
+			var keyCopy, valueCopy lua.LValue
+			if keyCopy, err = copyValueWithin(L, key, parents); err != nil {
+				return
+			}
+			if valueCopy, err = copyValueWithin(L, value, parents); err != nil {
+				return
+			}
+			table.RawSet(keyCopy, valueCopy)
+		})
+		if err != nil {
+			return lua.LNil, err
+		}
+		return table, nil
+	}
+	if value == lua.LNil {
+		return lua.LNil, nil
+	}
+	return lua.LNil, errMemCacheType
+}
+
+// Get the first argument, "self", and cast it from userdata to a memcache.
+func checkMemCache(L *lua.LState) *MemCache {
+	ud := L.CheckUserData(1)
+	if mc, ok := ud.Value.(*MemCache); ok {
+		return mc
+	}
+	L.ArgError(1, "memcache expected")
+	return nil
+}
+
+// Store a string, number, boolean or table, with an optional time to live,
+// in seconds. Returns true, or false and an error string.
+// mc:set(string, value[, number]) -> bool
+func mcSet(L *lua.LState) int {
+	mc := checkMemCache(L) // arg 1
+	key := L.CheckString(2)
+	value, err := copyValue(L, L.Get(3))
+	if err != nil {
+		L.Push(lua.LBool(false))
+		L.Push(lua.LString(err.Error()))
+		return 2 // Number of returned values
+	}
+	ttl := time.Duration(float64(L.OptNumber(4, 0)) * float64(time.Second))
+	mc.Set(key, value, ttl)
+	L.Push(lua.LBool(true))
+	return 1 // Number of returned values
+}
+
+// Retrieve a value, or nil if it is missing or has expired
+// mc:get(string) -> value
+func mcGet(L *lua.LState) int {
+	mc := checkMemCache(L) // arg 1
+	key := L.CheckString(2)
+	value, ok := mc.Get(key)
+	if !ok {
+		L.Push(lua.LNil)
+		return 1 // Number of returned values
+	}
+	value, _ = copyValue(L, value)
+	L.Push(value)
+	return 1 // Number of returned values
+}
+
+// Remove a value
+// mc:del(string)
+func mcDel(L *lua.LState) int {
+	mc := checkMemCache(L) // arg 1
+	key := L.CheckString(2)
+	mc.Del(key)
+	return 0 // Number of returned values
+}
+
+// String representation
+// tostring(mc) -> string
+func mcToString(L *lua.LState) int {
+	L.Push(lua.LString("MemCache"))
+	return 1 // Number of returned values
+}
+
+// The memcache methods that are to be registered
+var memCacheMethods = map[string]lua.LGFunction{
+	"__tostring": mcToString,
+	"set":        mcSet,
+	"get":        mcGet,
+	"del":        mcDel,
+}
+
+// LoadMemCache makes the shared in-memory cache available to Lua scripts.
+// Expired values are removed when they are retrieved, and by a periodic sweep.
+func LoadMemCache(L *lua.LState) {
+
+	// Start sweeping the cache for expired values, once
+	sharedMemCacheOnce.Do(func() {
+		go func() {
+			for range time.Tick(memCacheSweepInterval) {
+				sharedMemCache.Sweep()
+			}
+		}()
+	})
+
+	// Register the memcache class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lMemCacheClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, memCacheMethods)
+
+	// The constructor returns the shared in-memory cache
+	L.SetGlobal("MemCache", L.NewFunction(func(L *lua.LState) int {
+		ud := L.NewUserData()
+		ud.Value = sharedMemCache
+		L.SetMetatable(ud, L.GetTypeMetatable(lMemCacheClass))
+		L.Push(ud)
+		return 1 // Number of returned values
+	}))
+
+}
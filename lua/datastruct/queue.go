@@ -0,0 +1,208 @@
+package datastruct
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xyproto/gopher-lua"
+	"github.com/xyproto/pinterface"
+)
+
+// Identifier for the Queue class in Lua
+const lQueueClass = "QUEUE"
+
+// How often a blocking pop checks the list for new jobs
+const queuePollInterval = 100 * time.Millisecond
+
+// ErrQueueTimeout is returned by Pop if no job arrived before the timeout
+var ErrQueueTimeout = errors.New("timeout")
+
+var (
+	// For making push and pop atomic within this server, by queue name
+	queueMutexes   = make(map[string]*sync.Mutex)
+	queueMutexesMu sync.Mutex
+)
+
+// queueMutex returns the mutex for the queue with the given name
+func queueMutex(name string) *sync.Mutex {
+	queueMutexesMu.Lock()
+	defer queueMutexesMu.Unlock()
+	m, ok := queueMutexes[name]
+	if !ok {
+		m = &sync.Mutex{}
+		queueMutexes[name] = m
+	}
+	return m
+}
+
+// FirstPopper is a List that can atomically remove and return its first
+// element, also when several servers use the same database
+type FirstPopper interface {
+	PopFirst() (string, bool, error)
+}
+
+// Queue is a FIFO job queue, stored in a List in the database backend
+type Queue struct {
+	name string
+	list pinterface.IList
+	mut  *sync.Mutex
+}
+
+// NewQueue creates a new job queue with the given name
+func NewQueue(creator pinterface.ICreator, name string) (*Queue, error) {
+	list, err := creator.NewList("queue:" + name)
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{name, list, queueMutex(name)}, nil
+}
+
+// Name returns the name of the queue
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Push adds a job to the end of the queue
+func (q *Queue) Push(job string) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return q.list.Add(job)
+}
+
+// Len returns the number of jobs in the queue
+func (q *Queue) Len() (int, error) {
+	all, err := q.list.All()
+	return len(all), err
+}
+
+// tryPop removes and returns the first job in the queue, if any. Uses the
+// backend if it can do this atomically, and a mutex otherwise.
+func (q *Queue) tryPop() (string, bool, error) {
+	if fp, ok := q.list.(FirstPopper); ok {
+		return fp.PopFirst()
+	}
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	all, err := q.list.All()
+	if err != nil || len(all) == 0 {
+		return "", false, err
+	}
+	// The List type can only add to the end, so the rest is added back
+	if err := q.list.Clear(); err != nil {
+		return "", false, err
+	}
+	for _, job := range all[1:] {
+		if err := q.list.Add(job); err != nil {
+			return "", false, err
+		}
+	}
+	return all[0], true, nil
+}
+
+// Pop removes and returns the first job in the queue. If the queue is empty,
+// Pop waits for a job for up to the given duration, then returns ErrQueueTimeout.
+func (q *Queue) Pop(timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		job, ok, err := q.tryPop()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return job, nil
+		}
+		if !time.Now().Before(deadline) {
+			return "", ErrQueueTimeout
+		}
+		time.Sleep(queuePollInterval)
+	}
+}
+
+// Get the first argument, "self", and cast it from userdata to a queue.
+func checkQueue(L *lua.LState) *Queue {
+	ud := L.CheckUserData(1)
+	if queue, ok := ud.Value.(*Queue); ok {
+		return queue
+	}
+	L.ArgError(1, "queue expected")
+	return nil
+}
+
+// Add a job to the end of the queue
+// queue:push(string)
+func queuePush(L *lua.LState) int {
+	queue := checkQueue(L) // arg 1
+	job := L.CheckString(2)
+	queue.Push(job)
+	return 0 // Number of returned values
+}
+
+// Remove and return the first job in the queue, waiting for up to the given
+// number of seconds (the default is 0) if the queue is empty.
+// Returns an empty string if there were no jobs.
+// queue:pop([number]) -> string
+func queuePop(L *lua.LState) int {
+	queue := checkQueue(L) // arg 1
+	timeout := time.Duration(float64(L.OptNumber(2, 0)) * float64(time.Second))
+	job, err := queue.Pop(timeout)
+	if err != nil {
+		job = ""
+	}
+	L.Push(lua.LString(job))
+	return 1 // Number of returned values
+}
+
+// Return the number of jobs in the queue
+// queue:len() -> number
+func queueLen(L *lua.LState) int {
+	queue := checkQueue(L) // arg 1
+	n, _ := queue.Len()
+	L.Push(lua.LNumber(n))
+	return 1 // Number of returned values
+}
+
+// String representation
+// tostring(queue) -> string
+func queueToString(L *lua.LState) int {
+	queue := checkQueue(L) // arg 1
+	L.Push(lua.LString("Queue " + queue.Name()))
+	return 1 // Number of returned values
+}
+
+// The queue methods that are to be registered
+var queueMethods = map[string]lua.LGFunction{
+	"__tostring": queueToString,
+	"push":       queuePush,
+	"pop":        queuePop,
+	"len":        queueLen,
+}
+
+// LoadQueue makes functions related to job queues available to Lua scripts
+func LoadQueue(L *lua.LState, creator pinterface.ICreator) {
+
+	// Register the queue class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(lQueueClass)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, queueMethods)
+
+	// The constructor for new queues takes a name
+	L.SetGlobal("Queue", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+
+		queue, err := NewQueue(creator, name)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // Number of returned values
+		}
+
+		// Return the queue object
+		ud := L.NewUserData()
+		ud.Value = queue
+		L.SetMetatable(ud, L.GetTypeMetatable(lQueueClass))
+		L.Push(ud)
+		return 1 // Number of returned values
+	}))
+
+}
@@ -0,0 +1,216 @@
+package httpclient
+
+// Resolving host names with DNS over HTTPS (RFC 8484)
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// The maximum size of a DNS message
+const dnsMessageMaxSize = 65535
+
+// DoHResolver resolves host names by sending DNS queries to a DNS over HTTPS
+// server, and caches the answers for as long as the server allows
+type DoHResolver struct {
+	endpoint string
+	client   *http.Client
+	mut      sync.Mutex
+	cache    map[string]dohAnswer
+}
+
+// dohAnswer is a cached list of addresses for a host name
+type dohAnswer struct {
+	addrs   []string
+	expires time.Time
+}
+
+var (
+	// The resolvers are shared, so that the cache is shared between requests
+	resolvers    = make(map[string]*DoHResolver)
+	resolversMut sync.Mutex
+)
+
+// NewDoHResolver returns the resolver for the given DNS over HTTPS endpoint,
+// like "https://cloudflare-dns.com/dns-query"
+func NewDoHResolver(endpoint string) (*DoHResolver, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("the DNS over HTTPS endpoint must be a https URL: %s", endpoint)
+	}
+	resolversMut.Lock()
+	defer resolversMut.Unlock()
+	if r, ok := resolvers[endpoint]; ok {
+		return r, nil
+	}
+	r := &DoHResolver{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		cache:    make(map[string]dohAnswer),
+	}
+	resolvers[endpoint] = r
+	return r, nil
+}
+
+// query sends a query for the given host name and record type, and returns
+// the addresses in the answer, and the lowest TTL
+func (r *DoHResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]string, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	// The ID should be 0, so that the responses can be cached by HTTP caches
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	sep := "?"
+	if strings.Contains(r.endpoint, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequest(http.MethodGet, r.endpoint+sep+"dns="+base64.RawURLEncoding.EncodeToString(packed), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("the DNS over HTTPS server responded with %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, dnsMessageMaxSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	var p dnsmessage.Parser
+	header, err := p.Start(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host}
+	default:
+		return nil, 0, fmt.Errorf("could not resolve %s: %s", host, header.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+	var (
+		addrs []string
+		ttl   time.Duration
+	)
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		switch {
+		case h.Type == dnsmessage.TypeA && qtype == dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, net.IP(a.A[:]).String())
+		case h.Type == dnsmessage.TypeAAAA && qtype == dnsmessage.TypeAAAA:
+			aaaa, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, net.IP(aaaa.AAAA[:]).String())
+		default:
+			// CNAME records and such are followed by the server
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if recordTTL := time.Duration(h.TTL) * time.Second; ttl == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+	}
+	return addrs, ttl, nil
+}
+
+// LookupHost returns the IPv4 and IPv6 addresses for the given host name
+func (r *DoHResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mut.Lock()
+	cached, ok := r.cache[host]
+	r.mut.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	addrs, ttl, errA := r.query(ctx, host, dnsmessage.TypeA)
+	addrs6, ttl6, errAAAA := r.query(ctx, host, dnsmessage.TypeAAAA)
+	if errA != nil && errAAAA != nil {
+		return nil, errA
+	}
+	addrs = append(addrs, addrs6...)
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	if ttl6 != 0 && (ttl == 0 || ttl6 < ttl) {
+		ttl = ttl6
+	}
+
+	r.mut.Lock()
+	r.cache[host] = dohAnswer{addrs: addrs, expires: time.Now().Add(ttl)}
+	r.mut.Unlock()
+	return addrs, nil
+}
+
+// DialContext connects to the given address, like net.Dialer.DialContext,
+// but resolves the host name with this resolver. The addresses are tried in
+// order, until a connection can be made.
+func (r *DoHResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	err = errors.New("no addresses for " + host)
+	for _, ip := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,91 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohServer answers A queries for "flunix.test." with 127.0.0.1
+func dohServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q := query.Questions[0]
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RecursionAvailable: true},
+			Questions: query.Questions,
+		}
+		if q.Name.String() != "flunix.test." {
+			answer.Header.RCode = dnsmessage.RCodeNameError
+		} else if q.Type == dnsmessage.TypeA {
+			answer.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			}}
+		}
+		packed, err := answer.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+}
+
+func TestDoHResolver(t *testing.T) {
+	doh := dohServer(t)
+	defer doh.Close()
+
+	resolver, err := NewDoHResolver(doh.URL + "/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := resolver.LookupHost(context.Background(), "flunix.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Errorf("Expected [127.0.0.1], got %v", addrs)
+	}
+	if _, err := resolver.LookupHost(context.Background(), "missing.test"); err == nil {
+		t.Error("Expected an error for a missing host")
+	}
+
+	// Make a request to a server, by name
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "hi "+req.Host)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	hc := NewHTTPClient()
+	hc.resolver = resolver
+	resp, err := hc.Do("GET", "http://flunix.test:"+port+"/", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := resp.ToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "hi flunix.test:"+port {
+		t.Errorf("Unexpected response: %q", body)
+	}
+}
@@ -0,0 +1,203 @@
+package httpclient
+
+// A GraphQL client, built on top of the HTTP client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// GraphQLClass is an identifier for the GraphQL class in Lua
+	GraphQLClass = "GraphQL"
+)
+
+// GraphQL is a client for a GraphQL endpoint
+type GraphQL struct {
+	endpoint string
+	hc       *HTTPClient
+}
+
+// GraphQLResponse is the response to a GraphQL query. Data and Errors are
+// kept as generic values, so that they can be converted to Lua tables.
+type GraphQLResponse struct {
+	Data   interface{}   `json:"data"`
+	Errors []interface{} `json:"errors"`
+}
+
+// NewGraphQL creates a new GraphQL client for the given endpoint, which
+// sends the requests with the given HTTP client
+func NewGraphQL(endpoint string, hc *HTTPClient) *GraphQL {
+	return &GraphQL{endpoint: endpoint, hc: hc}
+}
+
+// Query POSTs the given query and variables, which may be nil, as JSON
+// and returns the parsed response. An error is only returned if there is no
+// GraphQL response, the errors in the response are in GraphQLResponse.Errors.
+func (gql *GraphQL) Query(query string, variables map[string]interface{}, headers map[string]string) (*GraphQLResponse, error) {
+	payload := map[string]interface{}{"query": query}
+	if variables != nil {
+		payload["variables"] = variables
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	allHeaders := map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json",
+	}
+	for k, v := range headers {
+		allHeaders[k] = v
+	}
+	resp, err := gql.hc.Do("POST", gql.endpoint, allHeaders, body)
+	if err != nil {
+		return nil, err
+	}
+	data, err := resp.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var gresp GraphQLResponse
+	if err := json.Unmarshal(data, &gresp); err != nil {
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("the GraphQL server responded with %s", resp.Status)
+		}
+		return nil, err
+	}
+	if gresp.Data == nil && len(gresp.Errors) == 0 && resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("the GraphQL server responded with %s", resp.Status)
+	}
+	return &gresp, nil
+}
+
+// ErrorString joins the messages of the errors in the response, or returns
+// an empty string if there are no errors
+func (gresp *GraphQLResponse) ErrorString() string {
+	messages := make([]string, 0, len(gresp.Errors))
+	for _, e := range gresp.Errors {
+		if m, ok := e.(map[string]interface{}); ok {
+			if message, ok := m["message"].(string); ok {
+				messages = append(messages, message)
+				continue
+			}
+		}
+		messages = append(messages, fmt.Sprint(e))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Get the first argument, "self", and cast it from userdata to a GraphQL client
+func checkGraphQLClass(L *lua.LState) *GraphQL {
+	ud := L.CheckUserData(1)
+	if gql, ok := ud.Value.(*GraphQL); ok {
+		return gql
+	}
+	L.ArgError(1, "GraphQL expected")
+	return nil
+}
+
+// gqlQuery is a Lua function for sending a GraphQL query.
+// The first argument is the query.
+// It can also take the following optional arguments:
+// * A table with variables
+// * A table with HTTP headers
+// Returns the data as a table, an error string (or nil) and the errors
+// from the response as a table (or nil). If the request fails, the data is nil.
+func gqlQuery(L *lua.LState) int {
+	gql := checkGraphQLClass(L) // arg 1
+	query := L.CheckString(2)   // arg 2
+
+	var variables map[string]interface{}
+	if variablesTable := L.OptTable(3, nil); variablesTable != nil { // arg 3 (optional)
+		value, err := convert.LValue2Interface(variablesTable)
+		if err != nil {
+			L.ArgError(3, err.Error())
+			return 0 // no results
+		}
+		var ok bool
+		variables, ok = value.(map[string]interface{})
+		if !ok {
+			L.ArgError(3, "Expected a table with named variables")
+			return 0 // no results
+		}
+	}
+
+	// HTTP HEADERS
+	headers := make(map[string]string)
+	if headerTable := L.OptTable(4, nil); headerTable != nil { // arg 4 (optional)
+		headerMap := convert.Table2interfaceMap(headerTable)
+		for k, interfaceValue := range headerMap {
+			switch v := interfaceValue.(type) {
+			case int:
+				headers[k] = strconv.Itoa(v)
+			case string:
+				headers[k] = v
+			default:
+				log.Warn("Unrecognized value in table:", v)
+			}
+		}
+	}
+
+	gresp, err := gql.Query(query, variables, headers)
+	if err != nil {
+		log.Error(err)
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(convert.Interface2LValue(L, gresp.Data))
+	if len(gresp.Errors) == 0 {
+		return 1 // number of results
+	}
+	L.Push(lua.LString(gresp.ErrorString()))
+	L.Push(convert.Interface2LValue(L, gresp.Errors))
+	return 3 // number of results
+}
+
+// gqlString is a Lua function that returns a descriptive string
+func gqlString(L *lua.LState) int {
+	gql := checkGraphQLClass(L) // arg 1
+	L.Push(lua.LString("GraphQL client for " + gql.endpoint))
+	return 1 // number of results
+}
+
+// The GraphQL methods that are to be registered
+var gqlMethods = map[string]lua.LGFunction{
+	"__tostring": gqlString,
+	"query":      gqlQuery,
+}
+
+// constructGraphQL creates a new GraphQL client. The Lua function takes the
+// endpoint and an optional HTTPClient, for the user agent, timeout, headers
+// and other settings. Otherwise, a new HTTPClient is used.
+func constructGraphQL(L *lua.LState, userAgent string, headers map[string]string) (*lua.LUserData, error) {
+	endpoint := L.CheckString(1) // arg 1
+	if endpoint == "" {
+		return nil, errors.New("GraphQL endpoint expected")
+	}
+	var hc *HTTPClient
+	if L.GetTop() >= 2 {
+		ud := L.CheckUserData(2) // arg 2 (optional)
+		var ok bool
+		if hc, ok = ud.Value.(*HTTPClient); !ok {
+			L.ArgError(2, "HTTPClient expected")
+			return nil, errors.New("HTTPClient expected")
+		}
+	} else {
+		hc = NewHTTPClient()
+		hc.userAgent = userAgent
+		hc.headers = headers
+	}
+	ud := L.NewUserData()
+	ud.Value = NewGraphQL(endpoint, hc)
+	L.SetMetatable(ud, L.GetTypeMetatable(GraphQLClass))
+	return ud, nil
+}
@@ -2,14 +2,21 @@
 package httpclient
 
 import (
-	"github.com/ddliu/go-httpclient"
-	log "github.com/sirupsen/logrus"
-	"github.com/xyproto/algernon/lua/convert"
-	"github.com/xyproto/gopher-lua"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ddliu/go-httpclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/breaker"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
 )
 
 type HTTPClient struct {
@@ -18,7 +25,19 @@ type HTTPClient struct {
 	language  string
 	client    *httpclient.HttpClient
 	cookieMap map[string]string
+	headers   map[string]string
 	invalid   bool
+
+	// Retry on connection errors, and on 5xx responses for idempotent
+	// methods, with exponential backoff
+	retries    int
+	retryDelay time.Duration
+
+	// Fail fast while this circuit breaker is open, if set
+	breaker *breaker.Breaker
+
+	// Resolve host names with DNS over HTTPS, if set
+	resolver *DoHResolver
 }
 
 func NewHTTPClient() *HTTPClient {
@@ -40,6 +59,9 @@ func (hc *HTTPClient) Begin() *httpclient.HttpClient {
 	if hc.language != "" {
 		hclient = hclient.WithHeader("Accept-Language", hc.language)
 	}
+	if len(hc.headers) != 0 {
+		hclient = hclient.WithHeaders(hc.headers)
+	}
 	if len(hc.cookieMap) != 0 {
 		for k, v := range hc.cookieMap {
 			hclient = hclient.WithCookie(&http.Cookie{
@@ -51,6 +73,151 @@ func (hc *HTTPClient) Begin() *httpclient.HttpClient {
 	return hclient.WithOption(httpclient.OPT_UNSAFE_TLS, hc.invalid)
 }
 
+// The shortest remaining time that is worth another attempt
+const minAttemptTime = time.Millisecond
+
+// failed checks if a request failed, given the result
+func failed(resp *httpclient.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// retryable checks if a request with the given method should be retried,
+// given the result. All methods are retried on connection errors. A 5xx
+// response means that the server may have acted on the request, so only
+// requests with idempotent methods are then retried.
+func retryable(method string, resp *httpclient.Response, err error) bool {
+	return err != nil || (resp.StatusCode >= 500 && idempotent(method))
+}
+
+// idempotent checks if repeating a request with the given method has the
+// same effect as making it once, so that it is safe to retry
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Do performs a HTTP request with the given method, URL, headers and body,
+// which may be nil. If retries are enabled with SetRetry, the request is
+// retried on connection errors, and on 5xx responses if the method is
+// idempotent, with exponential backoff and jitter. The timeout is then the
+// total time for all the attempts.
+// If a circuit breaker is set, breaker.ErrOpen is returned while it is open.
+func (hc *HTTPClient) Do(method, URL string, headers map[string]string, body []byte) (*httpclient.Response, error) {
+	if hc.breaker == nil {
+		return hc.do(method, URL, headers, body)
+	}
+	if !hc.breaker.Allow() {
+		return nil, breaker.ErrOpen
+	}
+	resp, err := hc.do(method, URL, headers, body)
+	if failed(resp, err) {
+		hc.breaker.Failure()
+	} else {
+		hc.breaker.Success()
+	}
+	return resp, err
+}
+
+// remaining returns the time until the given deadline, but at least
+// minAttemptTime, since a timeout of 0 means no timeout
+func remaining(deadline time.Time) time.Duration {
+	if d := time.Until(deadline); d > minAttemptTime {
+		return d
+	}
+	return minAttemptTime
+}
+
+// do performs a HTTP request, with retries if enabled with SetRetry
+func (hc *HTTPClient) do(method, URL string, headers map[string]string, body []byte) (*httpclient.Response, error) {
+	var deadline time.Time
+	if hc.timeout != 0 {
+		deadline = time.Now().Add(time.Duration(hc.timeout) * time.Second)
+	}
+	retries := hc.retries
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		var (
+			resp *httpclient.Response
+			err  error
+		)
+		if hc.resolver != nil {
+			resp, err = hc.doResolved(method, URL, headers, bodyReader, deadline)
+		} else {
+			hclient := hc.Begin()
+			if !deadline.IsZero() {
+				// The remaining time is the timeout for this attempt
+				hclient = hclient.WithOption(httpclient.OPT_TIMEOUT_MS, int(remaining(deadline)/time.Millisecond))
+			}
+			resp, err = hclient.Do(method, URL, headers, bodyReader)
+		}
+		if attempt >= retries || !retryable(method, resp, err) {
+			return resp, err
+		}
+		// Wait for base delay * 2^attempt, with up to half of it as jitter
+		delay := hc.retryDelay << uint(attempt)
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if !deadline.IsZero() && time.Until(deadline)-delay < minAttemptTime {
+			// A timeout of 0 would mean no timeout at all
+			return resp, err
+		}
+		if err != nil {
+			log.Warnf("%s %s failed, retrying in %s: %s", method, URL, delay, err)
+		} else {
+			log.Warnf("%s %s responded with %s, retrying in %s", method, URL, resp.Status, delay)
+			resp.Body.Close()
+		}
+		time.Sleep(delay)
+	}
+}
+
+// doResolved performs a HTTP request with the settings in the HTTPClient struct,
+// but connects with the resolver that was set with SetResolver instead of the
+// system resolver, which the httpclient package has no option for
+func (hc *HTTPClient) doResolved(method, URL string, headers map[string]string, body io.Reader, deadline time.Time) (*httpclient.Response, error) {
+	req, err := http.NewRequest(method, URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if hc.userAgent != "" {
+		req.Header.Set("User-Agent", hc.userAgent)
+	} else {
+		req.Header.Set("User-Agent", httpclient.USERAGENT)
+	}
+	if hc.language != "" {
+		req.Header.Set("Accept-Language", hc.language)
+	}
+	for k, v := range hc.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range hc.cookieMap {
+		req.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       hc.resolver.DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: hc.invalid},
+			DisableKeepAlives: true,
+		},
+	}
+	if !deadline.IsZero() {
+		client.Timeout = remaining(deadline)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &httpclient.Response{Response: resp}, nil
+}
+
 const (
 	// HTTPClientClass is an identifier for the HTTPClient class in Lua
 	HTTPClientClass = "HTTPClient"
@@ -67,13 +234,16 @@ func checkHTTPClientClass(L *lua.LState) *HTTPClient {
 }
 
 // Create a new httpclient.HttpClient. The Lua function takes no arguments.
-func constructHTTPClient(L *lua.LState, userAgent string) (*lua.LUserData, error) {
+func constructHTTPClient(L *lua.LState, userAgent string, headers map[string]string) (*lua.LUserData, error) {
 	// Create a new HTTP Client
 	hc := NewHTTPClient()
 
 	// Default user agent is the same as the server name
 	hc.userAgent = userAgent
 
+	// Headers that are sent with every request, like the trace context
+	hc.headers = headers
+
 	// Create a new userdata struct
 	ud := L.NewUserData()
 	ud.Value = hc
@@ -137,10 +307,12 @@ func hcGet(L *lua.LState) int {
 	//log.Info("GET " + URL)
 
 	// GET the given URL with the given HTTP headers
-	resp, err := hc.Begin().Do("GET", URL, headers, nil)
+	resp, err := hc.Do("GET", URL, headers, nil)
 	if err != nil {
 		log.Error(err)
-		return 0 // no results
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
 	}
 
 	// Read the returned body
@@ -210,15 +382,17 @@ func hcPost(L *lua.LState) int {
 	}
 
 	// Body
-	bodyReader := strings.NewReader(L.ToString(5)) // arg 5 (optional)
+	body := []byte(L.ToString(5)) // arg 5 (optional)
 
 	//log.Info("POST " + URL)
 
 	// POST the given URL with the given HTTP headers
-	resp, err := hc.Begin().Do("POST", URL, headers, bodyReader)
+	resp, err := hc.Do("POST", URL, headers, body)
 	if err != nil {
 		log.Error(err)
-		return 0 // no results
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
 	}
 
 	// Read the returned body
@@ -295,10 +469,12 @@ func hcDo(L *lua.LState) int {
 	// log.Info(method + " " + URL)
 
 	// Connect to the given URL with the given method and the given HTTP headers
-	resp, err := hc.Begin().Do(method, URL, headers, nil)
+	resp, err := hc.Do(method, URL, headers, nil)
 	if err != nil {
 		log.Error(err)
-		return 0 // no results
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
 	}
 
 	// Read the returned body
@@ -374,6 +550,63 @@ func hcSetTimeout(L *lua.LState) int {
 	return 0 // no results
 }
 
+// hcSetRetry is a Lua function for retrying requests on connection errors,
+// and on 5xx responses for idempotent methods. Takes the maximum number of
+// retries and the delay before the first retry, in milliseconds, which is
+// doubled for every retry.
+func hcSetRetry(L *lua.LState) int {
+	hc := checkHTTPClientClass(L) // arg 1
+	retries := L.CheckInt(2)      // arg 2
+	delay := L.OptInt(3, 100)     // arg 3 (optional)
+	if retries < 0 {
+		L.ArgError(2, "Expected a number of retries")
+		return 0 // no results
+	}
+	if delay < 0 {
+		L.ArgError(3, "Expected a delay (in milliseconds)")
+		return 0 // no results
+	}
+
+	hc.retries = retries
+	hc.retryDelay = time.Duration(delay) * time.Millisecond
+
+	return 0 // no results
+}
+
+// hcSetCircuitBreaker is a Lua function for making requests fail fast while
+// the circuit breaker with the given name is open. See CircuitBreaker.
+func hcSetCircuitBreaker(L *lua.LState) int {
+	hc := checkHTTPClientClass(L) // arg 1
+	name := L.CheckString(2)      // arg 2
+
+	hc.breaker = breaker.Get(name)
+
+	return 0 // no results
+}
+
+// hcSetResolver is a Lua function for setting how host names are resolved.
+// Takes "doh" and the URL of a DNS over HTTPS server, or "system" for using
+// the system resolver, which is the default.
+func hcSetResolver(L *lua.LState) int {
+	hc := checkHTTPClientClass(L) // arg 1
+	kind := L.CheckString(2)      // arg 2
+	switch kind {
+	case "system":
+		hc.resolver = nil
+	case "doh":
+		endpoint := L.CheckString(3) // arg 3
+		resolver, err := NewDoHResolver(endpoint)
+		if err != nil {
+			L.ArgError(3, err.Error())
+			return 0 // no results
+		}
+		hc.resolver = resolver
+	default:
+		L.ArgError(2, "Expected \"doh\" or \"system\"")
+	}
+	return 0 // no results
+}
+
 // hcSetLanguage is a Lua function for setting the desired language
 // for HTTP request.
 func hcSetLanguage(L *lua.LState) int {
@@ -391,21 +624,30 @@ func hcSetLanguage(L *lua.LState) int {
 
 // The hash map methods that are to be registered
 var hcMethods = map[string]lua.LGFunction{
-	"__tostring":   hcString,
-	"SetLanguage":  hcSetLanguage,
-	"SetTimeout":   hcSetTimeout,
-	"SetCookie":    hcSetCookie,
-	"SetUserAgent": hcSetUserAgent,
-	"SetInvalid":   hcSetInvalid,
-	"GET":          hcGet,
-	"POST":         hcPost,
-	"DO":           hcDo,
+	"__tostring":        hcString,
+	"SetLanguage":       hcSetLanguage,
+	"SetTimeout":        hcSetTimeout,
+	"SetRetry":          hcSetRetry,
+	"SetCircuitBreaker": hcSetCircuitBreaker,
+	"SetResolver":       hcSetResolver,
+	"SetCookie":         hcSetCookie,
+	"SetUserAgent":      hcSetUserAgent,
+	"SetInvalid":        hcSetInvalid,
+	"GET":               hcGet,
+	"POST":              hcPost,
+	"DO":                hcDo,
 
 	// TODO: Consider also implementing support for cookies
 }
 
 // Load makes functions related to httpclient available to the given Lua state
 func Load(L *lua.LState, userAgent string) {
+	LoadWithHeaders(L, userAgent, nil)
+}
+
+// LoadWithHeaders makes functions related to httpclient available to the given
+// Lua state. The given headers are sent with every request.
+func LoadWithHeaders(L *lua.LState, userAgent string, headers map[string]string) {
 
 	// Register the HTTPClient class and the methods that belongs with it.
 	metaTableHC := L.NewTypeMetatable(HTTPClientClass)
@@ -415,7 +657,7 @@ func Load(L *lua.LState, userAgent string) {
 	// The constructor for HTTPClient
 	L.SetGlobal("HTTPClient", L.NewFunction(func(L *lua.LState) int {
 		// Construct a new HTTPClient
-		userdata, err := constructHTTPClient(L, userAgent)
+		userdata, err := constructHTTPClient(L, userAgent, headers)
 		if err != nil {
 			log.Error(err)
 			return 0 // Number of returned values
@@ -426,10 +668,26 @@ func Load(L *lua.LState, userAgent string) {
 		return 1 // number of results
 	}))
 
+	// Register the GraphQL class and the methods that belongs with it.
+	metaTableGQL := L.NewTypeMetatable(GraphQLClass)
+	metaTableGQL.RawSetH(lua.LString("__index"), metaTableGQL)
+	L.SetFuncs(metaTableGQL, gqlMethods)
+
+	// The constructor for GraphQL
+	L.SetGlobal("GraphQL", L.NewFunction(func(L *lua.LState) int {
+		userdata, err := constructGraphQL(L, userAgent, headers)
+		if err != nil {
+			log.Error(err)
+			return 0 // Number of returned values
+		}
+		L.Push(userdata)
+		return 1 // number of results
+	}))
+
 	// Make a HTTP GET request to the given URL
 	L.SetGlobal("GET", L.NewFunction(func(L *lua.LState) int {
 		// Construct a new HTTPClient
-		userdata, err := constructHTTPClient(L, userAgent)
+		userdata, err := constructHTTPClient(L, userAgent, headers)
 		if err != nil {
 			log.Error(err)
 			return 0 // Number of returned values
@@ -441,7 +699,7 @@ func Load(L *lua.LState, userAgent string) {
 	// Make a HTTP POST request to the given URL
 	L.SetGlobal("POST", L.NewFunction(func(L *lua.LState) int {
 		// Construct a new HTTPClient
-		userdata, err := constructHTTPClient(L, userAgent)
+		userdata, err := constructHTTPClient(L, userAgent, headers)
 		if err != nil {
 			log.Error(err)
 			return 0 // Number of returned values
@@ -453,7 +711,7 @@ func Load(L *lua.LState, userAgent string) {
 	// Make a custom HTTP request to a given URL, like "PUT"
 	L.SetGlobal("DO", L.NewFunction(func(L *lua.LState) int {
 		// Construct a new HTTPClient
-		userdata, err := constructHTTPClient(L, userAgent)
+		userdata, err := constructHTTPClient(L, userAgent, headers)
 		if err != nil {
 			log.Error(err)
 			return 0 // Number of returned values
@@ -4,8 +4,11 @@ package jnode
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http" // For sending JSON requests
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -96,6 +99,136 @@ func jnodeGetString(L *lua.LState) int {
 	return 1 // number of results
 }
 
+// ErrNoValue is returned by the typed getters when the JSON path has no value
+var ErrNoValue = errors.New("no value at the given JSON path")
+
+// jsonType returns the name of the JSON type of the given node
+func jsonType(node *jpath.Node) string {
+	switch node.Interface().(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	default:
+		return "a number"
+	}
+}
+
+// typeError describes a JSON value that has the wrong type
+func typeError(jsonpath, expected string, node *jpath.Node) error {
+	return fmt.Errorf("the value at %s is not %s, but %s", jsonpath, expected, jsonType(node))
+}
+
+// Number returns the value of a JSON node as a number. Strings that contain
+// a number are converted.
+func Number(node *jpath.Node, jsonpath string) (float64, error) {
+	if node == nil || node.Interface() == nil {
+		return 0, ErrNoValue
+	}
+	if f, ok := node.CheckFloat64(); ok {
+		return f, nil
+	}
+	if s, ok := node.CheckString(); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, typeError(jsonpath, "a number", node)
+}
+
+// Bool returns the value of a JSON node as a boolean. Strings like "true"
+// and "false" are converted.
+func Bool(node *jpath.Node, jsonpath string) (bool, error) {
+	if node == nil || node.Interface() == nil {
+		return false, ErrNoValue
+	}
+	if b, ok := node.CheckBool(); ok {
+		return b, nil
+	}
+	if s, ok := node.CheckString(); ok {
+		if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			return b, nil
+		}
+	}
+	return false, typeError(jsonpath, "a boolean", node)
+}
+
+// Array returns the value of a JSON node as a Lua table, if it is a JSON array
+func Array(L *lua.LState, node *jpath.Node, jsonpath string) (*lua.LTable, error) {
+	if node == nil || node.Interface() == nil {
+		return nil, ErrNoValue
+	}
+	list, ok := node.CheckList()
+	if !ok {
+		return nil, typeError(jsonpath, "an array", node)
+	}
+	table := L.NewTable()
+	for _, element := range list {
+		table.Append(convert.Interface2LValue(L, element))
+	}
+	return table, nil
+}
+
+// Takes a JNode and a JSON path.
+// Returns a number, or nil and an error message.
+func jnodeGetNumber(L *lua.LState) int {
+	jnode := checkJNode(L) // arg 1
+	jsonpath := L.ToString(2)
+	if jsonpath == "" {
+		L.ArgError(2, "JSON path expected")
+	}
+	f, err := Number(jnode.GetNode(jsonpath), jsonpath)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(lua.LNumber(f))
+	return 1 // number of results
+}
+
+// Takes a JNode and a JSON path.
+// Returns a bool, or nil and an error message.
+func jnodeGetBool(L *lua.LState) int {
+	jnode := checkJNode(L) // arg 1
+	jsonpath := L.ToString(2)
+	if jsonpath == "" {
+		L.ArgError(2, "JSON path expected")
+	}
+	b, err := Bool(jnode.GetNode(jsonpath), jsonpath)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(lua.LBool(b))
+	return 1 // number of results
+}
+
+// Takes a JNode and a JSON path.
+// Returns a table, or nil and an error message.
+func jnodeGetArray(L *lua.LState) int {
+	jnode := checkJNode(L) // arg 1
+	jsonpath := L.ToString(2)
+	if jsonpath == "" {
+		L.ArgError(2, "JSON path expected")
+	}
+	table, err := Array(L, jnode.GetNode(jsonpath), jsonpath)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(table)
+	return 1 // number of results
+}
+
 // Take a JNode, a JSON path and a string.
 // Returns nothing
 func jnodeSet(L *lua.LState) int {
@@ -157,6 +290,21 @@ func jnodeJSONcompact(L *lua.LState) int {
 	return 1 // number of results
 }
 
+// Given a JNode and a table of JSON paths, return a JSON document with
+// only the values at the given paths. Missing paths are left out.
+func jnodePick(L *lua.LState) int {
+	jnode := checkJNode(L) // arg 1
+	paths := convert.Table2strings(L.CheckTable(2))
+	data, err := Pick(jnode, paths)
+	if err != nil {
+		log.Error(err)
+		L.Push(lua.LString(""))
+		return 1 // number of results
+	}
+	L.Push(lua.LString(string(data)))
+	return 1 // number of results
+}
+
 // Send JSON to host. First argument: URL
 // Second argument (optional) Auth token.
 // Returns a string that starts with FAIL if it fails.
@@ -341,6 +489,10 @@ var jnodeMethods = map[string]lua.LGFunction{
 	"add":        jnodeAdd,
 	"get":        jnodeGetNode,
 	"getstring":  jnodeGetString,
+	"getnumber":  jnodeGetNumber,
+	"getbool":    jnodeGetBool,
+	"getarray":   jnodeGetArray,
+	"pick":       jnodePick,
 	"set":        jnodeSet,
 	"delkey":     jnodeDelKey,
 	"pretty":     jnodeJSON,
@@ -0,0 +1,118 @@
+package jnode
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/jpath"
+)
+
+type (
+	// pickMap and pickList are the containers that are created when picking
+	// values. Other maps and lists in the result are from the original document.
+	pickMap  map[string]interface{}
+	pickList []interface{}
+)
+
+// pathStep is one step of a JSON path, either a key or a list index
+type pathStep struct {
+	key   string
+	index int
+}
+
+// splitPath splits a JSON path like "x.users[1].name" into steps
+func splitPath(JSONpath string) ([]pathStep, bool) {
+	// Only strip "x" when it is the root of the path, not the start of a key
+	switch {
+	case JSONpath == "x":
+		JSONpath = ""
+	case strings.HasPrefix(JSONpath, "x."), strings.HasPrefix(JSONpath, "x["):
+		JSONpath = JSONpath[1:]
+	}
+	JSONpath = strings.TrimPrefix(JSONpath, ".")
+	var steps []pathStep
+	if JSONpath == "" {
+		return steps, true
+	}
+	for _, part := range strings.Split(JSONpath, ".") {
+		name := part
+		if pos := strings.Index(part, "["); pos >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, false
+			}
+			index, err := strconv.Atoi(part[pos+1 : len(part)-1])
+			if err != nil || index < 0 {
+				return nil, false
+			}
+			name = part[:pos]
+			if name != "" {
+				steps = append(steps, pathStep{key: name, index: -1})
+			}
+			steps = append(steps, pathStep{index: index})
+			continue
+		}
+		if name == "" {
+			return nil, false
+		}
+		steps = append(steps, pathStep{key: name, index: -1})
+	}
+	return steps, true
+}
+
+// place stores the value in the container at the given steps, creating
+// maps and lists as needed. Returns the container.
+func place(container interface{}, steps []pathStep, value interface{}) interface{} {
+	if len(steps) == 0 {
+		return value
+	}
+	step := steps[0]
+	if step.index < 0 {
+		m, ok := container.(pickMap)
+		if !ok {
+			if container != nil {
+				// The original value, including this path, is already picked
+				return container
+			}
+			m = make(pickMap)
+		}
+		m[step.key] = place(m[step.key], steps[1:], value)
+		return m
+	}
+	l, ok := container.(*pickList)
+	if !ok {
+		if container != nil {
+			return container
+		}
+		l = &pickList{}
+	}
+	for len(*l) <= step.index {
+		*l = append(*l, nil)
+	}
+	(*l)[step.index] = place((*l)[step.index], steps[1:], value)
+	return l
+}
+
+// Pick returns a JSON document with only the values at the given JSON paths.
+// Paths that are invalid or have no value are left out.
+func Pick(node *jpath.Node, paths []string) ([]byte, error) {
+	var result interface{}
+	for _, JSONpath := range paths {
+		steps, ok := splitPath(JSONpath)
+		if !ok {
+			continue
+		}
+		value := node.GetNode(JSONpath).Interface()
+		if len(steps) == 0 {
+			value = node.Interface()
+		}
+		if value == nil {
+			continue
+		}
+		result = place(result, steps, value)
+	}
+	if result == nil {
+		result = pickMap{}
+	}
+	return json.Marshal(result)
+}
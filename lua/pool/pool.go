@@ -14,6 +14,22 @@ import (
 type LStatePool struct {
 	m     sync.Mutex
 	saved []*lua.LState
+
+	// The maximum number of idle Lua states to keep, 0 for no limit
+	max int
+
+	// Statistics
+	inUse   int
+	created uint64
+	reused  uint64
+}
+
+// Stats contains statistics for a Lua state pool
+type Stats struct {
+	InUse   int    // Lua states that are currently borrowed
+	Idle    int    // Lua states that are ready to be borrowed
+	Created uint64 // Lua states that have been created
+	Reused  uint64 // Times an existing Lua state has been borrowed
 }
 
 // New returns a new Lua pool structure
@@ -26,27 +42,81 @@ func (pl *LStatePool) New() *lua.LState {
 	L := lua.NewState()
 	// setting the L up here.
 	// load scripts, set global variables, share channels, etc...
+	pl.m.Lock()
+	pl.created++
+	pl.m.Unlock()
 	return L
 }
 
 // Get borrows an existing Lua state
 func (pl *LStatePool) Get() *lua.LState {
 	pl.m.Lock()
-	defer pl.m.Unlock()
 	n := len(pl.saved)
+	pl.inUse++
 	if n == 0 {
+		pl.m.Unlock()
 		return pl.New()
 	}
 	x := pl.saved[n-1]
 	pl.saved = pl.saved[0 : n-1]
+	pl.reused++
+	pl.m.Unlock()
 	return x
 }
 
-// Put delivers back a borrowed Lua state
+// Put delivers back a borrowed Lua state.
+// If there already are max idle Lua states in the pool, the state is closed.
 func (pl *LStatePool) Put(L *lua.LState) {
 	pl.m.Lock()
-	defer pl.m.Unlock()
+	if pl.inUse > 0 {
+		pl.inUse--
+	}
+	if pl.max > 0 && len(pl.saved) >= pl.max {
+		pl.m.Unlock()
+		L.Close()
+		return
+	}
 	pl.saved = append(pl.saved, L)
+	pl.m.Unlock()
+}
+
+// SetSize creates Lua states until there are at least min idle states in the
+// pool, and sets the maximum number of idle Lua states to keep (0 for no limit).
+func (pl *LStatePool) SetSize(min, max int) {
+	if max > 0 && min > max {
+		min = max
+	}
+	pl.m.Lock()
+	pl.max = max
+	var dropped []*lua.LState
+	if max > 0 && len(pl.saved) > max {
+		dropped = pl.saved[max:]
+		pl.saved = pl.saved[:max:max]
+	}
+	missing := min - len(pl.saved)
+	pl.m.Unlock()
+	// Close the idle Lua states that no longer fit in the pool
+	for _, L := range dropped {
+		L.Close()
+	}
+	for i := 0; i < missing; i++ {
+		L := pl.New()
+		pl.m.Lock()
+		pl.saved = append(pl.saved, L)
+		pl.m.Unlock()
+	}
+}
+
+// Stats returns statistics for the pool
+func (pl *LStatePool) Stats() Stats {
+	pl.m.Lock()
+	defer pl.m.Unlock()
+	return Stats{
+		InUse:   pl.inUse,
+		Idle:    len(pl.saved),
+		Created: pl.created,
+		Reused:  pl.reused,
+	}
 }
 
 // Shutdown can be used then the Lua pool is being shut down
@@ -0,0 +1,152 @@
+package pure
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// The exit code that is returned if a command could not be started, or timed out
+	failedExitCode = -1
+
+	// The Python interpreter that is used by py() if no interpreter is given
+	defaultPythonInterpreter = "python"
+)
+
+// interpreterExists checks if the given interpreter exists, either as a path
+// (relative to the given directory) or as an executable in the PATH
+func interpreterExists(dir, interpreter string) bool {
+	if strings.ContainsRune(interpreter, filepath.Separator) || strings.ContainsRune(interpreter, '/') {
+		if !filepath.IsAbs(interpreter) {
+			interpreter = filepath.Join(dir, interpreter)
+		}
+		fInfo, err := os.Stat(interpreter)
+		return err == nil && !fInfo.IsDir()
+	}
+	_, err := exec.LookPath(interpreter)
+	return err == nil
+}
+
+// scriptDir calls the scriptdir() Lua function, if available, to find the
+// directory that commands should run in
+func scriptDir(L *lua.LState) string {
+	fn, ok := L.GetGlobal("scriptdir").(*lua.LFunction)
+	if !ok {
+		return ""
+	}
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+		return ""
+	}
+	dir := L.Get(-1).String()
+	L.Pop(1)
+	return dir
+}
+
+// splitLines splits the output from a command into lines, without a final empty line
+func splitLines(output []byte) []string {
+	s := strings.TrimRight(string(output), "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+}
+
+// RunCommand runs the given program with the given arguments, in the given
+// directory, without a shell. The process is killed if the timeout is reached.
+// A timeout of 0 means no timeout. Returns the output lines, the error lines
+// and the exit code, which is -1 if the program could not run to completion.
+func RunCommand(dir string, timeout time.Duration, program string, args ...string) ([]string, []string, int) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	exitCode := 0
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Warn(program, " was killed after ", timeout)
+			exitCode = failedExitCode
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			// ExitError.ExitCode needs Go 1.12
+			exitCode = failedExitCode
+			if status, ok := exitErr.Sys().(interface{ ExitStatus() int }); ok {
+				exitCode = status.ExitStatus()
+			}
+		} else {
+			log.Error("Could not run ", program, ": ", err)
+			exitCode = failedExitCode
+		}
+	}
+	return splitLines(stdout.Bytes()), splitLines(stderr.Bytes()), exitCode
+}
+
+// LoadCommands makes functions for running commands available to the given Lua state
+func LoadCommands(L *lua.LState) {
+
+	// Run a program with a table of arguments, without using a shell.
+	// Takes an optional timeout in seconds. Returns the output lines and the exit code.
+	L.SetGlobal("exec", L.NewFunction(func(L *lua.LState) int {
+		program := L.CheckString(1)
+		var args []string
+		if L.GetTop() >= 2 && L.Get(2) != lua.LNil {
+			args = convert.Table2strings(L.CheckTable(2))
+		}
+		timeout := time.Duration(float64(L.OptNumber(3, 0)) * float64(time.Second))
+		stdout, _, exitCode := RunCommand(scriptDir(L), timeout, program, args...)
+		L.Push(convert.Strings2table(L, stdout))
+		L.Push(lua.LNumber(exitCode))
+		return 2 // number of results
+	}))
+
+	// Given the name of a python script in the same directory, an optional
+	// table of arguments and an optional interpreter, like "python3" or the
+	// path to python in a virtualenv, return the outputted lines as a table.
+	L.SetGlobal("py", L.NewFunction(func(L *lua.LState) int {
+		if L.GetTop() == 0 || L.Get(1) == lua.LNil {
+			L.Push(L.NewTable())
+			return 1 // number of results
+		}
+		dir := scriptDir(L)
+		args := []string{filepath.Join(dir, L.CheckString(1))}
+		if L.GetTop() >= 2 && L.Get(2) != lua.LNil {
+			args = append(args, convert.Table2strings(L.CheckTable(2))...)
+		}
+		interpreter := L.OptString(3, defaultPythonInterpreter)
+		if !interpreterExists(dir, interpreter) {
+			log.Error("Could not find the Python interpreter: ", interpreter)
+			L.Push(L.NewTable())
+			return 1 // number of results
+		}
+		stdout, _, _ := RunCommand(dir, 0, interpreter, args...)
+		L.Push(convert.Strings2table(L, stdout))
+		return 1 // number of results
+	}))
+
+	// Run one or more commands with a shell, like run(), in the directory of
+	// the script. Returns the output lines, the error lines and the exit code.
+	L.SetGlobal("run2", L.NewFunction(func(L *lua.LState) int {
+		command := L.CheckString(1)
+		stdout, stderr, exitCode := RunCommand(scriptDir(L), 0, "sh", "-c", command)
+		L.Push(convert.Strings2table(L, stdout))
+		L.Push(convert.Strings2table(L, stderr))
+		L.Push(lua.LNumber(exitCode))
+		return 3 // number of results
+	}))
+
+}
@@ -0,0 +1,100 @@
+package pure
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// Hash functions that can be used with Hash, by name
+var hashFunctions = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Hash returns the hex encoded hash of the given data, using the given
+// algorithm: "md5", "sha1", "sha256" or "sha512"
+func Hash(algorithm string, data []byte) (string, error) {
+	newHash, ok := hashFunctions[algorithm]
+	if !ok {
+		return "", errors.New("unknown hash algorithm: " + algorithm)
+	}
+	h := newHash()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HMACSHA256 returns the HMAC-SHA256 of the given message, using the given key
+func HMACSHA256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// checkByteCount checks the first argument, which is a number of bytes
+func checkByteCount(L *lua.LState) int {
+	n := L.CheckInt(1)
+	if n < 0 {
+		L.ArgError(1, "the number of bytes can not be negative")
+	}
+	return n
+}
+
+// LoadCrypto makes functions for hashing, signing and for generating random data
+// available to the given Lua state
+func LoadCrypto(L *lua.LState) {
+
+	// Takes a key and a message. Returns the HMAC-SHA256 as a hex encoded string.
+	L.SetGlobal("hmac_sha256", L.NewFunction(func(L *lua.LState) int {
+		key := L.CheckString(1)
+		message := L.CheckString(2)
+		L.Push(lua.LString(hex.EncodeToString(HMACSHA256([]byte(key), []byte(message)))))
+		return 1 // number of results
+	}))
+
+	// Takes an algorithm and data. Returns the hash as a hex encoded string and
+	// an empty string, or an empty string and an error string.
+	L.SetGlobal("hash", L.NewFunction(func(L *lua.LState) int {
+		digest, err := Hash(L.CheckString(1), []byte(L.CheckString(2)))
+		if err != nil {
+			L.Push(lua.LString(""))
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LString(digest))
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+	// Return the given number of cryptographically random bytes, as a raw string
+	L.SetGlobal("random_bytes", L.NewFunction(func(L *lua.LState) int {
+		b := make([]byte, checkByteCount(L))
+		if _, err := rand.Read(b); err != nil {
+			L.RaiseError("could not generate random bytes: %s", err)
+		}
+		L.Push(lua.LString(string(b)))
+		return 1 // number of results
+	}))
+
+	// Return the given number of cryptographically random bytes, hex encoded.
+	// The returned string is twice as long as the number of bytes.
+	L.SetGlobal("random_hex", L.NewFunction(func(L *lua.LState) int {
+		b := make([]byte, checkByteCount(L))
+		if _, err := rand.Read(b); err != nil {
+			L.RaiseError("could not generate random bytes: %s", err)
+		}
+		L.Push(lua.LString(hex.EncodeToString(b)))
+		return 1 // number of results
+	}))
+
+}
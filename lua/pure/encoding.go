@@ -0,0 +1,68 @@
+package pure
+
+import (
+	"encoding/base64"
+	"net/url"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// decodeResult pushes the decoded string and an empty error string, or an
+// empty string and an error string, and returns the number of results
+func decodeResult(L *lua.LState, decoded string, err error) int {
+	if err != nil {
+		L.Push(lua.LString(""))
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(lua.LString(decoded))
+	L.Push(lua.LString(""))
+	return 2 // number of results
+}
+
+// LoadEncoding makes functions for base64 and URL encoding available to the given Lua state
+func LoadEncoding(L *lua.LState) {
+
+	// Encode a string with standard base64, with padding
+	L.SetGlobal("base64encode", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(base64.StdEncoding.EncodeToString([]byte(L.CheckString(1)))))
+		return 1 // number of results
+	}))
+
+	// Decode a string with standard base64. Returns the decoded string and an error string.
+	L.SetGlobal("base64decode", L.NewFunction(func(L *lua.LState) int {
+		decoded, err := base64.StdEncoding.DecodeString(L.CheckString(1))
+		return decodeResult(L, string(decoded), err)
+	}))
+
+	// Encode a string with URL-safe base64, without padding
+	L.SetGlobal("base64urlencode", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(base64.RawURLEncoding.EncodeToString([]byte(L.CheckString(1)))))
+		return 1 // number of results
+	}))
+
+	// Decode a string with URL-safe base64, with or without padding.
+	// Returns the decoded string and an error string.
+	L.SetGlobal("base64urldecode", L.NewFunction(func(L *lua.LState) int {
+		s := L.CheckString(1)
+		for len(s)%4 != 0 {
+			s += "="
+		}
+		decoded, err := base64.URLEncoding.DecodeString(s)
+		return decodeResult(L, string(decoded), err)
+	}))
+
+	// Escape a string so that it can be used as a query parameter in a URL
+	L.SetGlobal("urlencode", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(url.QueryEscape(L.CheckString(1))))
+		return 1 // number of results
+	}))
+
+	// Unescape a string that has been escaped with urlencode.
+	// Returns the decoded string and an error string.
+	L.SetGlobal("urldecode", L.NewFunction(func(L *lua.LState) int {
+		decoded, err := url.QueryUnescape(L.CheckString(1))
+		return decodeResult(L, decoded, err)
+	}))
+
+}
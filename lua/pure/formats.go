@@ -0,0 +1,99 @@
+package pure
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/BurntSushi/toml"
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+	"gopkg.in/yaml.v2"
+)
+
+// errTOMLTable is returned when trying to encode something else than a map as TOML
+var errTOMLTable = errors.New("TOML data must be a table with string keys")
+
+// formatResult pushes the given value, or nil and an error string,
+// and returns the number of results
+func formatResult(L *lua.LState, value lua.LValue, err error) int {
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(value)
+	return 1 // number of results
+}
+
+// LoadFormats makes functions for decoding and encoding YAML, TOML and XML
+// available to the given Lua state
+func LoadFormats(L *lua.LState) {
+
+	// Decode a YAML string to a Lua value. Returns nil and an error string on failure.
+	L.SetGlobal("yaml_decode", L.NewFunction(func(L *lua.LState) int {
+		var data interface{}
+		if err := yaml.Unmarshal([]byte(L.CheckString(1)), &data); err != nil {
+			return formatResult(L, nil, err)
+		}
+		return formatResult(L, convert.Interface2LValue(L, data), nil)
+	}))
+
+	// Encode a Lua value as YAML. Returns nil and an error string on failure.
+	L.SetGlobal("yaml_encode", L.NewFunction(func(L *lua.LState) int {
+		value, err := convert.LValue2Interface(L.CheckAny(1))
+		if err != nil {
+			return formatResult(L, nil, err)
+		}
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return formatResult(L, nil, err)
+		}
+		return formatResult(L, lua.LString(data), nil)
+	}))
+
+	// Decode a TOML string to a table. Returns nil and an error string on failure.
+	L.SetGlobal("toml_decode", L.NewFunction(func(L *lua.LState) int {
+		var data map[string]interface{}
+		if _, err := toml.Decode(L.CheckString(1), &data); err != nil {
+			return formatResult(L, nil, err)
+		}
+		return formatResult(L, convert.Interface2LValue(L, data), nil)
+	}))
+
+	// Encode a table as TOML. Returns nil and an error string on failure.
+	L.SetGlobal("toml_encode", L.NewFunction(func(L *lua.LState) int {
+		value, err := convert.LValue2Interface(L.CheckTable(1))
+		if err != nil {
+			return formatResult(L, nil, err)
+		}
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return formatResult(L, nil, errTOMLTable)
+		}
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(data); err != nil {
+			return formatResult(L, nil, err)
+		}
+		return formatResult(L, lua.LString(buf.String()), nil)
+	}))
+
+	// Decode an XML document to a table with the root element.
+	// Returns nil and an error string on failure.
+	L.SetGlobal("xml_decode", L.NewFunction(func(L *lua.LState) int {
+		element, err := XML2table(L, L.CheckString(1))
+		if err != nil {
+			return formatResult(L, nil, err)
+		}
+		return formatResult(L, element, nil)
+	}))
+
+	// Encode a table with an element as XML. Returns nil and an error string on failure.
+	L.SetGlobal("xml_encode", L.NewFunction(func(L *lua.LState) int {
+		data, err := Table2XML(L.CheckTable(1))
+		if err != nil {
+			return formatResult(L, nil, err)
+		}
+		return formatResult(L, lua.LString(data), nil)
+	}))
+
+}
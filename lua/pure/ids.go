@@ -0,0 +1,101 @@
+package pure
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// The characters that are used by nanoid, 64 in total
+	nanoidAlphabet = "useandom-26T198340PX75pxJACKVERYMINDBUSHWOLF_GQZbfghjklqvwyzrict"
+
+	// The default length of a nanoid
+	nanoidDefaultLength = 21
+)
+
+// formatUUID formats 16 bytes as a UUID string
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+// UUIDv4 returns a random UUID (version 4)
+func UUIDv4() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return formatUUID(b), nil
+}
+
+// UUIDv7 returns a time-ordered UUID (version 7), where the first 48 bits are
+// the number of milliseconds since 1970 and the rest is random
+func UUIDv7() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(b[0:6], ms[2:8])
+	b[6] = (b[6] & 0x0f) | 0x70 // version 7
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return formatUUID(b), nil
+}
+
+// Nanoid returns a random URL-friendly ID of the given length
+func Nanoid(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = nanoidAlphabet[b[i]&63]
+	}
+	return string(b), nil
+}
+
+// LoadIDs makes functions for generating unique IDs available to the given Lua state
+func LoadIDs(L *lua.LState) {
+
+	// Return a random UUID (version 4)
+	L.SetGlobal("uuid", L.NewFunction(func(L *lua.LState) int {
+		id, err := UUIDv4()
+		if err != nil {
+			L.RaiseError("could not generate a UUID: %s", err)
+		}
+		L.Push(lua.LString(id))
+		return 1 // number of results
+	}))
+
+	// Return a time-ordered UUID (version 7)
+	L.SetGlobal("uuidv7", L.NewFunction(func(L *lua.LState) int {
+		id, err := UUIDv7()
+		if err != nil {
+			L.RaiseError("could not generate a UUID: %s", err)
+		}
+		L.Push(lua.LString(id))
+		return 1 // number of results
+	}))
+
+	// Return a random URL-friendly ID, with an optional length (the default is 21)
+	L.SetGlobal("nanoid", L.NewFunction(func(L *lua.LState) int {
+		length := L.OptInt(1, nanoidDefaultLength)
+		if length < 1 {
+			L.ArgError(1, "the length must be positive")
+		}
+		id, err := Nanoid(length)
+		if err != nil {
+			L.RaiseError("could not generate a nanoid: %s", err)
+		}
+		L.Push(lua.LString(id))
+		return 1 // number of results
+	}))
+
+}
@@ -8,22 +8,6 @@ import (
 
 // Extra Lua functions
 const luacode = `
--- Given the name of a python script in the same directory,
--- return the outputted lines as a table
-function py(filename)
-  if filename == nil then
-    return {}
-  end
-  local cmd = "python " .. scriptdir() .. "/" .. filename
-  local f = assert(io.popen(cmd, 'r'))
-  local a = {}
-  for line in f:lines() do
-    table.insert(a, line)
-  end
-  f:close()
-  return a
-end
-
 -- Given the name of an executable (or executable script) in the same directory,
 -- return the outputted lines as a table
 function run(given_command)
@@ -54,9 +38,18 @@ end
 `
 
 // Load makes functions for running commands, python code or listing files to
-// the given Lua state struct: py, run and dir
+// the given Lua state struct: py, run, run2, exec and dir.
+// Also makes utility functions for generating IDs, signing, random data,
+// encoding, YAML, TOML, time and regular expressions available.
 func Load(L *lua.LState) {
 	if err := L.DoString(luacode); err != nil {
 		log.Errorf("Could not load extra Lua functions: %s", err)
 	}
+	LoadCommands(L)
+	LoadIDs(L)
+	LoadCrypto(L)
+	LoadEncoding(L)
+	LoadFormats(L)
+	LoadTime(L)
+	LoadRegex(L)
 }
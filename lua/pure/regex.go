@@ -0,0 +1,95 @@
+package pure
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/xyproto/algernon/lua/convert"
+	"github.com/xyproto/gopher-lua"
+)
+
+// The maximum number of compiled regular expressions to keep. Patterns may
+// be built from request data, so the cache is emptied when it is full.
+const regexCacheSize = 256
+
+// Compiled regular expressions, by pattern
+var (
+	regexCache      = make(map[string]*regexp.Regexp)
+	regexCacheMutex sync.Mutex
+)
+
+// compileRegex compiles the given pattern, or returns it from the cache
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	regexCacheMutex.Lock()
+	re, ok := regexCache[pattern]
+	regexCacheMutex.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCacheMutex.Lock()
+	if len(regexCache) >= regexCacheSize {
+		regexCache = make(map[string]*regexp.Regexp)
+	}
+	regexCache[pattern] = re
+	regexCacheMutex.Unlock()
+	return re, nil
+}
+
+// LoadRegex makes functions for regular expressions available to the given Lua state
+func LoadRegex(L *lua.LState) {
+
+	// Check if a string matches a regular expression.
+	// Returns a bool and an error string.
+	L.SetGlobal("regex_match", L.NewFunction(func(L *lua.LState) int {
+		re, err := compileRegex(L.CheckString(1))
+		if err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LBool(re.MatchString(L.CheckString(2))))
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+	// Find the first match of a regular expression in a string. Returns a table
+	// with the match followed by the captured groups, or nil if there is no
+	// match, and an error string.
+	L.SetGlobal("regex_find", L.NewFunction(func(L *lua.LState) int {
+		re, err := compileRegex(L.CheckString(1))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		matches := re.FindStringSubmatch(L.CheckString(2))
+		if matches == nil {
+			L.Push(lua.LNil)
+		} else {
+			L.Push(convert.Strings2table(L, matches))
+		}
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+	// Replace all matches of a regular expression in a string. The replacement
+	// may refer to captured groups with $1, $2 and so on.
+	// Returns the resulting string and an error string.
+	L.SetGlobal("regex_replace", L.NewFunction(func(L *lua.LState) int {
+		re, err := compileRegex(L.CheckString(1))
+		s := L.CheckString(2)
+		if err != nil {
+			L.Push(lua.LString(s))
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LString(re.ReplaceAllString(s, L.CheckString(3))))
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+}
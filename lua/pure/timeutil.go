@@ -0,0 +1,123 @@
+package pure
+
+import (
+	"errors"
+	"time"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+var errLayout = errors.New("the layout contains no date or time elements")
+
+// Named layouts that can be used instead of a Go time layout
+var namedLayouts = map[string]string{
+	"ANSIC":    time.ANSIC,
+	"RFC822":   time.RFC822,
+	"RFC822Z":  time.RFC822Z,
+	"RFC850":   time.RFC850,
+	"RFC1123":  time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"RFC3339":  time.RFC3339,
+	"Kitchen":  time.Kitchen,
+	"DateTime": "2006-01-02 15:04:05",
+	"DateOnly": "2006-01-02",
+	"TimeOnly": "15:04:05",
+}
+
+// timeLayout returns the Go time layout for the given layout or layout name.
+// The default layout is RFC3339.
+func timeLayout(layout string) (string, error) {
+	if layout == "" {
+		return time.RFC3339, nil
+	}
+	if namedLayout, ok := namedLayouts[layout]; ok {
+		return namedLayout, nil
+	}
+	// A layout without any of the reference time elements is most likely a mistake
+	if time.Unix(0, 0).UTC().Format(layout) == layout {
+		return "", errLayout
+	}
+	return layout, nil
+}
+
+// timeLocation returns the location for the given time zone name, like
+// "Europe/Oslo". The default is the local time zone.
+func timeLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// FormatTime formats the given number of seconds since 1970 with the given
+// layout, in the given time zone
+func FormatTime(unixSeconds float64, layout, zone string) (string, error) {
+	layout, err := timeLayout(layout)
+	if err != nil {
+		return "", err
+	}
+	loc, err := timeLocation(zone)
+	if err != nil {
+		return "", err
+	}
+	sec := int64(unixSeconds)
+	nsec := int64((unixSeconds - float64(sec)) * float64(time.Second))
+	return time.Unix(sec, nsec).In(loc).Format(layout), nil
+}
+
+// ParseTime parses the given string with the given layout, in the given time
+// zone (if the string has no time zone), and returns the number of seconds since 1970
+func ParseTime(s, layout, zone string) (float64, error) {
+	layout, err := timeLayout(layout)
+	if err != nil {
+		return 0, err
+	}
+	loc, err := timeLocation(zone)
+	if err != nil {
+		return 0, err
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.UnixNano()) / float64(time.Second), nil
+}
+
+// LoadTime makes functions for formatting and parsing time available to the given Lua state
+func LoadTime(L *lua.LState) {
+
+	// Return the current time, as the number of seconds since 1970 (with fractions)
+	L.SetGlobal("now", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(float64(time.Now().UnixNano()) / float64(time.Second)))
+		return 1 // number of results
+	}))
+
+	// Format a number of seconds since 1970 with a Go time layout or a layout
+	// name, in an optional time zone. Returns the string and an error string.
+	L.SetGlobal("formattime", L.NewFunction(func(L *lua.LState) int {
+		s, err := FormatTime(float64(L.CheckNumber(1)), L.OptString(2, ""), L.OptString(3, ""))
+		if err != nil {
+			L.Push(lua.LString(""))
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LString(s))
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+	// Parse a string with a Go time layout or a layout name, in an optional
+	// time zone. Returns the number of seconds since 1970 and an error string.
+	L.SetGlobal("parsetime", L.NewFunction(func(L *lua.LState) int {
+		seconds, err := ParseTime(L.CheckString(1), L.OptString(2, ""), L.OptString(3, ""))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		L.Push(lua.LNumber(seconds))
+		L.Push(lua.LString(""))
+		return 2 // number of results
+	}))
+
+}
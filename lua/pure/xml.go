@@ -0,0 +1,149 @@
+package pure
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/gopher-lua"
+)
+
+// XML elements are represented as Lua tables with these fields:
+//   name     - the element name, including the namespace prefix, like "soap:Body"
+//   attr     - a table with attribute names (also with prefixes) and values
+//   children - a list of child elements and text strings, in document order
+//   text     - the text directly within the element, with surrounding space trimmed
+// Namespace declarations are kept as attributes, like "xmlns:soap".
+
+var (
+	errXMLNoElement = errors.New("no XML element found")
+	errXMLName      = errors.New("XML elements must have a name")
+)
+
+// rawName returns the name as written in the document, like "soap:Body"
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// XML2table decodes an XML document and returns the root element as a Lua table
+func XML2table(L *lua.LState, data string) (*lua.LTable, error) {
+	decoder := xml.NewDecoder(strings.NewReader(data))
+	var (
+		stack []*lua.LTable
+		texts []*bytes.Buffer
+		root  *lua.LTable
+	)
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := L.NewTable()
+			element.RawSetString("name", lua.LString(rawName(t.Name)))
+			attr := L.NewTable()
+			for _, a := range t.Attr {
+				attr.RawSetString(rawName(a.Name), lua.LString(a.Value))
+			}
+			element.RawSetString("attr", attr)
+			element.RawSetString("children", L.NewTable())
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.RawGetString("children").(*lua.LTable).Append(element)
+			} else if root == nil {
+				root = element
+			}
+			stack = append(stack, element)
+			texts = append(texts, &bytes.Buffer{})
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, errors.New("unexpected end element: " + rawName(t.Name))
+			}
+			element := stack[len(stack)-1]
+			if name := rawName(t.Name); element.RawGetString("name").String() != name {
+				return nil, errors.New("mismatched end element: " + name)
+			}
+			element.RawSetString("text", lua.LString(strings.TrimSpace(texts[len(texts)-1].String())))
+			stack = stack[:len(stack)-1]
+			texts = texts[:len(texts)-1]
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			texts[len(texts)-1].Write(t)
+			if s := string(t); strings.TrimSpace(s) != "" {
+				stack[len(stack)-1].RawGetString("children").(*lua.LTable).Append(lua.LString(s))
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, errors.New("unclosed element: " + stack[len(stack)-1].RawGetString("name").String())
+	}
+	if root == nil {
+		return nil, errXMLNoElement
+	}
+	return root, nil
+}
+
+// encodeXMLElement writes an element, as represented by a Lua table, to the encoder
+func encodeXMLElement(encoder *xml.Encoder, element *lua.LTable) error {
+	name, ok := element.RawGetString("name").(lua.LString)
+	if !ok || name == "" {
+		return errXMLName
+	}
+	start := xml.StartElement{Name: xml.Name{Local: string(name)}}
+	if attr, ok := element.RawGetString("attr").(*lua.LTable); ok {
+		attr.ForEach(func(key, value lua.LValue) {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: key.String()}, Value: value.String()})
+		})
+		// Sort the attributes, for predictable output
+		sort.Slice(start.Attr, func(i, j int) bool {
+			return start.Attr[i].Name.Local < start.Attr[j].Name.Local
+		})
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	children, hasChildren := element.RawGetString("children").(*lua.LTable)
+	if hasChildren && children.Len() > 0 {
+		for i := 1; i <= children.Len(); i++ {
+			switch child := children.RawGetInt(i).(type) {
+			case *lua.LTable:
+				if err := encodeXMLElement(encoder, child); err != nil {
+					return err
+				}
+			case lua.LString, lua.LNumber, lua.LBool:
+				if err := encoder.EncodeToken(xml.CharData(child.String())); err != nil {
+					return err
+				}
+			}
+		}
+	} else if text := element.RawGetString("text"); text != lua.LNil {
+		if err := encoder.EncodeToken(xml.CharData(text.String())); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// Table2XML encodes an element, as represented by a Lua table, as XML
+func Table2XML(element *lua.LTable) (string, error) {
+	var buf bytes.Buffer
+	encoder := xml.NewEncoder(&buf)
+	if err := encodeXMLElement(encoder, element); err != nil {
+		return "", err
+	}
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
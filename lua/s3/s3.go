@@ -0,0 +1,405 @@
+// Package s3 provides Lua functions for storing objects in S3 compatible object storage
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xyproto/algernon/lua/upload"
+	"github.com/xyproto/gopher-lua"
+)
+
+const (
+	// Class is an identifier for the S3 class in Lua
+	Class = "S3"
+
+	// The region that is used if no region is given
+	defaultRegion = "us-east-1"
+
+	// The timeout for requests to the object storage
+	defaultTimeout = 30 * time.Second
+
+	// The longest time a presigned URL can be valid, according to the S3 API
+	maxPresignSeconds = 7 * 24 * 60 * 60
+
+	// Formats for the timestamps in signatures
+	amzDateFormat  = "20060102T150405Z"
+	amzShortFormat = "20060102"
+
+	// The payload hash for presigned URLs
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// ErrNotFound is returned when getting an object that does not exist
+var ErrNotFound = errors.New("not found")
+
+// Client is a client for a bucket in S3 compatible object storage,
+// which signs the requests with AWS Signature Version 4
+type Client struct {
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	bucket    string
+	region    string
+	pathStyle bool
+	client    *http.Client
+}
+
+// New creates a new Client for the given endpoint, like "https://s3.amazonaws.com"
+// or "http://localhost:9000", and bucket. Path-style addressing is used, unless
+// the endpoint is an amazonaws.com host name. This can be changed with SetPathStyle.
+func New(endpoint, accessKey, secretKey, bucket string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("the S3 endpoint must be a http or https URL: %s", endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("no S3 bucket name given")
+	}
+	return &Client{
+		endpoint:  u,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		bucket:    bucket,
+		region:    defaultRegion,
+		pathStyle: !strings.HasSuffix(u.Hostname(), ".amazonaws.com"),
+		client:    &http.Client{Timeout: defaultTimeout},
+	}, nil
+}
+
+// SetRegion sets the region that is used when signing requests
+func (c *Client) SetRegion(region string) {
+	c.region = region
+}
+
+// SetPathStyle selects path-style addressing ("host/bucket/key") if true,
+// or virtual-host addressing ("bucket.host/key") if false
+func (c *Client) SetPathStyle(pathStyle bool) {
+	c.pathStyle = pathStyle
+}
+
+// uriEncode encodes the given string as required by the signature,
+// optionally leaving slashes as they are
+func uriEncode(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && keepSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// objectURL returns the host and the encoded path for the given object key
+func (c *Client) objectURL(key string) (host, encodedPath string) {
+	host = c.endpoint.Host
+	basePath := strings.TrimSuffix(c.endpoint.Path, "/")
+	if c.pathStyle {
+		return host, basePath + "/" + uriEncode(c.bucket, false) + "/" + uriEncode(key, true)
+	}
+	return c.bucket + "." + host, basePath + "/" + uriEncode(key, true)
+}
+
+// canonicalQuery sorts and encodes the given query values
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range values[key] {
+			parts = append(parts, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the given data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sha256Hex returns the SHA256 of the given data, as hex digits
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// scope returns the credential scope for the given time
+func (c *Client) scope(t time.Time) string {
+	return t.Format(amzShortFormat) + "/" + c.region + "/s3/aws4_request"
+}
+
+// signature signs the given canonical request
+func (c *Client) signature(t time.Time, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format(amzDateFormat) + "\n" + c.scope(t) + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), t.Format(amzShortFormat))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// presign returns an URL for the given method and object key that is valid
+// for the given duration, from the given time
+func (c *Client) presign(method, key string, expires time.Duration, t time.Time) string {
+	t = t.UTC()
+	host, encodedPath := c.objectURL(key)
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", c.accessKey+"/"+c.scope(t))
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	canonicalRequest := strings.Join([]string{
+		method,
+		encodedPath,
+		canonicalQuery(query),
+		"host:" + host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	return c.endpoint.Scheme + "://" + host + encodedPath + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + c.signature(t, canonicalRequest)
+}
+
+// Presign returns an URL for getting the given object without credentials,
+// that is valid for the given duration
+func (c *Client) Presign(key string, expires time.Duration) string {
+	return c.presign(http.MethodGet, key, expires, time.Now())
+}
+
+// do sends a signed request for the given object key
+func (c *Client) do(method, key string, body []byte, contentType string) (*http.Response, error) {
+	t := time.Now().UTC()
+	host, encodedPath := c.objectURL(key)
+	payloadHash := sha256Hex(body)
+	req, err := http.NewRequest(method, c.endpoint.Scheme+"://"+host+encodedPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// Keep the encoding of the key, as it is used in the signature
+	req.URL.RawPath = encodedPath
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + t.Format(amzDateFormat) + "\n"
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+		signedHeaders = "content-type;" + signedHeaders
+		canonicalHeaders = "content-type:" + contentType + "\n" + canonicalHeaders
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		encodedPath,
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+c.scope(t)+", SignedHeaders="+signedHeaders+", Signature="+c.signature(t, canonicalRequest))
+	return c.client.Do(req)
+}
+
+// responseError returns an error for the given response, if it is not a success
+func responseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// Put stores the given data as the given object key
+func (c *Client) Put(key string, data []byte, contentType string) error {
+	resp, err := c.do(http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// Get returns the data for the given object key.
+// ErrNotFound is returned if the object does not exist.
+func (c *Client) Get(key string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Delete removes the given object key
+func (c *Client) Delete(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// Get the first argument, "self", and cast it from userdata to a Client
+func checkS3(L *lua.LState) *Client {
+	ud := L.CheckUserData(1)
+	if c, ok := ud.Value.(*Client); ok {
+		return c
+	}
+	L.ArgError(1, "S3 expected")
+	return nil
+}
+
+// result pushes true, or false and an error string, and returns the number of results
+func result(L *lua.LState, err error) int {
+	if err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(lua.LTrue)
+	return 1 // number of results
+}
+
+// Takes an object key, the data as a string or an UploadedFile, and an
+// optional content type. Returns true, or false and an error string.
+func s3Put(L *lua.LState) int {
+	c := checkS3(L)         // arg 1
+	key := L.CheckString(2) // arg 2
+	contentType := L.OptString(4, "")
+	var data []byte
+	switch v := L.CheckAny(3).(type) {
+	case lua.LString:
+		data = []byte(v)
+	case *lua.LUserData:
+		ulf, ok := v.Value.(*upload.UploadedFile)
+		if !ok {
+			L.ArgError(3, "string or UploadedFile expected")
+			return 0 // number of results
+		}
+		data = ulf.Bytes()
+		if contentType == "" {
+			contentType = ulf.MimeType()
+		}
+	default:
+		L.ArgError(3, "string or UploadedFile expected")
+		return 0 // number of results
+	}
+	return result(L, c.Put(key, data, contentType))
+}
+
+// Takes an object key. Returns the data, or nil and an error string,
+// which is "not found" if the object does not exist.
+func s3Get(L *lua.LState) int {
+	c := checkS3(L) // arg 1
+	data, err := c.Get(L.CheckString(2))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2 // number of results
+	}
+	L.Push(lua.LString(data))
+	return 1 // number of results
+}
+
+// Takes an object key. Returns true, or false and an error string.
+func s3Delete(L *lua.LState) int {
+	c := checkS3(L) // arg 1
+	return result(L, c.Delete(L.CheckString(2)))
+}
+
+// Takes an object key and the number of seconds the URL should be valid.
+// Returns an URL for getting the object without credentials.
+func s3Presign(L *lua.LState) int {
+	c := checkS3(L) // arg 1
+	key := L.CheckString(2)
+	seconds := L.CheckInt(3)
+	if seconds <= 0 || seconds > maxPresignSeconds {
+		L.ArgError(3, "the number of seconds must be from 1 to 604800 (7 days)")
+		return 0 // number of results
+	}
+	L.Push(lua.LString(c.Presign(key, time.Duration(seconds)*time.Second)))
+	return 1 // number of results
+}
+
+// String representation
+func s3String(L *lua.LState) int {
+	c := checkS3(L) // arg 1
+	L.Push(lua.LString("S3 bucket " + c.bucket + " at " + c.endpoint.String()))
+	return 1 // number of results
+}
+
+// The S3 methods that are to be registered
+var s3Methods = map[string]lua.LGFunction{
+	"__tostring": s3String,
+	"put":        s3Put,
+	"get":        s3Get,
+	"delete":     s3Delete,
+	"presign":    s3Presign,
+}
+
+// Load makes functions related to S3 compatible object storage available to the given Lua state
+func Load(L *lua.LState) {
+
+	// Register the S3 class and the methods that belongs with it.
+	mt := L.NewTypeMetatable(Class)
+	mt.RawSetH(lua.LString("__index"), mt)
+	L.SetFuncs(mt, s3Methods)
+
+	// The constructor takes an endpoint, an access key, a secret key, a bucket
+	// name and an optional table with "region" and "path_style" fields.
+	// Returns nil and an error string on failure.
+	L.SetGlobal("S3", L.NewFunction(func(L *lua.LState) int {
+		c, err := New(L.CheckString(1), L.CheckString(2), L.CheckString(3), L.CheckString(4))
+		if err != nil {
+			log.Error(err)
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // number of results
+		}
+		if options := L.OptTable(5, nil); options != nil {
+			if region, ok := options.RawGetString("region").(lua.LString); ok {
+				c.SetRegion(string(region))
+			}
+			if pathStyle, ok := options.RawGetString("path_style").(lua.LBool); ok {
+				c.SetPathStyle(bool(pathStyle))
+			}
+		}
+		ud := L.NewUserData()
+		ud.Value = c
+		L.SetMetatable(ud, L.GetTypeMetatable(Class))
+		L.Push(ud)
+		return 1 // number of results
+	}))
+
+}
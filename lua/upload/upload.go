@@ -3,13 +3,20 @@ package upload
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/textproto"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/xyproto/algernon/utils"
@@ -31,47 +38,141 @@ const (
 	// Chunk size when reading uploaded file
 	chunkSize int64 = 4 * utils.KiB
 	//chunkSize = defaultMemoryLimit
+
+	// Number of bytes that are considered when sniffing the content type
+	sniffLength = 512
+
+	// Allowance for multipart headers and other form fields,
+	// when limiting the size of the request body
+	formOverhead int64 = 64 * utils.KiB
 )
 
+var (
+	// ErrTooLarge is used when the uploaded data exceeds the upload limit
+	ErrTooLarge = errors.New("too large")
+
+	// ErrNoSuchField is used when there is no uploaded file for the given form ID
+	ErrNoSuchField = errors.New("no such form field")
+)
+
+// Preferred filename extensions for common detected mime types.
+// mime.ExtensionsByType is used as a fallback.
+var preferredExtensions = map[string]string{
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/bmp":        ".bmp",
+	"image/x-icon":     ".ico",
+	"image/svg+xml":    ".svg",
+	"application/pdf":  ".pdf",
+	"application/zip":  ".zip",
+	"application/ogg":  ".ogg",
+	"audio/mpeg":       ".mp3",
+	"audio/wave":       ".wav",
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"text/plain":       ".txt",
+	"text/html":        ".html",
+	"text/xml":         ".xml",
+	"application/json": ".json",
+}
+
 // UploadedFile represents a file that has been uploaded but not yet been
-// written to file.
+// written to file. The data is either in memory, or in a file that has
+// already been received.
 type UploadedFile struct {
 	req       *http.Request
 	scriptdir string
 	header    textproto.MIMEHeader
 	filename  string
-	buf       *bytes.Buffer
+	buf       *bytes.Buffer // the data, if it is in memory
+	path      string        // the file with the data, if it is not in memory
+	size      int64
+	sha256sum string // hex encoded, computed while receiving the data
+	md5sum    string // hex encoded, computed while receiving the data
 }
 
-// New creates a struct that is used for accepting an uploaded file
-//
-// The client will send all the data, if the data is over the given size,
-// if the Content-Length is wrongly set to a value below the the uploadLimit.
-// However, the buffer and memory usage will not grow despite this.
-//
-// uploadLimit is in bytes.
-//
-// Note that the client may appear to keep sending the file even when the
-// server has stopped receiving it, for files that are too large.
-func New(req *http.Request, scriptdir, formID string, uploadLimit int64) (*UploadedFile, error) {
+// FilePart is information about an uploaded file in a multipart form
+type FilePart struct {
+	Field    string // the name of the form field
+	Filename string // the filename, as given by the client
+	Size     int64
+	MimeType string // the mime type, as given by the client
+}
 
-	clientLengthTotal, err := strconv.Atoi(req.Header.Get("Content-Length"))
-	if err != nil {
-		log.Error("Invalid Content-Length: ", req.Header.Get("Content-Length"))
+// parseMultipartForm parses the multipart form in the request body, if it
+// has not already been parsed. The body is limited to the given uploadLimit
+// (+ a small allowance for the multipart headers). The returned error message
+// starts with "too large" if the body is too large.
+func parseMultipartForm(w http.ResponseWriter, req *http.Request, uploadLimit int64) error {
+	if req.MultipartForm != nil {
+		return nil
 	}
-	// Remove the extra 20 bytes and convert to int64
-	clientLength := int64(clientLengthTotal - 20)
 
-	if clientLength > uploadLimit {
-		return nil, fmt.Errorf("Uploaded file was too large: %s according to Content-Length (current limit is %s)", utils.DescribeBytes(clientLength), utils.DescribeBytes(uploadLimit))
+	// Reject the upload early, if the client says it is too large
+	if req.ContentLength > uploadLimit+formOverhead {
+		return fmt.Errorf("%s: %s according to Content-Length (the limit is %s)", ErrTooLarge, utils.DescribeBytes(req.ContentLength), utils.DescribeBytes(uploadLimit))
 	}
 
+	// Stop reading the body if the client sends more data than it should
+	req.Body = http.MaxBytesReader(w, req.Body, uploadLimit+formOverhead)
+
 	// For specifying the memory usage when uploading
 	if errMem := req.ParseMultipartForm(defaultMemoryLimit); errMem != nil {
-		return nil, errMem
+		if strings.Contains(errMem.Error(), "request body too large") {
+			return fmt.Errorf("%s: the limit is %s", ErrTooLarge, utils.DescribeBytes(uploadLimit))
+		}
+		return errMem
+	}
+	return nil
+}
+
+// FileParts returns information about all the uploaded files in the
+// multipart form in the request body, sorted by field name. Several files
+// may have the same field name. The request body is limited in the same way
+// as for New, and the files can be received with New afterwards.
+func FileParts(w http.ResponseWriter, req *http.Request, uploadLimit int64) ([]FilePart, error) {
+	if err := parseMultipartForm(w, req, uploadLimit); err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(req.MultipartForm.File))
+	for field := range req.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var parts []FilePart
+	for _, field := range fields {
+		for _, fh := range req.MultipartForm.File[field] {
+			parts = append(parts, FilePart{
+				Field:    field,
+				Filename: fh.Filename,
+				Size:     fh.Size,
+				MimeType: fh.Header.Get("Content-Type"),
+			})
+		}
+	}
+	return parts, nil
+}
+
+// New creates a struct that is used for accepting an uploaded file
+//
+// The request body is limited with http.MaxBytesReader, so that an upload
+// that is larger than the given uploadLimit (+ a small allowance for the
+// multipart headers) is rejected before it is read into memory.
+//
+// uploadLimit is in bytes.
+//
+// The returned error message starts with "too large" if the upload is too
+// large, or with "no such form field" if there is no file for the formID.
+func New(w http.ResponseWriter, req *http.Request, scriptdir, formID string, uploadLimit int64) (*UploadedFile, error) {
+	if err := parseMultipartForm(w, req, uploadLimit); err != nil {
+		return nil, err
 	}
 	file, handler, err := req.FormFile(formID)
-	if err != nil {
+	if err == http.ErrMissingFile {
+		return nil, fmt.Errorf("%s: %s", ErrNoSuchField, formID)
+	} else if err != nil {
 		return nil, err
 	}
 	defer file.Close()
@@ -79,14 +180,19 @@ func New(req *http.Request, scriptdir, formID string, uploadLimit int64) (*Uploa
 	// Store the data in a buffer, for later usage.
 	buf := new(bytes.Buffer)
 
+	// Hash the data while it is being received
+	sha256hash := sha256.New()
+	md5hash := md5.New()
+	mw := io.MultiWriter(buf, sha256hash, md5hash)
+
 	// Read the data in chunks
 	var totalWritten, writtenBytes, i int64
-	for i = 0; i < int64(uploadLimit); i += chunkSize {
-		writtenBytes, err = io.CopyN(buf, file, chunkSize)
+	for i = 0; i <= uploadLimit; i += chunkSize {
+		writtenBytes, err = io.CopyN(mw, file, chunkSize)
 		totalWritten += writtenBytes
 		if totalWritten > uploadLimit {
 			// File too large
-			return nil, fmt.Errorf("Uploaded file was too large: %d bytes (limit is %d bytes)", totalWritten, uploadLimit)
+			return nil, fmt.Errorf("%s: %d bytes (the limit is %d bytes)", ErrTooLarge, totalWritten, uploadLimit)
 		} else if writtenBytes < chunkSize || err == io.EOF {
 			// Done writing
 			break
@@ -97,7 +203,89 @@ func New(req *http.Request, scriptdir, formID string, uploadLimit int64) (*Uploa
 	}
 
 	// all ok
-	return &UploadedFile{req, scriptdir, handler.Header, handler.Filename, buf}, nil
+	return &UploadedFile{
+		req:       req,
+		scriptdir: scriptdir,
+		header:    handler.Header,
+		filename:  handler.Filename,
+		buf:       buf,
+		size:      int64(buf.Len()),
+		sha256sum: hex.EncodeToString(sha256hash.Sum(nil)),
+		md5sum:    hex.EncodeToString(md5hash.Sum(nil)),
+	}, nil
+}
+
+// FromFile creates an UploadedFile from a file that has already been received,
+// for instance by a resumable upload. clientFilename is the filename, as
+// given by the client, and mimeType is the mime type as given by the client.
+// The data is not read into memory, but read from the file when it is needed.
+func FromFile(req *http.Request, scriptdir, filename, clientFilename, mimeType string) (*UploadedFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Hash the data, without keeping it
+	sha256hash := sha256.New()
+	md5hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(sha256hash, md5hash), file)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(textproto.MIMEHeader)
+	if mimeType != "" {
+		header.Set("Content-Type", mimeType)
+	}
+
+	return &UploadedFile{
+		req:       req,
+		scriptdir: scriptdir,
+		header:    header,
+		filename:  clientFilename,
+		path:      filename,
+		size:      size,
+		sha256sum: hex.EncodeToString(sha256hash.Sum(nil)),
+		md5sum:    hex.EncodeToString(md5hash.Sum(nil)),
+	}, nil
+}
+
+// Bytes returns the uploaded data. Data that is not in memory is read from
+// the file, and nil is returned if that fails.
+func (ulf *UploadedFile) Bytes() []byte {
+	if ulf.buf == nil {
+		data, err := ioutil.ReadFile(ulf.path)
+		if err != nil {
+			log.Error(err)
+			return nil
+		}
+		return data
+	}
+	return ulf.buf.Bytes()
+}
+
+// Size returns the size of the uploaded data, in bytes
+func (ulf *UploadedFile) Size() int64 {
+	return ulf.size
+}
+
+// open returns a reader for the uploaded data, which must be closed
+func (ulf *UploadedFile) open() (io.ReadCloser, error) {
+	if ulf.buf == nil {
+		return os.Open(ulf.path)
+	}
+	return ioutil.NopCloser(bytes.NewReader(ulf.buf.Bytes())), nil
+}
+
+// MimeType returns the mime type, as given by the client
+func (ulf *UploadedFile) MimeType() string {
+	if contentTypes, ok := ulf.header["Content-Type"]; ok {
+		if len(contentTypes) > 0 {
+			return contentTypes[0]
+		}
+	}
+	return ""
 }
 
 // Get the first argument, "self", and cast it from userdata to
@@ -112,9 +300,9 @@ func checkUploadedFile(L *lua.LState) *UploadedFile {
 }
 
 // Create a new Upload file
-func constructUploadedFile(L *lua.LState, req *http.Request, scriptdir, formID string, uploadLimit int64) (*lua.LUserData, error) {
+func constructUploadedFile(L *lua.LState, w http.ResponseWriter, req *http.Request, scriptdir, formID string, uploadLimit int64) (*lua.LUserData, error) {
 	// Create a new UploadedFile
-	uploadedfile, err := New(req, scriptdir, formID, uploadLimit)
+	uploadedfile, err := New(w, req, scriptdir, formID, uploadLimit)
 	if err != nil {
 		return nil, err
 	}
@@ -141,23 +329,78 @@ func uploadedfileName(L *lua.LState) int {
 // File size
 func uploadedfileSize(L *lua.LState) int {
 	ulf := checkUploadedFile(L) // arg 1
-	L.Push(lua.LNumber(ulf.buf.Len()))
+	L.Push(lua.LNumber(ulf.Size()))
 	return 1 // number of results
 }
 
 // Mime type
 func uploadedfileMimeType(L *lua.LState) int {
 	ulf := checkUploadedFile(L) // arg 1
-	contentType := ""
-	if contentTypes, ok := ulf.header["Content-Type"]; ok {
-		if len(contentTypes) > 0 {
-			contentType = contentTypes[0]
-		}
-	}
-	L.Push(lua.LString(contentType))
+	L.Push(lua.LString(ulf.MimeType()))
+	return 1 // number of results
+}
+
+// Detected mime type, by looking at the first bytes of the uploaded data
+func uploadedfileDetectedType(L *lua.LState) int {
+	ulf := checkUploadedFile(L) // arg 1
+	L.Push(lua.LString(ulf.detectedType()))
+	return 1 // number of results
+}
+
+// Filename extension (including the leading ".") for the detected mime type.
+// Returns an empty string if no extension is known for the detected type.
+func uploadedfileExtension(L *lua.LState) int {
+	ulf := checkUploadedFile(L) // arg 1
+	L.Push(lua.LString(extensionByType(ulf.detectedType())))
+	return 1 // number of results
+}
+
+// SHA-256 checksum of the uploaded data, as a hex encoded string
+func uploadedfileSHA256(L *lua.LState) int {
+	ulf := checkUploadedFile(L) // arg 1
+	L.Push(lua.LString(ulf.sha256sum))
+	return 1 // number of results
+}
+
+// MD5 checksum of the uploaded data, as a hex encoded string
+func uploadedfileMD5(L *lua.LState) int {
+	ulf := checkUploadedFile(L) // arg 1
+	L.Push(lua.LString(ulf.md5sum))
 	return 1 // number of results
 }
 
+// detectedType sniffs the uploaded data with http.DetectContentType.
+// Parameters like "; charset=utf-8" are stripped from the returned mime type.
+func (ulf *UploadedFile) detectedType() string {
+	r, err := ulf.open()
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer r.Close()
+	data := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "application/octet-stream"
+	}
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(data[:n]))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mimeType
+}
+
+// extensionByType returns a filename extension for the given mime type,
+// or an empty string if none is known.
+func extensionByType(mimeType string) string {
+	if ext, ok := preferredExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return strings.ToLower(exts[0])
+	}
+	return ""
+}
+
 // Write the uploaded file to the given full filename.
 // Does not overwrite files.
 func (ulf *UploadedFile) write(fullFilename string, fperm os.FileMode) error {
@@ -173,9 +416,13 @@ func (ulf *UploadedFile) write(fullFilename string, fperm os.FileMode) error {
 		return err
 	}
 	defer f.Close()
-	// Copy the data to a new buffer, to keep the data and the length
-	fileDataBuffer := bytes.NewBuffer(ulf.buf.Bytes())
-	if _, err := io.Copy(f, fileDataBuffer); err != nil {
+	r, err := ulf.open()
+	if err != nil {
+		log.Error("Error when reading the uploaded data: " + err.Error())
+		return err
+	}
+	defer r.Close()
+	if _, err := io.Copy(f, r); err != nil {
 		log.Error("Error when writing: " + err.Error())
 		return err
 	}
@@ -237,12 +484,16 @@ func uploadedfileSaveIn(L *lua.LState) int {
 
 // The hash map methods that are to be registered
 var uploadedfileMethods = map[string]lua.LGFunction{
-	"__tostring": uploadedfileToString,
-	"filename":   uploadedfileName,
-	"size":       uploadedfileSize,
-	"mimetype":   uploadedfileMimeType,
-	"save":       uploadedfileSave,
-	"savein":     uploadedfileSaveIn,
+	"__tostring":   uploadedfileToString,
+	"filename":     uploadedfileName,
+	"size":         uploadedfileSize,
+	"mimetype":     uploadedfileMimeType,
+	"detectedtype": uploadedfileDetectedType,
+	"extension":    uploadedfileExtension,
+	"sha256":       uploadedfileSHA256,
+	"md5":          uploadedfileMD5,
+	"save":         uploadedfileSave,
+	"savein":       uploadedfileSaveIn,
 }
 
 // Load makes functions related to saving an uploaded file available
@@ -267,7 +518,7 @@ func Load(L *lua.LState, w http.ResponseWriter, req *http.Request, scriptdir str
 			uploadLimit = int64(L.ToInt(2)) * utils.MiB // optional upload limit, in MiB
 		}
 		// Construct a new UploadedFile
-		userdata, err := constructUploadedFile(L, req, scriptdir, formID, uploadLimit)
+		userdata, err := constructUploadedFile(L, w, req, scriptdir, formID, uploadLimit)
 		if err != nil {
 			// Log the error
 			log.Error(err)
@@ -285,4 +536,34 @@ func Load(L *lua.LState, w http.ResponseWriter, req *http.Request, scriptdir str
 		return 2 // Number of returned values
 	}))
 
+	// Return a list of tables with information about all the uploaded files
+	// in the form: "field", "filename", "size" and "mimetype". Takes an
+	// optional upload limit in MiB. Returns the table and an empty string on
+	// success. Returns nil and an error message on failure.
+	L.SetGlobal("formfiles", L.NewFunction(func(L *lua.LState) int {
+		uploadLimit := defaultUploadLimit
+		if L.GetTop() >= 1 {
+			uploadLimit = int64(L.CheckInt(1)) * utils.MiB // optional upload limit, in MiB
+		}
+		parts, err := FileParts(w, req, uploadLimit)
+		if err != nil {
+			log.Error(err)
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2 // Number of returned values
+		}
+		table := L.NewTable()
+		for _, part := range parts {
+			partTable := L.NewTable()
+			partTable.RawSetString("field", lua.LString(part.Field))
+			partTable.RawSetString("filename", lua.LString(part.Filename))
+			partTable.RawSetString("size", lua.LNumber(part.Size))
+			partTable.RawSetString("mimetype", lua.LString(part.MimeType))
+			table.Append(partTable)
+		}
+		L.Push(table)
+		L.Push(lua.LString(""))
+		return 2 // Number of returned values
+	}))
+
 }
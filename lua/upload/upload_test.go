@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func uploadRequest(t *testing.T, fieldName string, data []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(fieldName, "test.bin")
+	assert.Equal(t, err, nil)
+	fw.Write(data)
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploadOK(t *testing.T) {
+	req := uploadRequest(t, "file", []byte("hello"))
+	ulf, err := New(httptest.NewRecorder(), req, ".", "file", 1024)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ulf.buf.String(), "hello")
+	assert.Equal(t, ulf.sha256sum, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	assert.Equal(t, ulf.md5sum, "5d41402abc4b2a76b9719d911017c592")
+	assert.Equal(t, ulf.detectedType(), "text/plain")
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	req := uploadRequest(t, "file", bytes.Repeat([]byte("x"), 8192))
+	_, err := New(httptest.NewRecorder(), req, ".", "file", 1024)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, strings.HasPrefix(err.Error(), ErrTooLarge.Error()), true)
+}
+
+func TestUploadNoSuchField(t *testing.T) {
+	req := uploadRequest(t, "file", []byte("hello"))
+	_, err := New(httptest.NewRecorder(), req, ".", "other", 1024)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, strings.HasPrefix(err.Error(), ErrNoSuchField.Error()), true)
+}
+
+func TestFileParts(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		fw, err := mw.CreateFormFile("files", filename)
+		assert.Equal(t, err, nil)
+		fw.Write([]byte("hello"))
+	}
+	mw.WriteField("name", "value")
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	parts, err := FileParts(httptest.NewRecorder(), req, 1024)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(parts), 2)
+	assert.Equal(t, parts[0].Field, "files")
+	assert.Equal(t, parts[1].Filename, "b.txt")
+	assert.Equal(t, parts[1].Size, int64(5))
+	_, err = New(httptest.NewRecorder(), req, ".", "files", 1024)
+	assert.Equal(t, err, nil)
+}
+
+func TestFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "received")
+	assert.Equal(t, ioutil.WriteFile(filename, []byte("hello"), 0600), nil)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	ulf, err := FromFile(req, dir, filename, "hello.txt", "text/plain")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ulf.buf == nil, true)
+	assert.Equal(t, ulf.Size(), int64(5))
+	assert.Equal(t, ulf.sha256sum, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	assert.Equal(t, ulf.detectedType(), "text/plain")
+	assert.Equal(t, ulf.write(filepath.Join(dir, "saved"), 0600), nil)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "saved"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), "hello")
+}
@@ -1,8 +1,8 @@
-// +build !darwin,!dragonfly,!freebsd,!linux,!nacl,!netbsd,!openbsd,!solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!nacl,!netbsd,!openbsd,!solaris,!windows
 
 package platformdep
 
-// UNIX-like systems uses logo_unix.go instead.
+// UNIX-like systems uses logo_unix.go and Windows uses logo_windows.go instead.
 
 // Banner return the server name, version number and description
 func Banner(versionString, description string) string {
@@ -0,0 +1,32 @@
+// +build windows
+
+package platformdep
+
+import (
+	"os"
+	"strings"
+)
+
+// supportsANSI checks if the terminal is likely to support ANSI escape
+// sequences, like Windows Terminal, mintty, ConEmu and ANSICON do
+func supportsANSI() bool {
+	if os.Getenv("WT_SESSION") != "" || os.Getenv("ANSICON") != "" {
+		return true
+	}
+	if strings.EqualFold(os.Getenv("ConEmuANSI"), "ON") {
+		return true
+	}
+	// mintty, MSYS2 and Cygwin set TERM
+	term := os.Getenv("TERM")
+	return term != "" && term != "dumb"
+}
+
+// Banner returns ANSI graphics with the current version number embedded in the
+// text, if the terminal supports it, and the server name, version number and
+// description as plain text otherwise
+func Banner(versionString, description string) string {
+	if !supportsANSI() {
+		return "\n" + versionString + "\n" + description
+	}
+	return CustomBanner(decompressImage(image), versionString, description)
+}
@@ -0,0 +1,12 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!nacl,!netbsd,!openbsd,!solaris
+
+package platformdep
+
+import "os"
+
+// UNIX-like systems uses signal_unix.go instead.
+
+// Signal returns false, since there are no user-defined signals on this platform
+func Signal(name string) (os.Signal, bool) {
+	return nil, false
+}
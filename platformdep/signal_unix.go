@@ -0,0 +1,21 @@
+// +build darwin dragonfly freebsd linux nacl netbsd openbsd solaris
+
+package platformdep
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signal returns the signal with the given name, like "USR1", if it exists
+func Signal(name string) (os.Signal, bool) {
+	switch name {
+	case "HUP":
+		return syscall.SIGHUP, true
+	case "USR1":
+		return syscall.SIGUSR1, true
+	case "USR2":
+		return syscall.SIGUSR2, true
+	}
+	return nil, false
+}
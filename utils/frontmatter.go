@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// SplitFrontMatter separates the front matter at the start of the given data
+// from the rest of it. The front matter can be YAML between two "---" lines,
+// or TOML between two "+++" lines. If there is no front matter, nil and the
+// unmodified data is returned.
+func SplitFrontMatter(data []byte) (map[string]interface{}, []byte, error) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) == 0 {
+		return nil, data, nil
+	}
+	delimiter := string(bytes.TrimSpace(lines[0]))
+	if delimiter != "---" && delimiter != "+++" {
+		return nil, data, nil
+	}
+	offset := len(lines[0])
+	for _, line := range lines[1:] {
+		trimmed := string(bytes.TrimSpace(line))
+		if trimmed != delimiter && !(delimiter == "---" && trimmed == "...") {
+			offset += len(line)
+			continue
+		}
+		header := data[len(lines[0]):offset]
+		body := data[offset+len(line):]
+		meta := make(map[string]interface{})
+		if delimiter == "+++" {
+			if _, err := toml.Decode(string(header), &meta); err != nil {
+				return nil, data, fmt.Errorf("invalid TOML front matter: %s", err)
+			}
+			return meta, body, nil
+		}
+		if err := yaml.Unmarshal(header, &meta); err != nil {
+			return nil, data, fmt.Errorf("invalid YAML front matter: %s", err)
+		}
+		for key, value := range meta {
+			meta[key] = stringKeys(value)
+		}
+		return meta, body, nil
+	}
+	// No closing delimiter, so this is not front matter
+	return nil, data, nil
+}
+
+// stringKeys converts the maps that YAML decodes to, recursively, to maps
+// with string keys, so that they can be used in templates
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			m[fmt.Sprintf("%v", key)] = stringKeys(element)
+		}
+		return m
+	case []interface{}:
+		for i, element := range v {
+			v[i] = stringKeys(element)
+		}
+		return v
+	}
+	return value
+}
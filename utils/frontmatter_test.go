@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	meta, body, err := SplitFrontMatter([]byte("---\ntitle: Hello\ntags: [a, b]\n---\n# Headline\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, meta["title"], "Hello")
+	assert.Equal(t, len(meta["tags"].([]interface{})), 2)
+	assert.Equal(t, string(body), "# Headline\n")
+
+	meta, body, err = SplitFrontMatter([]byte("+++\ntitle = \"Hello\"\n+++\ntext"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, meta["title"], "Hello")
+	assert.Equal(t, string(body), "text")
+
+	meta, body, err = SplitFrontMatter([]byte("# Headline\n---\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(meta), 0)
+	assert.Equal(t, string(body), "# Headline\n---\n")
+}